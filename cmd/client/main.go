@@ -1,20 +1,51 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
 
+	"github.com/evilaffliction/merkle/pkg/algo/merkle/impl"
+	"github.com/evilaffliction/merkle/pkg/client"
 	"github.com/evilaffliction/merkle/pkg/middleware"
 	"github.com/evilaffliction/merkle/pkg/rest"
 )
 
 const version = 0
 
+// progressBarWidth is a number of characters in a progress bar
+const progressBarWidth = 40
+
 type clientConfig struct {
-	host      string
-	port      int
-	quotesNum int
+	host         string
+	port         int
+	quotesNum    int
+	hashName     string
+	timeout      time.Duration
+	streaming    bool
+	showProgress bool
+	binary       bool
+	multiProof   bool
+	challenge    bool
+	poolSize     int
+}
+
+// printProgress draws a progress bar of a merkle tree build in a terminal
+func printProgress(hashed int, total int) {
+	if total == 0 {
+		return
+	}
+	done := hashed * progressBarWidth / total
+	fmt.Fprintf(os.Stderr, "\r[%s%s] %3d%%",
+		strings.Repeat("=", done), strings.Repeat(" ", progressBarWidth-done), hashed*100/total)
+	if hashed == total {
+		fmt.Fprintln(os.Stderr)
+	}
 }
 
 func main() {
@@ -22,33 +53,88 @@ func main() {
 	flag.StringVar(&clientConfig.host, "host", "localhost", "server's host")
 	flag.IntVar(&clientConfig.port, "port", 8080, "server's port")
 	flag.IntVar(&clientConfig.quotesNum, "n", 1, "quotes number to extract")
+	flag.StringVar(&clientConfig.hashName, "hash", "md5", "hash function to build proof of work with")
+	flag.DurationVar(&clientConfig.timeout, "timeout", 0, "timeout for every quote, no timeout if 0")
+	flag.BoolVar(&clientConfig.streaming, "streaming", false, "build proof of work with O(depth) memory")
+	flag.BoolVar(&clientConfig.showProgress, "progress", true, "show progress of proof of work generation")
+	flag.BoolVar(&clientConfig.binary, "binary", false, "send proof of work in a compact binary encoding")
+	flag.BoolVar(&clientConfig.multiProof, "multiproof", false, "send proof of work as a deduplicated multiproof")
+	flag.BoolVar(&clientConfig.challenge, "challenge", false, "build proof of work for a server-issued challenge")
+	flag.IntVar(&clientConfig.poolSize, "pool", 0, "number of proofs of work to mine ahead of time, no mining ahead if 0")
 	flag.Parse()
+	if clientConfig.challenge && clientConfig.poolSize > 0 {
+		// proofs of work of a pool are built for client-chosen access tokens, a server rejects them for challenges
+		fmt.Fprintln(os.Stderr, "-pool can not be combined with -challenge")
+		os.Exit(2)
+	}
+
+	// stop proof of work generation on interruption
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var headerOpts []middleware.HeaderOption
+	if clientConfig.streaming {
+		headerOpts = append(headerOpts, middleware.WithTreeOptions(impl.WithStreamingMode()))
+	}
+	if clientConfig.binary {
+		headerOpts = append(headerOpts, middleware.WithBinaryEncoding())
+	}
+	if clientConfig.multiProof {
+		headerOpts = append(headerOpts, middleware.WithMultiProof())
+	}
+	if clientConfig.showProgress {
+		headerOpts = append(headerOpts, middleware.WithProgress(printProgress))
+	}
 
-	httpClient := &http.Client{}
 	quoteURL := fmt.Sprintf("http://%s:%d/v%d/quote", clientConfig.host, clientConfig.port, version)
+	clientOpts := []client.Option{
+		client.WithHash(clientConfig.hashName),
+		client.WithHeaderOptions(headerOpts...),
+	}
+	if clientConfig.challenge {
+		challengeURL := fmt.Sprintf("http://%s:%d/v%d/challenge", clientConfig.host, clientConfig.port, version)
+		clientOpts = append(clientOpts, client.WithChallengeURL(challengeURL))
+	}
+	if clientConfig.poolSize > 0 {
+		pool := client.NewPool(append(clientOpts, client.WithPoolSize(clientConfig.poolSize))...)
+		defer pool.Close()
+		clientOpts = append(clientOpts, client.WithPool(pool))
+	}
+	httpClient := client.NewHTTPClient(clientOpts...)
 
 	for i := 0; i < clientConfig.quotesNum; i++ {
-		merkleHeaderPayload, err := middleware.GenerateMerkleHeader(20, 5, "md5")
+		quote, err := getQuote(ctx, httpClient, quoteURL, clientConfig.timeout)
 		if err != nil {
-			panic(fmt.Errorf("failed to generate proof of work for a server, error: %w", err))
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
 
-		req, err := http.NewRequest("GET", quoteURL, nil)
-		if err != nil {
-			panic(fmt.Errorf("failed to create http request, error: %w", err))
-		}
-		req.Header.Set(middleware.MerkleHeaderName, merkleHeaderPayload)
+		fmt.Printf("%v\n", quote)
+	}
+}
 
-		resp, err := httpClient.Do(req)
-		if err != nil {
-			panic(fmt.Errorf("failed to get a random quote from %q, error: %w", quoteURL, err))
-		}
+// getQuote requests a quote, the client's transport attaches a proof of work to the request
+func getQuote(ctx context.Context, httpClient *http.Client, quoteURL string, timeout time.Duration) (string, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 
-		var quote string
-		if err := rest.ReadResponse(resp, &quote); err != nil {
-			panic(fmt.Errorf("failed to read response, error: %w", err))
-		}
+	req, err := http.NewRequestWithContext(ctx, "GET", quoteURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create http request, error: %w", err)
+	}
 
-		fmt.Printf("%v\n", quote)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get a random quote from %q, error: %w", quoteURL, err)
+	}
+	defer resp.Body.Close()
+
+	var quote string
+	if err := rest.ReadResponse(resp, &quote); err != nil {
+		return "", fmt.Errorf("failed to read response, error: %w", err)
 	}
+	return quote, nil
 }
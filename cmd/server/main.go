@@ -6,6 +6,8 @@ import (
 	"math/rand/v2"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
@@ -17,8 +19,15 @@ import (
 const version = 0
 
 type serverConfig struct {
-	dataFolder string
-	port       int
+	dataFolder      string
+	port            int
+	challengeSecret string
+	overloadRate    float64
+	reputation      bool
+	debugErrors     bool
+	redisAddress    string
+	sessionKeys     string
+	sessionMinDepth int
 }
 
 func main() {
@@ -26,8 +35,23 @@ func main() {
 	var serverConfig serverConfig
 	flag.StringVar(&serverConfig.dataFolder, "data-folder", "/data/", "folder with quotes to be served")
 	flag.IntVar(&serverConfig.port, "port", 8080, "port at which requests will be served")
+	flag.StringVar(&serverConfig.challengeSecret, "challenge-secret", "",
+		"secret to sign server-issued challenges with, challenges are disabled if empty")
+	flag.Float64Var(&serverConfig.overloadRate, "overload-rate", 0,
+		"requests per second that make min difficulty to be raised, adaptive difficulty is disabled if 0")
+	flag.BoolVar(&serverConfig.reputation, "client-reputation", false,
+		"raise difficulty for clients that send many requests or invalid proofs of work")
+	flag.BoolVar(&serverConfig.debugErrors, "debug-errors", false,
+		"include detailed reasons into rejections, they disclose internals of a verification")
+	flag.StringVar(&serverConfig.redisAddress, "redis-address", "",
+		"address of a redis server to share used access tokens between replicas, an in-process cache is used if empty")
+	flag.StringVar(&serverConfig.sessionKeys, "session-keys", "",
+		"comma separated id:secret keys to sign session tokens, the first one is current, sessions are disabled if empty")
+	flag.IntVar(&serverConfig.sessionMinDepth, "session-min-depth", 0,
+		"min depth of a proof of work that buys a session token, it is required with session keys")
 	flag.Parse()
-	fmt.Printf("server config: %v\n", serverConfig)
+	fmt.Printf("server config: data folder %q, port %d, challenges enabled: %t\n",
+		serverConfig.dataFolder, serverConfig.port, serverConfig.challengeSecret != "")
 
 	// building quote manager that will contain all the data
 	quoteManager := quote.NewInMemoryManagerImpl(rand.New(rand.NewChaCha8([32]byte([]byte("ABCDEFGHIJKLMNOPQRSTUVWXYZ123456")))))
@@ -52,13 +76,58 @@ func main() {
 
 	r := gin.New()
 	r.Use(gin.Recovery())
-	r.Use(middleware.GetMerkleMiddleware())
+	metrics := middleware.NewMetrics()
+	merkleOpts := []middleware.Option{middleware.WithMetrics(metrics)}
+	if serverConfig.challengeSecret != "" {
+		merkleOpts = append(merkleOpts, middleware.WithChallengeSecret([]byte(serverConfig.challengeSecret)))
+	}
+	if serverConfig.overloadRate > 0 {
+		adaptiveDifficulty := middleware.NewAdaptiveDifficulty(
+			middleware.NewRequestMeter(10*time.Second, nil),
+			middleware.WithLoadThresholds(middleware.LoadThresholds{RequestRate: serverConfig.overloadRate}),
+		)
+		merkleOpts = append(merkleOpts, middleware.WithAdaptiveDifficulty(adaptiveDifficulty))
+	}
+	if serverConfig.reputation {
+		merkleOpts = append(merkleOpts, middleware.WithClientReputation(middleware.NewClientReputation()))
+	}
+	if serverConfig.debugErrors {
+		merkleOpts = append(merkleOpts, middleware.WithDebugErrors())
+	}
+	if serverConfig.redisAddress != "" {
+		replayStore := middleware.NewRedisReplayStore(serverConfig.redisAddress)
+		defer replayStore.Close()
+		merkleOpts = append(merkleOpts, middleware.WithReplayStore(replayStore), middleware.WithSessionStore(replayStore))
+	}
+	if serverConfig.sessionKeys != "" {
+		if serverConfig.sessionMinDepth <= 0 {
+			panic(fmt.Errorf("session-min-depth is required with session-keys"))
+		}
+		var sessionKeys []middleware.SessionKey
+		for _, key := range strings.Split(serverConfig.sessionKeys, ",") {
+			id, secret, found := strings.Cut(key, ":")
+			if !found || id == "" || secret == "" {
+				panic(fmt.Errorf("session key %q is expected to be id:secret", key))
+			}
+			sessionKeys = append(sessionKeys, middleware.SessionKey{ID: id, Secret: []byte(secret)})
+		}
+		merkleOpts = append(merkleOpts, middleware.WithSessionKeys(sessionKeys[0], sessionKeys[1:]...),
+			middleware.WithSessionMinDepth(serverConfig.sessionMinDepth))
+	}
+	merkleMiddleware := middleware.NewMerkleMiddleware(merkleOpts...)
+	if serverConfig.challengeSecret != "" {
+		// challenges are issued before any proof of work, so the route is not protected
+		r.GET(fmt.Sprintf("/v%d/challenge", version), merkleMiddleware.ChallengeHandler())
+	}
+
+	// metrics are scraped by a monitoring system, so the route is not protected
+	r.GET("/metrics", gin.WrapH(metrics))
 
 	getRandomQuote := func(_ *gin.Context) (any, error) {
 		return quoteManager.GetRandomQuote()
 	}
 
-	r.GET(fmt.Sprintf("/v%d/quote", version), rest.EndpointWrapper(getRandomQuote))
+	r.GET(fmt.Sprintf("/v%d/quote", version), merkleMiddleware.Handler(), rest.EndpointWrapper(getRandomQuote))
 	if err := r.Run(fmt.Sprintf(":%d", serverConfig.port)); err != nil {
 		panic(fmt.Errorf("failed to run web server, error: %w", err))
 	}
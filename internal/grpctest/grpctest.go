@@ -0,0 +1,47 @@
+// Package grpctest serves gRPC services over in-memory connections in tests
+package grpctest
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// Target is a target of every connection to a served service
+const Target = "bufnet"
+
+// NewHealthClient serves a health service with given server options and dials it with given dial options,
+// both the server and the connection are stopped once a test is over
+func NewHealthClient(
+	t testing.TB,
+	serverOpts []grpc.ServerOption,
+	dialOpts ...grpc.DialOption,
+) healthpb.HealthClient {
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(serverOpts...)
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	dialOpts = append([]grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+	}, dialOpts...)
+	conn, err := grpc.Dial(Target, dialOpts...)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	return healthpb.NewHealthClient(conn)
+}
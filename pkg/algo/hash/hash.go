@@ -1,57 +1,55 @@
 package hash
 
 import (
+	"bytes"
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
 	"strings"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/sha3"
 )
 
-// Value is a wrapper for 16 byte array
-type Value [16]byte
+// Value is a wrapper for a digest of an arbitrary hash function.
+// Its size depends on a Hasher that computed it
+type Value []byte
 
 // FromString gets hash value from a base64 encoded string
 func FromString(data string) (Value, error) {
-	var result Value
 	byteData, err := base64.StdEncoding.DecodeString(data)
 	if err != nil {
-		return result, fmt.Errorf("failed to decode base64 string %q, error: %w", data, err)
+		return nil, fmt.Errorf("failed to decode base64 string %q, error: %w", data, err)
 	}
 
-	if len(result) != len(byteData) {
-		return result, fmt.Errorf("base64 string %q contains %d bytes of data, expected %d",
-			data, len(byteData), len(result))
-	}
-	for i := 0; i < len(result); i++ {
-		result[i] = byteData[i]
+	if len(byteData) == 0 {
+		return nil, fmt.Errorf("base64 string %q contains no data", data)
 	}
-	return result, nil
+	return byteData, nil
 }
 
-// ToSlice converts 16 byte array to a byte slice
+// ToSlice converts a hash value to a byte slice
 func (rcv Value) ToSlice() []byte {
-	return rcv[:]
+	return rcv
 }
 
-// String Hash 16 byte array to a base64 encoded string
+// String Hash a hash value to a base64 encoded string
 func (rcv Value) String() string {
-	return base64.StdEncoding.EncodeToString(rcv[:])
+	return base64.StdEncoding.EncodeToString(rcv)
 }
 
 // EqualsTo checks for equality between a given Value and provided other Value
 func (rcv Value) EqualsTo(other Value) bool {
-	for i := 0; i < len(rcv); i++ {
-		if rcv[i] != other[i] {
-			return false
-		}
-	}
-	return true
+	return bytes.Equal(rcv, other)
 }
 
 // Hasher is an interface for a class that is capable of computing hash values
 // for an arbitrary byte array
 type Hasher interface {
 	Hash([]byte) Value
+	// Size returns a number of bytes of a computed hash value
+	Size() int
 }
 
 // MD5Hasher is a wrapper for md5 hash function
@@ -59,7 +57,58 @@ type MD5Hasher struct{}
 
 // Hash computes md5 hash
 func (rcv MD5Hasher) Hash(data []byte) Value {
-	return md5.Sum(data)
+	sum := md5.Sum(data)
+	return sum[:]
+}
+
+// Size returns md5 digest size
+func (rcv MD5Hasher) Size() int {
+	return md5.Size
+}
+
+// SHA256Hasher is a wrapper for sha256 hash function
+type SHA256Hasher struct{}
+
+// Hash computes sha256 hash
+func (rcv SHA256Hasher) Hash(data []byte) Value {
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// Size returns sha256 digest size
+func (rcv SHA256Hasher) Size() int {
+	return sha256.Size
+}
+
+// sha3Size is a digest size of sha3-256, the sha3 package has no constant for it
+var sha3Size = sha3.New256().Size()
+
+// SHA3Hasher is a wrapper for sha3-256 hash function
+type SHA3Hasher struct{}
+
+// Hash computes sha3-256 hash
+func (rcv SHA3Hasher) Hash(data []byte) Value {
+	sum := sha3.Sum256(data)
+	return sum[:]
+}
+
+// Size returns sha3-256 digest size
+func (rcv SHA3Hasher) Size() int {
+	return sha3Size
+}
+
+// BLAKE2bHasher is a wrapper for blake2b-256 hash function
+type BLAKE2bHasher struct{}
+
+// Hash computes blake2b-256 hash
+func (rcv BLAKE2bHasher) Hash(data []byte) Value {
+	sum := blake2b.Sum256(data)
+	return sum[:]
+}
+
+// Size returns blake2b-256 digest size
+func (rcv BLAKE2bHasher) Size() int {
+	return blake2b.Size256
 }
 
 type seededHasher struct {
@@ -74,6 +123,11 @@ func (rcv *seededHasher) Hash(data []byte) Value {
 	return result
 }
 
+// Size returns a digest size of an original hasher
+func (rcv *seededHasher) Size() int {
+	return rcv.originalHasher.Size()
+}
+
 // NewSeededHasher builds a "shifted" hash function out of original
 // allows you to "parametrize" you hash function computation
 func NewSeededHasher(hasher Hasher, parts ...any) Hasher {
@@ -95,21 +149,19 @@ func NewSeededHasher(hasher Hasher, parts ...any) Hasher {
 	}
 }
 
-// XORHashes computes xored hash array
+// XORHashes computes xored hash array.
+// Both values are expected to be computed by the same hasher, i.e. to have the same size
 func XORHashes(left, right Value) Value {
-	var output Value
+	output := make(Value, len(left))
 	for i := 0; i < len(output); i++ {
 		output[i] = left[i] ^ right[i]
 	}
 	return output
 }
 
+// NameToHasher returns a registered hasher by its name.
+//
+// Deprecated: use LookupHasher
 func NameToHasher(hashName string) (Hasher, error) {
-	switch hashName {
-	case "md5":
-		return MD5Hasher{}, nil
-	default:
-		return nil, fmt.Errorf("unknown hash %q", hashName)
-	}
-	// unreachable
+	return LookupHasher(hashName)
 }
@@ -1,9 +1,11 @@
 package hash
 
 import (
+	"encoding/hex"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestIntMinTableDriven(t *testing.T) {
@@ -23,3 +25,88 @@ func TestBase54Conversions(t *testing.T) {
 	assert.NoError(t, err)
 	assert.EqualValues(t, initialHash, decodedHash)
 }
+
+func TestKeyedHasher(t *testing.T) {
+	hasher := MD5Hasher{}
+	first := NewKeyedHasher(hasher, "description", 10, 3)
+	second := NewKeyedHasher(hasher, "description", 10, 4)
+	ambiguous := NewKeyedHasher(hasher, "descriptio", "n10", 3)
+
+	assert.EqualValues(t, first.HashLeaf(42), NewKeyedHasher(hasher, "description", 10, 3).HashLeaf(42))
+	assert.NotEqualValues(t, first.HashLeaf(42), first.HashLeaf(43))
+	assert.NotEqualValues(t, first.HashLeaf(42), second.HashLeaf(42))
+	assert.NotEqualValues(t, first.HashLeaf(42), ambiguous.HashLeaf(42))
+
+	left, right := first.HashLeaf(3), first.HashLeaf(4)
+	assert.NotEqualValues(t, first.HashNode(1, left, right), first.HashNode(2, left, right))
+	assert.NotEqualValues(t, first.HashNode(1, left, right), first.HashNode(1, right, left))
+
+	root := first.HashNode(0, left, right)
+	assert.NotEqualValues(t, first.HashSelection(root, 0), first.HashSelection(root, 1))
+	assert.NotEqualValues(t, first.HashSelection(root, 0), second.HashSelection(root, 0))
+
+	// a key can not be factored out as it happens with a seeded hasher
+	keyDiff := XORHashes(first.HashLeaf(42), second.HashLeaf(42))
+	assert.NotEqualValues(t, keyDiff, XORHashes(first.HashLeaf(43), second.HashLeaf(43)))
+
+	t.Run("Test_vector", func(t *testing.T) {
+		// the vector is documented in README for implementations in other languages
+		keyed := NewKeyedHasher(SHA256Hasher{}, "abc", 10, 3)
+		assert.Equal(t, "28833a33940b3067a11c0cb39f2978cb195472ca305f7c611f8e2e38ee774a31", hex.EncodeToString(keyed.key))
+		assert.Equal(t, "1e34f89c2868feb7d0da81baf2d9f8b371025a1834525103b4a2a9cf42ff0d62",
+			hex.EncodeToString(keyed.HashLeaf(3)))
+	})
+}
+
+type reversedHasher struct{}
+
+func (rcv reversedHasher) Hash(data []byte) Value {
+	sum := SHA256Hasher{}.Hash(data)
+	for i, j := 0, len(sum)-1; i < j; i, j = i+1, j-1 {
+		sum[i], sum[j] = sum[j], sum[i]
+	}
+	return sum
+}
+
+func (rcv reversedHasher) Size() int {
+	return SHA256Hasher{}.Size()
+}
+
+func TestRegistry(t *testing.T) {
+	for name, expectedSize := range map[string]int{
+		MD5Name:     16,
+		SHA256Name:  32,
+		SHA3Name:    32,
+		BLAKE2bName: 32,
+	} {
+		hasher, err := LookupHasher(name)
+		require.NoError(t, err)
+		assert.Equal(t, expectedSize, hasher.Size())
+		assert.Len(t, hasher.Hash([]byte("Lorem ipsum")), expectedSize)
+	}
+
+	_, err := LookupHasher("crc32")
+	assert.Error(t, err)
+
+	require.NoError(t, RegisterHasher("reversed-sha256", reversedHasher{}))
+	hasher, err := LookupHasher("reversed-sha256")
+	require.NoError(t, err)
+	assert.Equal(t, reversedHasher{}, hasher)
+	assert.Contains(t, RegisteredHashers(), "reversed-sha256")
+
+	assert.Error(t, RegisterHasher("reversed-sha256", reversedHasher{}))
+	assert.Error(t, RegisterHasher(MD5Name, MD5Hasher{}))
+	assert.Error(t, RegisterHasher("", MD5Hasher{}))
+	assert.Error(t, RegisterHasher("nil", nil))
+}
+
+func TestWideValueConversions(t *testing.T) {
+	initialHash := BLAKE2bHasher{}.Hash([]byte("pish pish ololo"))
+	decodedHash, err := FromString(initialHash.String())
+	assert.NoError(t, err)
+	assert.True(t, initialHash.EqualsTo(decodedHash))
+	assert.False(t, initialHash.EqualsTo(decodedHash[:16]))
+
+	_, err = FromString("")
+	assert.Error(t, err)
+}
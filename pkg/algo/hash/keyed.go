@@ -0,0 +1,91 @@
+package hash
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// domain separation tags, they guarantee that a key, a leaf and an internal node
+// are never computed out of the same hash input
+const (
+	keyDomain  byte = 'K'
+	leafDomain byte = 'L'
+	// roundDomain is used to rehash a leaf several times
+	roundDomain byte = 'R'
+	nodeDomain  byte = 'N'
+	// selectionDomain is used to pick leaves of a proof of work out of a root
+	selectionDomain byte = 'S'
+)
+
+// KeyedHasher is a domain separated hash function that mixes a key into every hash input.
+// Unlike NewSeededHasher the key can not be factored out of computed values with XOR,
+// so values computed for one key are useless for any other key.
+type KeyedHasher struct {
+	originalHasher Hasher
+	key            Value
+}
+
+// NewKeyedHasher builds a keyed hash function out of original one.
+// The key is derived from every part, each part is length-prefixed to avoid
+// ambiguity between e.g. ("ab", "c") and ("a", "bc")
+func NewKeyedHasher(hasher Hasher, parts ...any) *KeyedHasher {
+	keyInput := []byte{keyDomain}
+	lenBuf := make([]byte, 8)
+	for _, part := range parts {
+		strPart := fmt.Sprintf("%v", part)
+		binary.BigEndian.PutUint64(lenBuf, uint64(len(strPart)))
+		keyInput = append(keyInput, lenBuf...)
+		keyInput = append(keyInput, strPart...)
+	}
+	return &KeyedHasher{
+		originalHasher: hasher,
+		key:            hasher.Hash(keyInput),
+	}
+}
+
+// HashLeaf computes H(key || 'L' || position)
+func (rcv *KeyedHasher) HashLeaf(position uint64) Value {
+	buf := make([]byte, 0, len(rcv.key)+1+8)
+	buf = append(buf, rcv.key...)
+	buf = append(buf, leafDomain)
+	buf = binary.BigEndian.AppendUint64(buf, position)
+	return rcv.originalHasher.Hash(buf)
+}
+
+// RehashLeaf computes H(key || 'R' || position || previous), it allows to make a leaf
+// arbitrary expensive by chaining its hash computations
+func (rcv *KeyedHasher) RehashLeaf(position uint64, previous Value) Value {
+	buf := make([]byte, 0, len(rcv.key)+1+8+len(previous))
+	buf = append(buf, rcv.key...)
+	buf = append(buf, roundDomain)
+	buf = binary.BigEndian.AppendUint64(buf, position)
+	buf = append(buf, previous...)
+	return rcv.originalHasher.Hash(buf)
+}
+
+// HashNode computes H(key || 'N' || position || left || right)
+func (rcv *KeyedHasher) HashNode(position uint64, left, right Value) Value {
+	buf := make([]byte, 0, len(rcv.key)+1+8+len(left)+len(right))
+	buf = append(buf, rcv.key...)
+	buf = append(buf, nodeDomain)
+	buf = binary.BigEndian.AppendUint64(buf, position)
+	buf = append(buf, left...)
+	buf = append(buf, right...)
+	return rcv.originalHasher.Hash(buf)
+}
+
+// HashSelection computes H(key || 'S' || root || counter), a sequence of such values
+// for counter = 0, 1, 2, ... is used as a random oracle to pick leaves of a proof of work
+func (rcv *KeyedHasher) HashSelection(root Value, counter uint64) Value {
+	buf := make([]byte, 0, len(rcv.key)+1+len(root)+8)
+	buf = append(buf, rcv.key...)
+	buf = append(buf, selectionDomain)
+	buf = append(buf, root...)
+	buf = binary.BigEndian.AppendUint64(buf, counter)
+	return rcv.originalHasher.Hash(buf)
+}
+
+// Size returns a number of bytes of computed hash values
+func (rcv *KeyedHasher) Size() int {
+	return rcv.originalHasher.Size()
+}
@@ -0,0 +1,79 @@
+package hash
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// names of hashers that are available out of the box
+const (
+	MD5Name     = "md5"
+	SHA256Name  = "sha256"
+	SHA3Name    = "sha3-256"
+	BLAKE2bName = "blake2b-256"
+)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Hasher{}
+)
+
+func init() {
+	for name, hasher := range map[string]Hasher{
+		MD5Name:     MD5Hasher{},
+		SHA256Name:  SHA256Hasher{},
+		SHA3Name:    SHA3Hasher{},
+		BLAKE2bName: BLAKE2bHasher{},
+	} {
+		if err := RegisterHasher(name, hasher); err != nil {
+			// unreachable: built-in names are unique
+			panic(err)
+		}
+	}
+}
+
+// RegisterHasher makes a hasher available by a given name for merkle trees and proofs of work.
+// A hasher has to be thread safe. Registering the same name twice is an error
+func RegisterHasher(name string, hasher Hasher) error {
+	if name == "" {
+		return fmt.Errorf("hasher name should not be empty")
+	}
+	if hasher == nil {
+		return fmt.Errorf("hasher %q should not be nil", name)
+	}
+	if hasher.Size() <= 0 {
+		return fmt.Errorf("hasher %q has non-positive digest size %d", name, hasher.Size())
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[name]; ok {
+		return fmt.Errorf("hasher %q is already registered", name)
+	}
+	registry[name] = hasher
+	return nil
+}
+
+// LookupHasher returns a hasher registered by a given name
+func LookupHasher(name string) (Hasher, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	hasher, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown hash %q", name)
+	}
+	return hasher, nil
+}
+
+// RegisteredHashers returns sorted names of all registered hashers
+func RegisteredHashers() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,340 @@
+package impl
+
+import (
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/evilaffliction/merkle/pkg/algo/hash"
+	"github.com/evilaffliction/merkle/pkg/algo/merkle"
+)
+
+// binary encoding of a proof of work, all the numbers are unsigned varints:
+//
+//	magic byte 'M' || layout
+//	proof version || len(hash name) || hash name || len(description) || description
+//	depth || proof leaves num || work factor || hash size
+//
+// a proof of work with listed nodes (layout 1) continues with
+//
+//	nodes count || nodes
+//
+// every node is encoded as
+//
+//	delta of node's num from a previous node's num || flags || hash value of "hash size" bytes
+//
+// a multiproof (layout 2) continues with
+//
+//	leaves count || deltas of leaf indexes || siblings count || sibling hash values of "hash size" bytes
+//
+// Nodes and leaves are sorted by their numbers, so deltas are small and take 1-2 bytes
+const (
+	binaryMagic            byte = 'M'
+	binaryNodeListLayout   byte = 1
+	binaryMultiProofLayout byte = 2
+
+	// flags of a node
+	binarySelectedFlag byte = 1
+
+	// maxBinaryHashSize protects a decoder from absurd hash sizes
+	maxBinaryHashSize = 512
+)
+
+// confirm interface's implementation
+var _ encoding.BinaryMarshaler = (*proofOfWork)(nil)
+
+// MarshalBinary encodes a proof of work into a compact binary form,
+// one can restore it with RestoreProofOfWorkFromBinary
+func (rcv *proofOfWork) MarshalBinary() ([]byte, error) {
+	for _, val := range []int{rcv.VersionVal, rcv.DepthVal, rcv.ProofLeavesNumVal, rcv.WorkFactorVal} {
+		if val < 0 {
+			return nil, fmt.Errorf("unable to encode negative value %d", val)
+		}
+	}
+
+	hashSize := 0
+	hashValues := make([]hash.Value, 0, len(rcv.NodesStats))
+	for i, nodeStats := range rcv.NodesStats {
+		hashValue, err := hash.FromString(nodeStats.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode hash value of node %d: %w", nodeStats.Num, err)
+		}
+		if i == 0 {
+			hashSize = len(hashValue)
+		}
+		if len(hashValue) != hashSize {
+			return nil, fmt.Errorf("node %d has a hash value of %d bytes, expected %d",
+				nodeStats.Num, len(hashValue), hashSize)
+		}
+		if i > 0 && nodeStats.Num <= rcv.NodesStats[i-1].Num {
+			return nil, fmt.Errorf("nodes are expected to be sorted by their unique numbers")
+		}
+		if nodeStats.Num < 0 {
+			return nil, fmt.Errorf("unable to encode negative node number %d", nodeStats.Num)
+		}
+		hashValues = append(hashValues, hashValue)
+	}
+
+	result := make([]byte, 0, 32+len(rcv.HashNameVal)+len(rcv.Description)+len(rcv.NodesStats)*(hashSize+3))
+	result = appendBinaryHeader(result, binaryNodeListLayout, rcv.VersionVal, rcv.HashNameVal, rcv.Description,
+		rcv.DepthVal, rcv.ProofLeavesNumVal, rcv.WorkFactorVal, hashSize)
+	result = binary.AppendUvarint(result, uint64(len(rcv.NodesStats)))
+	prevNum := 0
+	for i, nodeStats := range rcv.NodesStats {
+		result = binary.AppendUvarint(result, uint64(nodeStats.Num-prevNum))
+		prevNum = nodeStats.Num
+
+		var flags byte
+		if nodeStats.IsSelected {
+			flags |= binarySelectedFlag
+		}
+		result = append(result, flags)
+		result = append(result, hashValues[i]...)
+	}
+	return result, nil
+}
+
+// MarshalBinary encodes a multiproof into a compact binary form,
+// one can restore it with RestoreProofOfWorkFromBinary
+func (rcv *multiProof) MarshalBinary() ([]byte, error) {
+	for _, val := range []int{rcv.VersionVal, rcv.DepthVal, rcv.ProofLeavesNumVal, rcv.WorkFactorVal} {
+		if val < 0 {
+			return nil, fmt.Errorf("unable to encode negative value %d", val)
+		}
+	}
+	for i, leaf := range rcv.Leaves {
+		if leaf < 0 || (i > 0 && leaf <= rcv.Leaves[i-1]) {
+			return nil, fmt.Errorf("leaf indexes are expected to be sorted, unique and non-negative")
+		}
+	}
+
+	hashSize := 0
+	siblings := make([]hash.Value, 0, len(rcv.Siblings))
+	for i, sibling := range rcv.Siblings {
+		hashValue, err := hash.FromString(sibling)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode hash value of sibling %d: %w", i, err)
+		}
+		if i == 0 {
+			hashSize = len(hashValue)
+		}
+		if len(hashValue) != hashSize {
+			return nil, fmt.Errorf("sibling %d has a hash value of %d bytes, expected %d", i, len(hashValue), hashSize)
+		}
+		siblings = append(siblings, hashValue)
+	}
+
+	result := make([]byte, 0, 32+len(rcv.HashNameVal)+len(rcv.Description)+len(rcv.Leaves)*3+len(siblings)*hashSize)
+	result = appendBinaryHeader(result, binaryMultiProofLayout, rcv.VersionVal, rcv.HashNameVal, rcv.Description,
+		rcv.DepthVal, rcv.ProofLeavesNumVal, rcv.WorkFactorVal, hashSize)
+	result = binary.AppendUvarint(result, uint64(len(rcv.Leaves)))
+	prevLeaf := 0
+	for _, leaf := range rcv.Leaves {
+		result = binary.AppendUvarint(result, uint64(leaf-prevLeaf))
+		prevLeaf = leaf
+	}
+	result = binary.AppendUvarint(result, uint64(len(siblings)))
+	for _, sibling := range siblings {
+		result = append(result, sibling...)
+	}
+	return result, nil
+}
+
+func appendBinaryHeader(
+	data []byte,
+	layout byte,
+	version int,
+	hashName string,
+	description string,
+	depth int,
+	proofLeavesNum int,
+	workFactor int,
+	hashSize int,
+) []byte {
+	data = append(data, binaryMagic, layout)
+	data = binary.AppendUvarint(data, uint64(version))
+	data = appendBinaryString(data, hashName)
+	data = appendBinaryString(data, description)
+	data = binary.AppendUvarint(data, uint64(depth))
+	data = binary.AppendUvarint(data, uint64(proofLeavesNum))
+	data = binary.AppendUvarint(data, uint64(workFactor))
+	return binary.AppendUvarint(data, uint64(hashSize))
+}
+
+func appendBinaryString(data []byte, s string) []byte {
+	data = binary.AppendUvarint(data, uint64(len(s)))
+	return append(data, s...)
+}
+
+// binaryReader is a helper for decoding of a binary proof of work, it remembers the first error
+type binaryReader struct {
+	data []byte
+	err  error
+}
+
+func (rcv *binaryReader) readBytes(n int, what string) []byte {
+	if rcv.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(rcv.data) {
+		rcv.err = fmt.Errorf("unexpected end of data while reading %s", what)
+		return nil
+	}
+	result := rcv.data[:n]
+	rcv.data = rcv.data[n:]
+	return result
+}
+
+func (rcv *binaryReader) readInt(what string) int {
+	if rcv.err != nil {
+		return 0
+	}
+	val, n := binary.Uvarint(rcv.data)
+	if n <= 0 {
+		rcv.err = fmt.Errorf("malformed varint while reading %s", what)
+		return 0
+	}
+	if val > math.MaxInt32 {
+		rcv.err = fmt.Errorf("too large %s %d", what, val)
+		return 0
+	}
+	rcv.data = rcv.data[n:]
+	return int(val)
+}
+
+func (rcv *binaryReader) readString(what string) string {
+	return string(rcv.readBytes(rcv.readInt(what+" length"), what))
+}
+
+// RestoreProofOfWorkFromBinary decodes a proof of work or a multiproof encoded by their MarshalBinary
+func RestoreProofOfWorkFromBinary(data []byte) (merkle.ProofOfWork, error) {
+	reader := &binaryReader{data: data}
+	header := reader.readBytes(2, "header")
+	if reader.err != nil {
+		return nil, fmt.Errorf("failed to decode binary proof of work: %w", reader.err)
+	}
+	if header[0] != binaryMagic {
+		return nil, fmt.Errorf("data is not a binary proof of work")
+	}
+
+	var res merkle.ProofOfWork
+	var err error
+	switch header[1] {
+	case binaryNodeListLayout:
+		res, err = readBinaryProofOfWork(reader)
+	case binaryMultiProofLayout:
+		res, err = readBinaryMultiProof(reader)
+	default:
+		return nil, fmt.Errorf("unsupported binary layout %d", header[1])
+	}
+	if err != nil {
+		return nil, err
+	}
+	if reader.err != nil {
+		return nil, fmt.Errorf("failed to decode binary proof of work: %w", reader.err)
+	}
+	if len(reader.data) != 0 {
+		return nil, fmt.Errorf("unexpected %d trailing bytes", len(reader.data))
+	}
+	return res, nil
+}
+
+func readBinaryProofOfWork(reader *binaryReader) (*proofOfWork, error) {
+	var res proofOfWork
+	res.VersionVal = reader.readInt("proof version")
+	res.HashNameVal = reader.readString("hash name")
+	res.Description = reader.readString("description")
+	res.DepthVal = reader.readInt("depth")
+	res.ProofLeavesNumVal = reader.readInt("proof leaves num")
+	res.WorkFactorVal = reader.readInt("work factor")
+	hashSize := reader.readInt("hash size")
+	nodesCount := reader.readInt("nodes count")
+	if reader.err != nil {
+		return nil, fmt.Errorf("failed to decode binary proof of work: %w", reader.err)
+	}
+	if nodesCount > 0 && (hashSize == 0 || hashSize > maxBinaryHashSize) {
+		return nil, fmt.Errorf("unsupported hash size %d", hashSize)
+	}
+	// every node takes at least 2 bytes besides its hash value
+	if nodesCount > len(reader.data)/(hashSize+2) {
+		return nil, fmt.Errorf("nodes count %d does not fit into %d bytes", nodesCount, len(reader.data))
+	}
+
+	res.NodesStats = make([]nodeStats, 0, nodesCount)
+	num := 0
+	for i := 0; i < nodesCount; i++ {
+		delta := reader.readInt("node number")
+		if i > 0 && delta == 0 {
+			return nil, fmt.Errorf("node numbers are expected to be unique")
+		}
+		num += delta
+		if num > math.MaxInt32 {
+			return nil, fmt.Errorf("too large node number %d", num)
+		}
+		flags := reader.readBytes(1, "node flags")
+		hashValue := reader.readBytes(hashSize, "node hash value")
+		if reader.err != nil {
+			return nil, fmt.Errorf("failed to decode binary proof of work: %w", reader.err)
+		}
+		if flags[0]&^binarySelectedFlag != 0 {
+			return nil, fmt.Errorf("unknown flags %d of node %d", flags[0], num)
+		}
+		res.NodesStats = append(res.NodesStats, nodeStats{
+			Num:        num,
+			Value:      hash.Value(hashValue).String(),
+			IsSelected: flags[0]&binarySelectedFlag != 0,
+		})
+	}
+	return &res, nil
+}
+
+func readBinaryMultiProof(reader *binaryReader) (*multiProof, error) {
+	var res multiProof
+	res.VersionVal = reader.readInt("proof version")
+	res.HashNameVal = reader.readString("hash name")
+	res.Description = reader.readString("description")
+	res.DepthVal = reader.readInt("depth")
+	res.ProofLeavesNumVal = reader.readInt("proof leaves num")
+	res.WorkFactorVal = reader.readInt("work factor")
+	hashSize := reader.readInt("hash size")
+	leavesCount := reader.readInt("leaves count")
+	if reader.err != nil {
+		return nil, fmt.Errorf("failed to decode binary multiproof: %w", reader.err)
+	}
+	// every leaf takes at least 1 byte
+	if leavesCount > len(reader.data) {
+		return nil, fmt.Errorf("leaves count %d does not fit into %d bytes", leavesCount, len(reader.data))
+	}
+
+	res.Leaves = make([]int, 0, leavesCount)
+	leaf := 0
+	for i := 0; i < leavesCount; i++ {
+		delta := reader.readInt("leaf index")
+		if i > 0 && delta == 0 {
+			return nil, fmt.Errorf("leaf indexes are expected to be unique")
+		}
+		leaf += delta
+		if leaf > math.MaxInt32 {
+			return nil, fmt.Errorf("too large leaf index %d", leaf)
+		}
+		res.Leaves = append(res.Leaves, leaf)
+	}
+
+	siblingsCount := reader.readInt("siblings count")
+	if reader.err != nil {
+		return nil, fmt.Errorf("failed to decode binary multiproof: %w", reader.err)
+	}
+	if siblingsCount > 0 && (hashSize == 0 || hashSize > maxBinaryHashSize) {
+		return nil, fmt.Errorf("unsupported hash size %d", hashSize)
+	}
+	if hashSize > 0 && siblingsCount > len(reader.data)/hashSize {
+		return nil, fmt.Errorf("siblings count %d does not fit into %d bytes", siblingsCount, len(reader.data))
+	}
+
+	res.Siblings = make([]string, 0, siblingsCount)
+	for i := 0; i < siblingsCount; i++ {
+		res.Siblings = append(res.Siblings, hash.Value(reader.readBytes(hashSize, "sibling hash value")).String())
+	}
+	return &res, nil
+}
@@ -0,0 +1,115 @@
+package impl
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/evilaffliction/merkle/pkg/algo/hash"
+)
+
+func TestBinaryMarshalling(t *testing.T) {
+	for _, hashName := range []string{hash.MD5Name, hash.BLAKE2bName} {
+		t.Run(hashName, func(t *testing.T) {
+			tree, err := NewTree(hashName, 14, 6, "Scientia potentia est", WithWorkFactor(2))
+			require.NoError(t, err)
+			pow, err := tree.GenerateProofOfWork()
+			require.NoError(t, err)
+
+			binaryData, err := pow.(*proofOfWork).MarshalBinary()
+			require.NoError(t, err)
+			restored, err := RestoreProofOfWorkFromBinary(binaryData)
+			require.NoError(t, err)
+			assert.Equal(t, pow, restored)
+			assert.NoError(t, restored.Verify())
+		})
+	}
+}
+
+func TestBinaryUnmarshallingOfMalformedData(t *testing.T) {
+	tree, err := NewTree("md5", 10, 3, "Errare humanum est")
+	require.NoError(t, err)
+	pow, err := tree.GenerateProofOfWork()
+	require.NoError(t, err)
+	binaryData, err := pow.(*proofOfWork).MarshalBinary()
+	require.NoError(t, err)
+
+	for name, data := range map[string][]byte{
+		"empty":             {},
+		"json":              []byte(`{"depth": 10}`),
+		"unknown_encoding":  append([]byte{binaryMagic, 42}, binaryData[2:]...),
+		"truncated":         binaryData[:len(binaryData)-1],
+		"trailing_bytes":    append(append([]byte{}, binaryData...), 0),
+		"huge_nodes_count":  {binaryMagic, binaryNodeListLayout, 1, 0, 0, 10, 3, 1, 16, 0xff, 0xff, 0xff, 0x07},
+		"huge_hash_size":    {binaryMagic, binaryNodeListLayout, 1, 0, 0, 10, 3, 1, 0xff, 0xff, 0x03, 1, 0, 0},
+		"duplicated_nodes":  {binaryMagic, binaryNodeListLayout, 1, 0, 0, 10, 3, 1, 1, 2, 5, 0, 1, 0, 0, 2},
+		"overflowed_varint": {binaryMagic, binaryNodeListLayout, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01},
+	} {
+		_, err := RestoreProofOfWorkFromBinary(data)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestBinaryEncodingSize(t *testing.T) {
+	// sizes do not depend on hash values, so there is no need to build a huge tree
+	for _, testCase := range []struct {
+		hashName       string
+		depth          int
+		proofLeavesNum int
+	}{
+		{hashName: hash.MD5Name, depth: 20, proofLeavesNum: 5},
+		{hashName: hash.MD5Name, depth: 25, proofLeavesNum: 10},
+		{hashName: hash.SHA256Name, depth: 25, proofLeavesNum: 10},
+	} {
+		hasher, err := hash.LookupHasher(testCase.hashName)
+		require.NoError(t, err)
+		leaves, err := selectProofLeavesByHash(hasher.Hash([]byte("Veritas")), testCase.depth, testCase.proofLeavesNum)
+		require.NoError(t, err)
+		nodeNums, err := getProofNodeNums(leaves, testCase.depth)
+		require.NoError(t, err)
+		pow := &proofOfWork{
+			VersionVal: CurrentProofVersion,
+			NodesStats: newNodesStats(nodeNums, leaves, func(nodeNum int) hash.Value {
+				return hasher.Hash([]byte{byte(nodeNum)})
+			}),
+			HashNameVal:       testCase.hashName,
+			Description:       "1700000000000000_Ypc5/N0i9M0yqwQkTxGk0w==",
+			DepthVal:          testCase.depth,
+			ProofLeavesNumVal: testCase.proofLeavesNum,
+			WorkFactorVal:     1,
+		}
+
+		jsonData, err := json.Marshal(pow)
+		require.NoError(t, err)
+		binaryData, err := pow.MarshalBinary()
+		require.NoError(t, err)
+		binaryHeader := base64.RawURLEncoding.EncodeToString(binaryData)
+
+		t.Logf("%s, depth %d, %d proof leaves, %d nodes: json %d bytes, binary %d bytes, base64url header %d bytes",
+			testCase.hashName, testCase.depth, testCase.proofLeavesNum, len(pow.NodesStats),
+			len(jsonData), len(binaryData), len(binaryHeader))
+		assert.Less(t, len(binaryHeader)*4, len(jsonData)*3)
+	}
+}
+
+func FuzzRestoreProofOfWorkFromBinary(f *testing.F) {
+	pow, multi := getMultiProof(f, 8, 3, "Alea iacta est")
+	for _, seed := range []interface{ MarshalBinary() ([]byte, error) }{pow, multi} {
+		binaryData, err := seed.MarshalBinary()
+		require.NoError(f, err)
+		f.Add(binaryData)
+	}
+	f.Add([]byte{binaryMagic, binaryNodeListLayout, 1, 0, 0, 10, 3, 1, 16, 0xff, 0xff, 0xff, 0x07})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		restored, err := RestoreProofOfWorkFromBinary(data)
+		if err != nil {
+			return
+		}
+		// the only requirement is to finish quickly without panics
+		_ = restored.VerifyWithLimits(DefaultLimits)
+	})
+}
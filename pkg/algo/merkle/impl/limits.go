@@ -0,0 +1,100 @@
+package impl
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/evilaffliction/merkle/pkg/algo/hash"
+	"github.com/evilaffliction/merkle/pkg/algo/merkle"
+)
+
+// DefaultLimits are used by Verify of proofs of work
+var DefaultLimits = merkle.Limits{
+	MaxDepth:             32,
+	MaxProofLeavesNum:    1024,
+	MaxWorkFactor:        1024,
+	MaxDescriptionLength: 1024,
+	MaxHashComputations:  1 << 20,
+}
+
+// proofShape contains parameters shared by all the proof of work formats
+type proofShape struct {
+	version        int
+	hashName       string
+	description    string
+	depth          int
+	proofLeavesNum int
+	workFactor     int
+}
+
+// check validates parameters against limits without any hashing.
+// It returns a number of leaf nodes and a size of hash values
+func (rcv proofShape) check(limits merkle.Limits) (int, int, error) {
+	if rcv.version < ProofVersionSeeded || rcv.version > CurrentProofVersion {
+		return 0, 0, fmt.Errorf("unsupported proof of work version %d", rcv.version)
+	}
+	hasher, err := hash.LookupHasher(rcv.hashName)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to get hasher: %w", err)
+	}
+	if len(rcv.description) > limits.MaxDescriptionLength {
+		return 0, 0, fmt.Errorf("description of %d bytes is longer than allowed %d",
+			len(rcv.description), limits.MaxDescriptionLength)
+	}
+	if rcv.depth <= 1 || rcv.depth > limits.MaxDepth {
+		return 0, 0, fmt.Errorf("depth %d is out of allowed range [2, %d]", rcv.depth, limits.MaxDepth)
+	}
+	leafNodeCount := 1 << (rcv.depth - 1)
+	maxProofLeavesNum := leafNodeCount / 2
+	if limits.MaxProofLeavesNum < maxProofLeavesNum {
+		maxProofLeavesNum = limits.MaxProofLeavesNum
+	}
+	if rcv.proofLeavesNum < 1 || rcv.proofLeavesNum > maxProofLeavesNum {
+		return 0, 0, fmt.Errorf("proof leaves num %d is out of allowed range [1, %d]",
+			rcv.proofLeavesNum, maxProofLeavesNum)
+	}
+	if rcv.workFactor < 1 || rcv.workFactor > limits.MaxWorkFactor {
+		return 0, 0, fmt.Errorf("work factor %d is out of allowed range [1, %d]", rcv.workFactor, limits.MaxWorkFactor)
+	}
+
+	// a portable leaf selection takes at least one hash computation per selected leaf
+	hashComputations := rcv.treeHashComputations()
+	if rcv.version >= ProofVersionPortable {
+		hashComputations += rcv.proofLeavesNum
+	}
+	if hashComputations > limits.MaxHashComputations {
+		return 0, 0, fmt.Errorf("verification needs at least %d hash computations, allowed %d",
+			hashComputations, limits.MaxHashComputations)
+	}
+	return leafNodeCount, hasher.Size(), nil
+}
+
+// treeHashComputations returns a max number of hash computations to restore a root hash out of a proof:
+// a key, selected leaves and inner nodes. A proof has at most "depth" nodes per selected leaf,
+// and every computed inner node merges two of them
+func (rcv proofShape) treeHashComputations() int {
+	return 1 + rcv.proofLeavesNum*rcv.workFactor + rcv.proofLeavesNum*rcv.depth
+}
+
+// selectionBudget returns a number of hash computations a leaf selection may take within limits,
+// rejected and repeated values of a portable leaf selection make it to take more than one per leaf
+func (rcv proofShape) selectionBudget(limits merkle.Limits) int {
+	return limits.MaxHashComputations - rcv.treeHashComputations()
+}
+
+// decodeHashValue decodes a base64 hash value of an expected size,
+// the encoded length is checked before decoding
+func decodeHashValue(value string, hashSize int) (hash.Value, error) {
+	if len(value) != base64.StdEncoding.EncodedLen(hashSize) {
+		return nil, fmt.Errorf("base64 hash value of %d bytes is expected to have %d bytes",
+			len(value), base64.StdEncoding.EncodedLen(hashSize))
+	}
+	result, err := hash.FromString(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(result) != hashSize {
+		return nil, fmt.Errorf("hash value has %d bytes, expected %d", len(result), hashSize)
+	}
+	return result, nil
+}
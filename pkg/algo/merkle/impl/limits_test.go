@@ -0,0 +1,93 @@
+package impl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/evilaffliction/merkle/pkg/algo/merkle"
+)
+
+func TestVerificationLimits(t *testing.T) {
+	getProofOfWork := func() *proofOfWork {
+		pow, err := getTree(t, 12, 4, "Festina lente", WithWorkFactor(3)).GenerateProofOfWork()
+		require.NoError(t, err)
+		return pow.(*proofOfWork)
+	}
+	require.NoError(t, getProofOfWork().Verify())
+
+	for name, testCase := range map[string]struct {
+		modify func(pow *proofOfWork)
+		limits func(limits *merkle.Limits)
+	}{
+		"depth_limit":             {limits: func(limits *merkle.Limits) { limits.MaxDepth = 11 }},
+		"proof_leaves_num_limit":  {limits: func(limits *merkle.Limits) { limits.MaxProofLeavesNum = 3 }},
+		"work_factor_limit":       {limits: func(limits *merkle.Limits) { limits.MaxWorkFactor = 2 }},
+		"description_limit":       {limits: func(limits *merkle.Limits) { limits.MaxDescriptionLength = 5 }},
+		"hash_computations_limit": {limits: func(limits *merkle.Limits) { limits.MaxHashComputations = 50 }},
+		// a root hash takes 61 hash computations, a leaf selection takes at least 4 more
+		"selection_hashes_limit": {limits: func(limits *merkle.Limits) { limits.MaxHashComputations = 64 }},
+		"unknown_version":        {modify: func(pow *proofOfWork) { pow.VersionVal = 42 }},
+		"unknown_hash":           {modify: func(pow *proofOfWork) { pow.HashNameVal = "crc32" }},
+		"tiny_depth":             {modify: func(pow *proofOfWork) { pow.DepthVal = 1 }},
+		"huge_depth":             {modify: func(pow *proofOfWork) { pow.DepthVal = 1 << 20 }},
+		"negative_proof_leaves":  {modify: func(pow *proofOfWork) { pow.ProofLeavesNumVal = -1 }},
+		"too_many_proof_leaves":  {modify: func(pow *proofOfWork) { pow.DepthVal, pow.ProofLeavesNumVal = 3, 3 }},
+		"negative_work_factor":   {modify: func(pow *proofOfWork) { pow.WorkFactorVal = -1 }},
+		"too_many_nodes": {modify: func(pow *proofOfWork) {
+			for len(pow.NodesStats) <= pow.ProofLeavesNumVal*pow.DepthVal {
+				pow.NodesStats = append(pow.NodesStats, pow.NodesStats[0])
+			}
+		}},
+		"root_node":          {modify: func(pow *proofOfWork) { pow.NodesStats[0].Num = 0 }},
+		"negative_node":      {modify: func(pow *proofOfWork) { pow.NodesStats[0].Num = -5 }},
+		"out_of_range_node":  {modify: func(pow *proofOfWork) { pow.NodesStats[0].Num = 1 << 11 }},
+		"duplicated_node":    {modify: func(pow *proofOfWork) { pow.NodesStats[1].Num = pow.NodesStats[0].Num }},
+		"short_hash_value":   {modify: func(pow *proofOfWork) { pow.NodesStats[0].Value = "AAAA" }},
+		"malformed_hash":     {modify: func(pow *proofOfWork) { pow.NodesStats[0].Value = "!!!!!!!!!!!!!!!!!!!!!!!!" }},
+		"selected_inner":     {modify: func(pow *proofOfWork) { pow.NodesStats[0].Num, pow.NodesStats[0].IsSelected = 1, true }},
+		"unselected_leaves":  {modify: func(pow *proofOfWork) { pow.ProofLeavesNumVal = 5 }},
+		"extra_selected_one": {modify: func(pow *proofOfWork) { pow.ProofLeavesNumVal = 3 }},
+	} {
+		t.Run(name, func(t *testing.T) {
+			pow := getProofOfWork()
+			if testCase.modify != nil {
+				testCase.modify(pow)
+			}
+			limits := DefaultLimits
+			if testCase.limits != nil {
+				testCase.limits(&limits)
+			}
+			assert.Error(t, pow.VerifyWithLimits(limits))
+		})
+	}
+}
+
+func TestMultiProofVerificationLimits(t *testing.T) {
+	getMulti := func() *multiProof {
+		_, multi := getMultiProof(t, 12, 4, "Festina lente")
+		return multi
+	}
+	require.NoError(t, getMulti().Verify())
+
+	for name, modify := range map[string]func(multi *multiProof){
+		"huge_depth":        func(multi *multiProof) { multi.DepthVal = 1 << 20 },
+		"negative_leaf":     func(multi *multiProof) { multi.Leaves[0] = -1 },
+		"out_of_range_leaf": func(multi *multiProof) { multi.Leaves[3] = 1 << 11 },
+		"unsorted_leaves":   func(multi *multiProof) { multi.Leaves[0], multi.Leaves[1] = multi.Leaves[1], multi.Leaves[0] },
+		"duplicated_leaves": func(multi *multiProof) { multi.Leaves[1] = multi.Leaves[0] },
+		"too_many_siblings": func(multi *multiProof) {
+			for len(multi.Siblings) <= len(multi.Leaves)*multi.DepthVal {
+				multi.Siblings = append(multi.Siblings, multi.Siblings[0])
+			}
+		},
+		"short_hash_value": func(multi *multiProof) { multi.Siblings[0] = "AAAA" },
+	} {
+		t.Run(name, func(t *testing.T) {
+			multi := getMulti()
+			modify(multi)
+			assert.Error(t, multi.VerifyWithLimits(DefaultLimits))
+		})
+	}
+}
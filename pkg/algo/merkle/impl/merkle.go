@@ -1,8 +1,10 @@
 package impl
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
+	"math"
 	"math/rand"
 	"sort"
 
@@ -21,8 +23,10 @@ type node struct {
 // Nodes of tree a stored in array in order to improve performance.
 // Functions in aux.go help to find father<->sons connection by their position in the "nodes" array.
 type tree struct {
+	version        int
 	depth          int
 	proofLeavesNum int
+	workFactor     int
 	hashName       string
 	description    string
 	nodes          []node
@@ -32,6 +36,18 @@ func (rcv *tree) Depth() int {
 	return rcv.depth
 }
 
+// nodeHash returns a hash value of a node
+func (rcv *tree) nodeHash(nodeNum int) hash.Value {
+	return rcv.nodes[nodeNum].hashValue
+}
+
+// setNodeHash sets a hash value of a node, different nodes may be set concurrently
+func (rcv *tree) setNodeHash(nodeNum int, hashValue hash.Value) {
+	rcv.nodes[nodeNum] = node{
+		hashValue: hashValue,
+	}
+}
+
 // NewTree is a constructor for a Merkle tree
 // It requires
 //
@@ -39,28 +55,41 @@ func (rcv *tree) Depth() int {
 //			2: "depth" that allows you to bring higher CPU costs for a prover
 //			3: "proofLeavesNum" that allows you to bring higher network cost
 //	     	4: "description" that varies generation of a tree. Ideally it should incorporate a timestamp
+//
+// Optional "opts" allow to customize the build process, see TreeOption
 func NewTree(
 	hashName string,
 	depth int,
 	proofLeavesNum int,
 	description string,
+	opts ...TreeOption,
+) (merkle.Tree, error) {
+	return NewTreeWithContext(context.Background(), hashName, depth, proofLeavesNum, description, opts...)
+}
+
+// NewTreeWithContext is the same as NewTree, but stops a build process and returns
+// the context's error as soon as a given context is cancelled
+func NewTreeWithContext(
+	ctx context.Context,
+	hashName string,
+	depth int,
+	proofLeavesNum int,
+	description string,
+	opts ...TreeOption,
 ) (merkle.Tree, error) {
+	cfg := newTreeConfigFromOptions(opts...)
 
 	// the trivial case is not viable and brings error handling complexity -> remove it
 	if depth <= 1 {
 		return nil, fmt.Errorf("too shallow depth %d, expected to be at least 2", depth)
 	}
 
-	hasher, err := hash.NameToHasher(hashName)
+	// Customizing tree hash generation by a key that depends on a income parameters
+	hasher, err := newNodeHasher(cfg.version, hashName, description, depth, proofLeavesNum, cfg.workFactor)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create hasher for merkle tree: %w", err)
 	}
 
-	// Encoding depth and needed proofLeavesNum into description
-	// it is needed to avoid malicious intents by varying them by a prover.
-	// Customizing tree hash generation by a seed that depends on a income parameters
-	seededHasher := hash.NewSeededHasher(hasher, description, depth, proofLeavesNum)
-
 	nodeCount, err := getNodeCount(depth)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get total node count for a merkle tree, error:error %w", err)
@@ -77,45 +106,72 @@ func NewTree(
 			proofLeavesNum, depth, leafNodeCount/2)
 	}
 
-	// actual build process starts here
-	nodes := make([]node, nodeCount)
-
-	// init build from leaves
-	b := make([]byte, 8)
-	for nodeNum := nodeCount - 1; nodeNum >= nonLeafNodeCount; nodeNum-- {
-		binary.LittleEndian.PutUint64(b, uint64(nodeNum))
-		nodeHashValue := seededHasher.Hash(b)
-		nodes[nodeNum] = node{
-			hashValue: nodeHashValue,
+	tracker := newBuildTracker(ctx, cfg.progress, leafNodeCount*cfg.workFactor+nonLeafNodeCount)
+	if cfg.streaming {
+		streaming := &streamingTree{
+			version:          cfg.version,
+			depth:            depth,
+			proofLeavesNum:   proofLeavesNum,
+			workFactor:       cfg.workFactor,
+			hashName:         hashName,
+			description:      description,
+			workers:          cfg.workers,
+			hasher:           hasher,
+			nonLeafNodeCount: nonLeafNodeCount,
+			trackHeight:      streamingTrackHeight(cfg.workFactor),
+			tracker:          tracker,
 		}
-	}
-	// build the rest of the tree, starting from the lowest (with greater depth) nodes
-	for nodeNum := nonLeafNodeCount - 1; nodeNum >= 0; nodeNum-- {
-		leftSonNum, rightSonNum, err := getChildrenNums(nodeNum, depth)
+		streaming.rootHash, err = streaming.subtreeHash(0, cfg.workers)
 		if err != nil {
-			// unreachable since we are sure that nodes have their children
-			panic(err)
-		}
-		leftHash := nodes[leftSonNum].hashValue
-		rightHash := nodes[rightSonNum].hashValue
-		nodeHashValue := seededHasher.Hash(hash.XORHashes(leftHash, rightHash).ToSlice())
-		nodes[nodeNum] = node{
-			hashValue: nodeHashValue,
+			return nil, fmt.Errorf("failed to build merkle tree: %w", err)
 		}
+		return streaming, nil
 	}
-	return &tree{
+
+	// actual build process starts here
+	result := &tree{
+		version:        cfg.version,
 		depth:          depth,
 		proofLeavesNum: proofLeavesNum,
+		workFactor:     cfg.workFactor,
 		hashName:       hashName,
 		description:    description,
-		nodes:          nodes,
-	}, nil
+		nodes:          make([]node, nodeCount),
+	}
+
+	// init build from leaves
+	err = parallelFor(cfg.workers, nonLeafNodeCount, nodeCount, cfg.workFactor, tracker, func(nodeNum int) {
+		result.setNodeHash(nodeNum, hasher.leafHash(nodeNum))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build merkle tree: %w", err)
+	}
+	// build the rest of the tree level by level, starting from the lowest (with greater depth) nodes.
+	// Nodes of the same level depend only on the level below, so they can be hashed simultaneously
+	for level := depth - 2; level >= 0; level-- {
+		levelBegin, _ := getNodeCount(level)
+		levelEnd, _ := getNodeCount(level + 1)
+		err = parallelFor(cfg.workers, levelBegin, levelEnd, 1, tracker, func(nodeNum int) {
+			leftSonNum, rightSonNum, err := getChildrenNums(nodeNum, depth)
+			if err != nil {
+				// unreachable since we are sure that nodes have their children
+				panic(err)
+			}
+			result.setNodeHash(nodeNum, hasher.innerHash(nodeNum, result.nodeHash(leftSonNum), result.nodeHash(rightSonNum)))
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to build merkle tree: %w", err)
+		}
+	}
+	return result, nil
 }
 
 // Verify allows you to check that a given Tree is correctly stored in terms of a Merkel tree
 func (rcv *tree) verify() error {
-	hasher, _ := hash.NameToHasher(rcv.hashName)
-	seededHasher := hash.NewSeededHasher(hasher, rcv.description, rcv.depth, rcv.proofLeavesNum)
+	hasher, err := newNodeHasher(rcv.version, rcv.hashName, rcv.description, rcv.depth, rcv.proofLeavesNum, rcv.workFactor)
+	if err != nil {
+		return fmt.Errorf("failed to create hasher for merkle tree: %w", err)
+	}
 
 	nodeCount, err := getNodeCount(rcv.depth)
 	if err != nil {
@@ -133,10 +189,8 @@ func (rcv *tree) verify() error {
 	}
 
 	// check leaves
-	b := make([]byte, 8)
 	for nodeNum := nodeCount - 1; nodeNum >= nonLeafNodeCount; nodeNum-- {
-		binary.LittleEndian.PutUint64(b, uint64(nodeNum))
-		if !rcv.nodes[nodeNum].hashValue.EqualsTo(seededHasher.Hash(b)) {
+		if !rcv.nodeHash(nodeNum).EqualsTo(hasher.leafHash(nodeNum)) {
 			return fmt.Errorf("leaf node %d has incorrect hash value", nodeNum)
 		}
 	}
@@ -147,9 +201,8 @@ func (rcv *tree) verify() error {
 		if err != nil {
 			panic(err)
 		}
-		tmpBuf := hash.XORHashes(rcv.nodes[leftSonNum].hashValue, rcv.nodes[rightSonNum].hashValue)
-		expectedHash := seededHasher.Hash(tmpBuf.ToSlice())
-		if !rcv.nodes[nodeNum].hashValue.EqualsTo(expectedHash) {
+		expectedHash := hasher.innerHash(nodeNum, rcv.nodeHash(leftSonNum), rcv.nodeHash(rightSonNum))
+		if !rcv.nodeHash(nodeNum).EqualsTo(expectedHash) {
 			return fmt.Errorf("non-leaf node %d has incorrect hash value", nodeNum)
 		}
 	}
@@ -187,20 +240,65 @@ func selectProofLeavesByHash(hashValue hash.Value, depth int, numOfProofLeaves i
 	return selectedIndexes, nil
 }
 
-// generateProofOfWorkWithSelectedLeaves builds proof of work by provided
-// leaves. The building process starts from the leaves and goes up, level by level
-// of a merkle tree
-func (rcv *tree) generateProofOfWorkWithSelectedLeaves(
-	leaves map[int]struct{},
-) (merkle.ProofOfWork, error) {
+// selectProofLeavesByCounter is a portable way to choose leaves for a proof of work.
+// It does not depend on a PRNG of any runtime, every step is specified:
+//
+//	r_i  = H(key || 'S' || root || uint64_be(i)), i = 0, 1, 2, ...
+//	x_i  = uint64_be(first 8 bytes of r_i)
+//	leaf = x_i mod leafCount
+//
+// x_i is rejected if x_i < 2^64 mod leafCount in order to avoid a modulo bias,
+// an already selected leaf is skipped. Selection stops as soon as "numOfProofLeaves" distinct leaves are found,
+// it fails once "maxHashes" values are computed without finding them.
+// Leaf indexes start from 0, node numbers of leaves are "nonLeafNodeCount" greater
+func selectProofLeavesByCounter(
+	hasher *hash.KeyedHasher,
+	rootHash hash.Value,
+	depth int,
+	numOfProofLeaves int,
+	maxHashes int,
+) (map[int]struct{}, error) {
+	if hasher.Size() < 8 {
+		return nil, fmt.Errorf("hash values of %d bytes are too short to select leaves, at least 8 bytes needed",
+			hasher.Size())
+	}
+	nonLeafNodeCount, err := getNodeCount(depth - 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get total non-leaf node count for a merkle tree, error: %w", err)
+	}
+	leafNodeCount := uint64(nonLeafNodeCount) + 1
+	if numOfProofLeaves < 0 || uint64(numOfProofLeaves) > leafNodeCount {
+		return nil, fmt.Errorf("unable to select %d leaves out of %d", numOfProofLeaves, leafNodeCount)
+	}
+	// 2^64 mod leafNodeCount, computed without overflow
+	rejectionThreshold := -leafNodeCount % leafNodeCount
+
+	selectedIndexes := make(map[int]struct{}, numOfProofLeaves)
+	for counter := uint64(0); len(selectedIndexes) < numOfProofLeaves; counter++ {
+		if counter >= uint64(maxHashes) {
+			return nil, fmt.Errorf("unable to select %d leaves with %d hash computations", numOfProofLeaves, maxHashes)
+		}
+		x := binary.BigEndian.Uint64(hasher.HashSelection(rootHash, counter))
+		if x < rejectionThreshold {
+			continue
+		}
+		selectedIndexes[int(x%leafNodeCount)+nonLeafNodeCount] = struct{}{}
+	}
 
-	neededNodes := make([]int, 0, len(leaves)+2*rcv.depth) // heuristic size assumption
+	return selectedIndexes, nil
+}
+
+// getProofNodeNums returns sorted numbers of nodes that are needed to restore a root hash
+// out of provided leaves. The search starts from the leaves and goes up, level by level
+// of a merkle tree
+func getProofNodeNums(leaves map[int]struct{}, depth int) ([]int, error) {
+	neededNodes := make([]int, 0, len(leaves)+2*depth) // heuristic size assumption
 	for leaf := range leaves {
 		neededNodes = append(neededNodes, leaf)
 	}
 
 	curLevelNodes := leaves
-	for i := 0; i < rcv.depth-1; i++ {
+	for i := 0; i < depth-1; i++ {
 		fatherNodes := make(map[int]struct{}, len(curLevelNodes)/2)
 		for curNodePos := range curLevelNodes {
 			fatherNum, err := getFatherNum(curNodePos)
@@ -210,7 +308,7 @@ func (rcv *tree) generateProofOfWorkWithSelectedLeaves(
 			fatherNodes[fatherNum] = struct{}{}
 		}
 		for fatherNodePos := range fatherNodes {
-			leftSonNum, rightSonNum, err := getChildrenNums(fatherNodePos, rcv.depth)
+			leftSonNum, rightSonNum, err := getChildrenNums(fatherNodePos, depth)
 			if err != nil {
 				return nil, err
 			}
@@ -228,29 +326,50 @@ func (rcv *tree) generateProofOfWorkWithSelectedLeaves(
 	sort.SliceStable(neededNodes, func(i, j int) bool {
 		return neededNodes[i] < neededNodes[j]
 	})
+	return neededNodes, nil
+}
 
-	nodesStats := make([]nodeStats, 0, len(neededNodes))
-	for _, nodeNum := range neededNodes {
+// newNodesStats describes given nodes for a proof of work, "getHash" provides their hash values
+func newNodesStats(nodeNums []int, leaves map[int]struct{}, getHash func(nodeNum int) hash.Value) []nodeStats {
+	nodesStats := make([]nodeStats, 0, len(nodeNums))
+	for _, nodeNum := range nodeNums {
 		_, ok := leaves[nodeNum]
 		nodesStats = append(nodesStats, nodeStats{
 			Num:        nodeNum,
-			Value:      rcv.nodes[nodeNum].hashValue.String(),
+			Value:      getHash(nodeNum).String(),
 			IsSelected: ok,
 		})
 	}
+	return nodesStats
+}
+
+// generateProofOfWorkWithSelectedLeaves builds proof of work by provided leaves
+func (rcv *tree) generateProofOfWorkWithSelectedLeaves(
+	leaves map[int]struct{},
+) (merkle.ProofOfWork, error) {
+	neededNodes, err := getProofNodeNums(leaves, rcv.depth)
+	if err != nil {
+		return nil, err
+	}
 
 	return &proofOfWork{
-		NodesStats:        nodesStats,
-		HashName:          rcv.hashName,
+		VersionVal:        rcv.version,
+		NodesStats:        newNodesStats(neededNodes, leaves, rcv.nodeHash),
+		HashNameVal:       rcv.hashName,
 		Description:       rcv.description,
 		DepthVal:          rcv.depth,
 		ProofLeavesNumVal: rcv.proofLeavesNum,
+		WorkFactorVal:     rcv.workFactor,
 	}, nil
 }
 
 // GenerateProofOfWork generates a proof of work from a fully built merkle tree
 func (rcv *tree) GenerateProofOfWork() (merkle.ProofOfWork, error) {
-	leaves, err := selectProofLeavesByHash(rcv.nodes[0].hashValue, rcv.depth, rcv.proofLeavesNum)
+	hasher, err := newNodeHasher(rcv.version, rcv.hashName, rcv.description, rcv.depth, rcv.proofLeavesNum, rcv.workFactor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create hasher for merkle tree: %w", err)
+	}
+	leaves, err := hasher.selectLeaves(rcv.nodeHash(0), rcv.depth, rcv.proofLeavesNum, math.MaxInt)
 	if err != nil {
 		return nil, fmt.Errorf("failed to select leaves for verification, error: %w", err)
 	}
@@ -258,7 +377,7 @@ func (rcv *tree) GenerateProofOfWork() (merkle.ProofOfWork, error) {
 }
 
 func computeHash(
-	hasher hash.Hasher,
+	hasher nodeHasher,
 	nodeNum int,
 	depth int,
 	computedNodes map[int]node,
@@ -285,5 +404,5 @@ func computeHash(
 		return defaultResult, err
 	}
 
-	return hasher.Hash(hash.XORHashes(leftHash, rightHash).ToSlice()), nil
+	return hasher.innerHash(nodeNum, leftHash, rightHash), nil
 }
@@ -1,7 +1,14 @@
 package impl
 
 import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"sort"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -10,8 +17,8 @@ import (
 	"github.com/evilaffliction/merkle/pkg/algo/hash"
 )
 
-func getTree(t *testing.T, depth int, proofLeavesNum int, description string) *tree {
-	i, err := NewTree("md5", depth, proofLeavesNum, description)
+func getTree(t testing.TB, depth int, proofLeavesNum int, description string, opts ...TreeOption) *tree {
+	i, err := NewTree("md5", depth, proofLeavesNum, description, opts...)
 	require.NoError(t, err)
 	v, ok := i.(*tree)
 	require.True(t, ok)
@@ -47,6 +54,100 @@ func TestStabilityOfProofLeafesGeneration(t *testing.T) {
 	assert.NotEqualValues(t, nodeNumsOriginal, otherNodeNums)
 }
 
+func TestPortableLeafSelection(t *testing.T) {
+	hasher := hash.NewKeyedHasher(hash.SHA256Hasher{}, "Veni, vidi, vici", 10, 15)
+	root := hasher.HashLeaf(0)
+	nodeNums, err := selectProofLeavesByCounter(hasher, root, 10, 15, math.MaxInt)
+	require.NoError(t, err)
+	assert.Len(t, nodeNums, 15)
+	for nodeNum := range nodeNums {
+		isLeafNode, err := isLeaf(nodeNum, 10)
+		assert.NoError(t, err)
+		assert.True(t, isLeafNode)
+	}
+	sameNodeNums, err := selectProofLeavesByCounter(hasher, root, 10, 15, math.MaxInt)
+	require.NoError(t, err)
+	assert.Equal(t, nodeNums, sameNodeNums)
+
+	otherNodeNums, err := selectProofLeavesByCounter(hasher, hasher.HashLeaf(1), 10, 15, math.MaxInt)
+	require.NoError(t, err)
+	assert.NotEqual(t, nodeNums, otherNodeNums)
+
+	// every leaf of a tiny tree has to be found
+	allNodeNums, err := selectProofLeavesByCounter(hasher, root, 3, 4, math.MaxInt)
+	require.NoError(t, err)
+	assert.Equal(t, map[int]struct{}{3: {}, 4: {}, 5: {}, 6: {}}, allNodeNums)
+
+	_, err = selectProofLeavesByCounter(hasher, root, 3, 5, math.MaxInt)
+	assert.Error(t, err)
+
+	// every computed value is counted, so a selection can not take more hashing than allowed
+	_, err = selectProofLeavesByCounter(hasher, root, 10, 15, 14)
+	assert.Error(t, err)
+}
+
+var updateVectors = flag.Bool("update", false, "rewrite test vectors in testdata")
+
+// leafSelectionVector describes a tree of ProofVersionPortable, so that implementations
+// in other languages can check both a tree build and a leaf selection.
+// A root is hex encoded, leaves are sorted indexes among leaf nodes
+type leafSelectionVector struct {
+	HashName       string `json:"hash_name"`
+	Description    string `json:"description"`
+	Depth          int    `json:"depth"`
+	ProofLeavesNum int    `json:"proof_leaves_num"`
+	WorkFactor     int    `json:"work_factor"`
+	Root           string `json:"root"`
+	Leaves         []int  `json:"leaves"`
+}
+
+func TestLeafSelectionVectors(t *testing.T) {
+	const vectorsPath = "testdata/leaf_selection_vectors.json"
+
+	var vectors []leafSelectionVector
+	if *updateVectors {
+		for _, hashName := range []string{hash.MD5Name, hash.SHA256Name, hash.SHA3Name, hash.BLAKE2bName} {
+			vectors = append(vectors,
+				leafSelectionVector{HashName: hashName, Description: "Ab ovo", Depth: 4, ProofLeavesNum: 2, WorkFactor: 1},
+				leafSelectionVector{HashName: hashName, Description: "Per aspera ad astra", Depth: 12, ProofLeavesNum: 7, WorkFactor: 3},
+			)
+		}
+	} else {
+		vectorsData, err := os.ReadFile(vectorsPath)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(vectorsData, &vectors))
+		require.NotEmpty(t, vectors)
+	}
+
+	for i := range vectors {
+		vector := &vectors[i]
+		built, err := NewTree(vector.HashName, vector.Depth, vector.ProofLeavesNum, vector.Description,
+			WithProofVersion(ProofVersionPortable), WithWorkFactor(vector.WorkFactor))
+		require.NoError(t, err)
+		v := built.(*tree)
+		pow, err := v.GenerateProofOfWork()
+		require.NoError(t, err)
+		multi, err := NewMultiProof(pow)
+		require.NoError(t, err)
+		leaves := multi.(*multiProof).Leaves
+		require.True(t, sort.IntsAreSorted(leaves))
+
+		if *updateVectors {
+			vector.Root = hex.EncodeToString(v.nodeHash(0))
+			vector.Leaves = leaves
+			continue
+		}
+		assert.Equal(t, vector.Root, hex.EncodeToString(v.nodeHash(0)), "%s %q", vector.HashName, vector.Description)
+		assert.Equal(t, vector.Leaves, leaves, "%s %q", vector.HashName, vector.Description)
+	}
+
+	if *updateVectors {
+		vectorsData, err := json.MarshalIndent(vectors, "", "  ")
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(vectorsPath, append(vectorsData, '\n'), 0o644))
+	}
+}
+
 func TestCorrectnessOfProofOfWork(t *testing.T) {
 	t.Run("depth_5_and_1_leaf_selected_manually", func(t *testing.T) {
 		// example of a merkle tree enumeration with depth 4
@@ -165,6 +266,133 @@ func TestProofOfWorkVerification(t *testing.T) {
 	})
 }
 
+// rebuildTreeForDescription reuses a tree that was built once for any other description
+// by XORing its nodes with a difference of seeds. That works only for ProofVersionSeeded
+func rebuildTreeForDescription(precomputed *tree, description string) *tree {
+	seedOf := func(description string) hash.Value {
+		seeded := hash.NewSeededHasher(hash.MD5Hasher{}, description, precomputed.depth, precomputed.proofLeavesNum)
+		return hash.XORHashes(seeded.Hash(nil), hash.MD5Hasher{}.Hash(nil))
+	}
+	seedDiff := hash.XORHashes(seedOf(precomputed.description), seedOf(description))
+
+	reused := &tree{
+		version:        precomputed.version,
+		depth:          precomputed.depth,
+		proofLeavesNum: precomputed.proofLeavesNum,
+		workFactor:     precomputed.workFactor,
+		hashName:       precomputed.hashName,
+		description:    description,
+		nodes:          make([]node, len(precomputed.nodes)),
+	}
+	for nodeNum := range precomputed.nodes {
+		reused.setNodeHash(nodeNum, hash.XORHashes(precomputed.nodeHash(nodeNum), seedDiff))
+	}
+	return reused
+}
+
+func TestTreesWithDifferentHashers(t *testing.T) {
+	for _, hashName := range []string{hash.MD5Name, hash.SHA256Name, hash.SHA3Name, hash.BLAKE2bName} {
+		t.Run(hashName, func(t *testing.T) {
+			hasher, err := hash.LookupHasher(hashName)
+			require.NoError(t, err)
+
+			i, err := NewTree(hashName, 12, 5, "Cogito, ergo sum")
+			require.NoError(t, err)
+			rawTree := i.(*tree)
+			require.NoError(t, rawTree.verify())
+			assert.Len(t, rawTree.nodeHash(0), hasher.Size())
+
+			pow, err := rawTree.GenerateProofOfWork()
+			require.NoError(t, err)
+			require.NoError(t, pow.Verify())
+
+			jsonData, err := json.Marshal(pow)
+			require.NoError(t, err)
+			restored, err := RestoreProofOfWorkFromJSON(jsonData)
+			require.NoError(t, err)
+			assert.NoError(t, restored.Verify())
+
+			// a digest of a different size should not be accepted
+			rawPow := restored.(*proofOfWork)
+			rawPow.NodesStats[0].Value = hash.Value(make([]byte, hasher.Size()+1)).String()
+			assert.Error(t, rawPow.Verify())
+		})
+	}
+}
+
+func TestParallelBuild(t *testing.T) {
+	for _, version := range []int{ProofVersionSeeded, ProofVersionKeyed, ProofVersionPortable} {
+		sequential := getTree(t, 16, 5, "Festina lente", WithProofVersion(version), WithWorkers(1))
+		for _, workers := range []int{2, 3, 8, 64} {
+			parallel := getTree(t, 16, 5, "Festina lente", WithProofVersion(version), WithWorkers(workers))
+			assert.Equal(t, sequential.nodes, parallel.nodes, "version %d, workers %d", version, workers)
+		}
+	}
+}
+
+func TestWorkFactor(t *testing.T) {
+	singleWork := getTree(t, 10, 3, "Non scholae, sed vitae")
+	multipleWork := getTree(t, 10, 3, "Non scholae, sed vitae", WithWorkFactor(5))
+	assert.NotEqual(t, singleWork.nodeHash(0), multipleWork.nodeHash(0))
+
+	pow, err := multipleWork.GenerateProofOfWork()
+	require.NoError(t, err)
+	assert.Equal(t, 5, pow.WorkFactor())
+	require.NoError(t, pow.Verify())
+
+	t.Run("Changing_work_factor_should_break_verification", func(t *testing.T) {
+		for _, workFactor := range []int{-1, 1, 4, 6} {
+			rawPow := *pow.(*proofOfWork)
+			rawPow.WorkFactorVal = workFactor
+			assert.Error(t, rawPow.Verify(), "work factor %d", workFactor)
+		}
+	})
+
+	t.Run("Streaming_tree_should_generate_the_same_proof", func(t *testing.T) {
+		streaming, err := NewTree("md5", 10, 3, "Non scholae, sed vitae", WithWorkFactor(5), WithStreamingMode())
+		require.NoError(t, err)
+		streamingPow, err := streaming.GenerateProofOfWork()
+		require.NoError(t, err)
+		assert.Equal(t, pow, streamingPow)
+	})
+
+	t.Run("Incorrect_work_factor_is_rejected", func(t *testing.T) {
+		_, err := NewTree("md5", 10, 3, "Non scholae, sed vitae", WithWorkFactor(0))
+		assert.Error(t, err)
+		_, err = NewTree("md5", 10, 3, "Non scholae, sed vitae",
+			WithWorkFactor(2), WithProofVersion(ProofVersionSeeded))
+		assert.Error(t, err)
+	})
+}
+
+func TestPrecomputedTreeReusal(t *testing.T) {
+	t.Run("Seeded_tree_can_be_precomputed", func(t *testing.T) {
+		precomputed := getTree(t, 12, 5, "Alea iacta est", WithProofVersion(ProofVersionSeeded))
+		reused := rebuildTreeForDescription(precomputed, "Carpe diem")
+		require.NoError(t, reused.verify())
+
+		pow, err := reused.GenerateProofOfWork()
+		require.NoError(t, err)
+		assert.NoError(t, pow.Verify())
+	})
+
+	t.Run("Keyed_tree_can_not_be_precomputed", func(t *testing.T) {
+		precomputed := getTree(t, 12, 5, "Alea iacta est", WithProofVersion(ProofVersionKeyed))
+		reused := rebuildTreeForDescription(precomputed, "Carpe diem")
+		require.Error(t, reused.verify())
+
+		pow, err := reused.GenerateProofOfWork()
+		require.NoError(t, err)
+		assert.Equal(t, ProofVersionKeyed, pow.Version())
+		assert.Error(t, pow.Verify())
+	})
+
+	t.Run("Unknown_version_is_rejected", func(t *testing.T) {
+		_, err := NewTree("md5", 5, 2, "Dura lex, sed lex", WithProofVersion(42))
+		assert.Error(t, err)
+	})
+}
+
 func Benchmark_MD5_GenerationProofOfWork(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		t, err := NewTree("md5", 20, 10, fmt.Sprintf("bench_%d", i))
@@ -191,3 +419,23 @@ func Benchmark_MD5_VerifyProofOfWork(b *testing.B) {
 		assert.NoError(b, err)
 	}
 }
+
+func benchmarkTreeBuild(b *testing.B, workers int) {
+	for depth := 16; depth <= 25; depth++ {
+		b.Run(fmt.Sprintf("depth_%d", depth), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				t, err := NewTree("md5", depth, 10, fmt.Sprintf("bench_%d", i), WithWorkers(workers))
+				assert.NoError(b, err)
+				assert.NotNil(b, t)
+			}
+		})
+	}
+}
+
+func Benchmark_MD5_SequentialTreeBuild(b *testing.B) {
+	benchmarkTreeBuild(b, 1)
+}
+
+func Benchmark_MD5_ParallelTreeBuild(b *testing.B) {
+	benchmarkTreeBuild(b, runtime.GOMAXPROCS(0))
+}
@@ -0,0 +1,220 @@
+package impl
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/evilaffliction/merkle/pkg/algo/merkle"
+)
+
+// multiProof is a compact representation of a proof of work.
+// It contains indexes of selected leaves and hash values of siblings only, since
+//
+//  1. hash values of selected leaves are recomputed by a verifier
+//  2. numbers of siblings are derived from selected leaves:
+//     siblings are the nodes needed to restore a root hash, sorted by their numbers
+type multiProof struct {
+	VersionVal        int      `json:"version,omitempty"`
+	HashNameVal       string   `json:"hash_name"`
+	Description       string   `json:"description"`
+	DepthVal          int      `json:"depth"`
+	ProofLeavesNumVal int      `json:"proof_leaves_num"`
+	WorkFactorVal     int      `json:"work_factor,omitempty"`
+	Leaves            []int    `json:"leaves"`
+	Siblings          []string `json:"siblings"`
+}
+
+// confirm interface's implementation
+var _ merkle.ProofOfWork = (*multiProof)(nil)
+
+// NewMultiProof converts a proof of work generated by a Tree to a multiproof
+func NewMultiProof(pow merkle.ProofOfWork) (merkle.ProofOfWork, error) {
+	rawPow, ok := pow.(*proofOfWork)
+	if !ok {
+		return nil, fmt.Errorf("unable to convert proof of work of type %T to a multiproof", pow)
+	}
+	nonLeafNodeCount, err := getNodeCount(rawPow.DepthVal - 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get total non-leaf node count for a merkle tree, error: %w", err)
+	}
+
+	values := make(map[int]string, len(rawPow.NodesStats))
+	leaves := make([]int, 0, rawPow.ProofLeavesNumVal)
+	for _, nodeStats := range rawPow.NodesStats {
+		if nodeStats.IsSelected {
+			leaves = append(leaves, nodeStats.Num-nonLeafNodeCount)
+			continue
+		}
+		values[nodeStats.Num] = nodeStats.Value
+	}
+	sort.Ints(leaves)
+
+	siblingNums, err := getMultiProofSiblingNums(leaves, rawPow.DepthVal)
+	if err != nil {
+		return nil, err
+	}
+	siblings := make([]string, 0, len(siblingNums))
+	for _, siblingNum := range siblingNums {
+		value, ok := values[siblingNum]
+		if !ok {
+			return nil, fmt.Errorf("proof of work has no node %d", siblingNum)
+		}
+		siblings = append(siblings, value)
+	}
+
+	return &multiProof{
+		VersionVal:        rawPow.VersionVal,
+		HashNameVal:       rawPow.HashNameVal,
+		Description:       rawPow.Description,
+		DepthVal:          rawPow.DepthVal,
+		ProofLeavesNumVal: rawPow.ProofLeavesNumVal,
+		WorkFactorVal:     rawPow.WorkFactorVal,
+		Leaves:            leaves,
+		Siblings:          siblings,
+	}, nil
+}
+
+// getMultiProofSiblingNums returns sorted numbers of nodes that are needed
+// along with given leaves to restore a root hash. Leaves are indexes among leaf nodes
+func getMultiProofSiblingNums(leaves []int, depth int) ([]int, error) {
+	nonLeafNodeCount, err := getNodeCount(depth - 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get total non-leaf node count for a merkle tree, error: %w", err)
+	}
+	leafNodes := make(map[int]struct{}, len(leaves))
+	for _, leaf := range leaves {
+		leafNodes[leaf+nonLeafNodeCount] = struct{}{}
+	}
+	neededNodes, err := getProofNodeNums(leafNodes, depth)
+	if err != nil {
+		return nil, err
+	}
+
+	siblingNums := make([]int, 0, len(neededNodes)-len(leafNodes))
+	for _, nodeNum := range neededNodes {
+		if _, ok := leafNodes[nodeNum]; !ok {
+			siblingNums = append(siblingNums, nodeNum)
+		}
+	}
+	return siblingNums, nil
+}
+
+// Verify verifies that a Merkle tree was originally built and
+// a given multiproof was built from it
+func (rcv *multiProof) Verify() error {
+	return rcv.VerifyWithLimits(DefaultLimits)
+}
+
+// VerifyWithLimits is the same as Verify, but it checks the multiproof's structure
+// against given limits before any hashing
+func (rcv *multiProof) VerifyWithLimits(limits merkle.Limits) error {
+	shape := proofShape{
+		version:        rcv.VersionVal,
+		hashName:       rcv.HashNameVal,
+		description:    rcv.Description,
+		depth:          rcv.DepthVal,
+		proofLeavesNum: rcv.ProofLeavesNumVal,
+		workFactor:     rcv.WorkFactor(),
+	}
+	leafNodeCount, hashSize, err := shape.check(limits)
+	if err != nil {
+		return fmt.Errorf("multiproof exceeds limits: %w", err)
+	}
+	nonLeafNodeCount := leafNodeCount - 1
+
+	if len(rcv.Leaves) != rcv.ProofLeavesNumVal {
+		return fmt.Errorf("expected number %d and acutal number %d of selected leafs are different",
+			rcv.ProofLeavesNumVal, len(rcv.Leaves))
+	}
+	for i, leaf := range rcv.Leaves {
+		if leaf < 0 || leaf >= leafNodeCount {
+			return fmt.Errorf("leaf index %d is out of range [0, %d)", leaf, leafNodeCount)
+		}
+		if i > 0 && leaf <= rcv.Leaves[i-1] {
+			return fmt.Errorf("leaf indexes are expected to be sorted and unique")
+		}
+	}
+	// every leaf brings at most one sibling per level
+	if len(rcv.Siblings) > len(rcv.Leaves)*(rcv.DepthVal-1) {
+		return fmt.Errorf("too many siblings %d for %d leaves", len(rcv.Siblings), len(rcv.Leaves))
+	}
+
+	siblingNums, err := getMultiProofSiblingNums(rcv.Leaves, rcv.DepthVal)
+	if err != nil {
+		return err
+	}
+	if len(siblingNums) != len(rcv.Siblings) {
+		return fmt.Errorf("expected %d siblings, actual number %d", len(siblingNums), len(rcv.Siblings))
+	}
+
+	nodes := make(map[int]node, len(rcv.Leaves)+len(rcv.Siblings))
+	for i, siblingNum := range siblingNums {
+		siblingHash, err := decodeHashValue(rcv.Siblings[i], hashSize)
+		if err != nil {
+			return fmt.Errorf("malformed hash value of sibling %d: %w", siblingNum, err)
+		}
+		nodes[siblingNum] = node{
+			hashValue: siblingHash,
+		}
+	}
+
+	// the structure is fine, hashing starts here
+	hasher, err := newNodeHasher(rcv.VersionVal, rcv.HashNameVal, rcv.Description, rcv.DepthVal, rcv.ProofLeavesNumVal,
+		rcv.WorkFactor())
+	if err != nil {
+		return fmt.Errorf("unable to get hasher: %w", err)
+	}
+	for _, leaf := range rcv.Leaves {
+		nodes[leaf+nonLeafNodeCount] = node{
+			hashValue: hasher.leafHash(leaf + nonLeafNodeCount),
+		}
+	}
+
+	rootHash, err := computeHash(hasher, 0, rcv.DepthVal, nodes)
+	if err != nil {
+		return fmt.Errorf("failed to compute root hash, error: %w", err)
+	}
+
+	expectedSelectedLeafNodes, err := hasher.selectLeaves(rootHash, rcv.DepthVal, rcv.ProofLeavesNumVal,
+		shape.selectionBudget(limits))
+	if err != nil {
+		return fmt.Errorf("failed to select proof leaves, error: %w", err)
+	}
+	for _, leaf := range rcv.Leaves {
+		if _, ok := expectedSelectedLeafNodes[leaf+nonLeafNodeCount]; !ok {
+			return fmt.Errorf("leaf %d is not expected to be selected", leaf)
+		}
+	}
+
+	return nil
+}
+
+func (rcv *multiProof) Version() int {
+	return rcv.VersionVal
+}
+
+func (rcv *multiProof) AccessToken() string {
+	return rcv.Description
+}
+
+func (rcv *multiProof) Depth() int {
+	return rcv.DepthVal
+}
+
+func (rcv *multiProof) ProofLeavesNum() int {
+	return rcv.ProofLeavesNumVal
+}
+
+// WorkFactor returns a number of hash computations per leaf,
+// proofs without a work factor were built with a single computation
+func (rcv *multiProof) WorkFactor() int {
+	if rcv.WorkFactorVal == 0 {
+		return 1
+	}
+	return rcv.WorkFactorVal
+}
+
+// HashName returns a name of a hash function a merkle tree was built with
+func (rcv *multiProof) HashName() string {
+	return rcv.HashNameVal
+}
@@ -0,0 +1,83 @@
+package impl
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getMultiProof(t testing.TB, depth int, proofLeavesNum int, description string) (*proofOfWork, *multiProof) {
+	pow, err := getTree(t, depth, proofLeavesNum, description).GenerateProofOfWork()
+	require.NoError(t, err)
+	multi, err := NewMultiProof(pow)
+	require.NoError(t, err)
+	return pow.(*proofOfWork), multi.(*multiProof)
+}
+
+func TestMultiProofSiblingNums(t *testing.T) {
+	// the same tree as in TestCorrectnessOfProofOfWork, leaf 5 is the node 20
+	siblingNums, err := getMultiProofSiblingNums([]int{5}, 5)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 3, 10, 19}, siblingNums)
+
+	// leaves 3, 6, 8, 13 are the nodes 18, 21, 23, 28
+	siblingNums, err = getMultiProofSiblingNums([]int{3, 6, 8, 13}, 5)
+	require.NoError(t, err)
+	assert.Equal(t, []int{7, 9, 12, 14, 17, 22, 24, 27}, siblingNums)
+}
+
+func TestMultiProofVerification(t *testing.T) {
+	pow, multi := getMultiProof(t, 16, 8, "Omnia mea mecum porto")
+	require.NoError(t, multi.Verify())
+	assert.Equal(t, pow.Description, multi.AccessToken())
+	assert.Equal(t, pow.Depth(), multi.Depth())
+	assert.Equal(t, pow.ProofLeavesNum(), multi.ProofLeavesNum())
+	assert.Equal(t, pow.Version(), multi.Version())
+	assert.Equal(t, pow.WorkFactor(), multi.WorkFactor())
+	assert.Len(t, multi.Leaves, 8)
+	assert.Len(t, multi.Siblings, len(pow.NodesStats)-8)
+
+	t.Run("Encodings_should_be_restored", func(t *testing.T) {
+		jsonData, err := json.Marshal(multi)
+		require.NoError(t, err)
+		powJSONData, err := json.Marshal(pow)
+		require.NoError(t, err)
+		assert.Less(t, len(jsonData), len(powJSONData))
+		restored, err := RestoreProofOfWorkFromJSON(jsonData)
+		require.NoError(t, err)
+		assert.Equal(t, multi, restored)
+
+		binaryData, err := multi.MarshalBinary()
+		require.NoError(t, err)
+		powBinaryData, err := pow.MarshalBinary()
+		require.NoError(t, err)
+		assert.Less(t, len(binaryData), len(powBinaryData))
+		restored, err = RestoreProofOfWorkFromBinary(binaryData)
+		require.NoError(t, err)
+		assert.Equal(t, multi, restored)
+		t.Logf("multiproof: json %d bytes, binary %d bytes; node list: json %d bytes, binary %d bytes",
+			len(jsonData), len(binaryData), len(powJSONData), len(powBinaryData))
+	})
+
+	for name, corrupt := range map[string]func(multi *multiProof){
+		"changed_description": func(multi *multiProof) { multi.Description = "no no no" },
+		"changed_leaf":        func(multi *multiProof) { multi.Leaves[0]++ },
+		"unsorted_leaves":     func(multi *multiProof) { multi.Leaves[0], multi.Leaves[1] = multi.Leaves[1], multi.Leaves[0] },
+		"leaf_out_of_range":   func(multi *multiProof) { multi.Leaves[7] = 1 << 15 },
+		"negative_leaf":       func(multi *multiProof) { multi.Leaves[0] = -1 },
+		"missing_leaf":        func(multi *multiProof) { multi.Leaves = multi.Leaves[1:] },
+		"missing_sibling":     func(multi *multiProof) { multi.Siblings = multi.Siblings[1:] },
+		"extra_sibling":       func(multi *multiProof) { multi.Siblings = append(multi.Siblings, multi.Siblings[0]) },
+		"swapped_siblings":    func(multi *multiProof) { multi.Siblings[0], multi.Siblings[1] = multi.Siblings[1], multi.Siblings[0] },
+		"malformed_sibling":   func(multi *multiProof) { multi.Siblings[0] = "c2hvcnQ=" },
+		"shallow_depth":       func(multi *multiProof) { multi.DepthVal = 1 },
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, corrupted := getMultiProof(t, 16, 8, "Omnia mea mecum porto")
+			corrupt(corrupted)
+			assert.Error(t, corrupted.Verify())
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package impl
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/evilaffliction/merkle/pkg/algo/hash"
+)
+
+// supported versions of a proof of work format
+const (
+	// ProofVersionSeeded is the original format. Nodes are hashed with a XOR seeded hasher,
+	// so the seed cancels out and a tree can be computed once and reused for any description.
+	// Kept only to be able to read old proofs
+	ProofVersionSeeded = 0
+	// ProofVersionKeyed mixes a key derived from a description and a node's position
+	// into every hash input
+	ProofVersionKeyed = 1
+	// ProofVersionPortable hashes nodes the same way as ProofVersionKeyed, but leaves of a proof of work
+	// are selected by a specified hash based derivation instead of Go's math/rand,
+	// see selectProofLeavesByCounter
+	ProofVersionPortable = 2
+
+	// CurrentProofVersion is a version used by NewTree by default
+	CurrentProofVersion = ProofVersionPortable
+)
+
+// nodeHasher computes hash values of a merkle tree's nodes by their positions.
+// Implementations have to be safe for concurrent use
+type nodeHasher interface {
+	leafHash(nodeNum int) hash.Value
+	innerHash(nodeNum int, leftHash, rightHash hash.Value) hash.Value
+	// size returns a number of bytes of computed hash values
+	size() int
+	// selectLeaves picks node numbers of leaves for a proof of work out of a root hash,
+	// it fails rather than take more than "maxHashes" hash computations
+	selectLeaves(rootHash hash.Value, depth int, proofLeavesNum int, maxHashes int) (map[int]struct{}, error)
+}
+
+// newNodeHasher picks a node hashing scheme for a given proof version.
+// Encoding depth, needed proofLeavesNum and workFactor into a seed/key is needed to avoid
+// malicious intents by varying them by a prover.
+func newNodeHasher(
+	version int,
+	hashName string,
+	description string,
+	depth int,
+	proofLeavesNum int,
+	workFactor int,
+) (nodeHasher, error) {
+	hasher, err := hash.LookupHasher(hashName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get hasher: %w", err)
+	}
+	if workFactor < 1 {
+		return nil, fmt.Errorf("work factor should be a positive number, actual %d", workFactor)
+	}
+
+	switch version {
+	case ProofVersionSeeded:
+		if workFactor != 1 {
+			return nil, fmt.Errorf("proof of work version %d does not support work factor %d", version, workFactor)
+		}
+		return &seededNodeHasher{
+			hasher: hash.NewSeededHasher(hasher, description, depth, proofLeavesNum),
+		}, nil
+	case ProofVersionKeyed, ProofVersionPortable:
+		keyParts := []any{description, depth, proofLeavesNum}
+		// keeps keys of trees without extra work the same as before work factor was introduced
+		if workFactor > 1 {
+			keyParts = append(keyParts, workFactor)
+		}
+		keyed := &keyedNodeHasher{
+			hasher:     hash.NewKeyedHasher(hasher, keyParts...),
+			workFactor: workFactor,
+		}
+		if version == ProofVersionKeyed {
+			return keyed, nil
+		}
+		return &portableNodeHasher{keyedNodeHasher: keyed}, nil
+	default:
+		return nil, fmt.Errorf("unsupported proof of work version %d", version)
+	}
+}
+
+// seededNodeHasher implements ProofVersionSeeded hashing
+type seededNodeHasher struct {
+	hasher hash.Hasher
+}
+
+func (rcv *seededNodeHasher) leafHash(nodeNum int) hash.Value {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(nodeNum))
+	return rcv.hasher.Hash(b)
+}
+
+func (rcv *seededNodeHasher) innerHash(_ int, leftHash, rightHash hash.Value) hash.Value {
+	return rcv.hasher.Hash(hash.XORHashes(leftHash, rightHash).ToSlice())
+}
+
+func (rcv *seededNodeHasher) size() int {
+	return rcv.hasher.Size()
+}
+
+func (rcv *seededNodeHasher) selectLeaves(rootHash hash.Value, depth int, proofLeavesNum int, _ int) (map[int]struct{}, error) {
+	return selectProofLeavesByHash(rootHash, depth, proofLeavesNum)
+}
+
+// keyedNodeHasher implements ProofVersionKeyed hashing.
+// Every leaf is rehashed "workFactor - 1" more times
+type keyedNodeHasher struct {
+	hasher     *hash.KeyedHasher
+	workFactor int
+}
+
+func (rcv *keyedNodeHasher) leafHash(nodeNum int) hash.Value {
+	result := rcv.hasher.HashLeaf(uint64(nodeNum))
+	for round := 1; round < rcv.workFactor; round++ {
+		result = rcv.hasher.RehashLeaf(uint64(nodeNum), result)
+	}
+	return result
+}
+
+func (rcv *keyedNodeHasher) innerHash(nodeNum int, leftHash, rightHash hash.Value) hash.Value {
+	return rcv.hasher.HashNode(uint64(nodeNum), leftHash, rightHash)
+}
+
+func (rcv *keyedNodeHasher) size() int {
+	return rcv.hasher.Size()
+}
+
+func (rcv *keyedNodeHasher) selectLeaves(rootHash hash.Value, depth int, proofLeavesNum int, _ int) (map[int]struct{}, error) {
+	return selectProofLeavesByHash(rootHash, depth, proofLeavesNum)
+}
+
+// portableNodeHasher implements ProofVersionPortable hashing
+type portableNodeHasher struct {
+	*keyedNodeHasher
+}
+
+func (rcv *portableNodeHasher) selectLeaves(
+	rootHash hash.Value,
+	depth int,
+	proofLeavesNum int,
+	maxHashes int,
+) (map[int]struct{}, error) {
+	return selectProofLeavesByCounter(rcv.hasher, rootHash, depth, proofLeavesNum, maxHashes)
+}
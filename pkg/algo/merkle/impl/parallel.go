@@ -0,0 +1,63 @@
+package impl
+
+import (
+	"sync"
+)
+
+// minNodesPerWorker prevents from spawning goroutines for tiny levels of a tree,
+// where synchronization costs more than hashing itself
+const minNodesPerWorker = 1024
+
+// parallelFor calls "fn" for every node number in [begin, end).
+// The range is split into contiguous chunks among at most "workers" goroutines,
+// so every goroutine writes to its own part of a tree.
+// Every node takes "cost" hash computations that are registered in a tracker,
+// the first tracker's error stops all the workers
+func parallelFor(workers int, begin int, end int, cost int, tracker *buildTracker, fn func(nodeNum int)) error {
+	total := end - begin
+	if maxWorkers := total / minNodesPerWorker; workers > maxWorkers {
+		workers = maxWorkers
+	}
+	if workers <= 1 {
+		return forRange(begin, end, cost, tracker, fn)
+	}
+
+	chunkSize := (total + workers - 1) / workers
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for chunkBegin := begin; chunkBegin < end; chunkBegin += chunkSize {
+		chunkEnd := chunkBegin + chunkSize
+		if chunkEnd > end {
+			chunkEnd = end
+		}
+		wg.Add(1)
+		go func(chunkBegin, chunkEnd int) {
+			defer wg.Done()
+			if err := forRange(chunkBegin, chunkEnd, cost, tracker, fn); err != nil {
+				errs <- err
+			}
+		}(chunkBegin, chunkEnd)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// forRange sequentially calls "fn" for every node number in [begin, end).
+// Nodes are registered in a tracker by batches of about trackInterval hash computations
+func forRange(begin int, end int, cost int, tracker *buildTracker, fn func(nodeNum int)) error {
+	batchSize := max(1, trackInterval/cost)
+	for batchBegin := begin; batchBegin < end; batchBegin += batchSize {
+		batchEnd := batchBegin + batchSize
+		if batchEnd > end {
+			batchEnd = end
+		}
+		for nodeNum := batchBegin; nodeNum < batchEnd; nodeNum++ {
+			fn(nodeNum)
+		}
+		if err := tracker.add((batchEnd - batchBegin) * cost); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package impl
+
+import (
+	"context"
+	"sync"
+)
+
+// trackInterval is a number of hash computations a worker does between reporting progress
+// and checking for a cancelled context, a leaf of a tree with a large work factor may exceed it on its own
+const trackInterval = 4096
+
+// ProgressFunc is called during a tree build with a number of already done hash computations
+// and a total number of hash computations. Every leaf takes as many hash computations as a work factor
+// of a tree, every inner node takes one
+type ProgressFunc func(hashed int, total int)
+
+// buildTracker counts hash computations in order to report progress and to stop a build
+// as soon as its context is cancelled. It is safe for concurrent use
+type buildTracker struct {
+	ctx      context.Context
+	progress ProgressFunc
+
+	mu     sync.Mutex
+	hashed int
+	total  int
+}
+
+func newBuildTracker(ctx context.Context, progress ProgressFunc, total int) *buildTracker {
+	return &buildTracker{
+		ctx:      ctx,
+		progress: progress,
+		total:    total,
+	}
+}
+
+// add registers new hash computations and returns an error if the build should be stopped
+func (rcv *buildTracker) add(hashed int) error {
+	if rcv.progress != nil {
+		rcv.mu.Lock()
+		rcv.hashed += hashed
+		rcv.progress(rcv.hashed, rcv.total)
+		rcv.mu.Unlock()
+	}
+	return rcv.ctx.Err()
+}
+
+// extend increases a total number of hash computations
+func (rcv *buildTracker) extend(total int) {
+	rcv.mu.Lock()
+	rcv.total += total
+	rcv.mu.Unlock()
+}
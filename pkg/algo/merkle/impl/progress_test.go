@@ -0,0 +1,101 @@
+package impl
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildProgress(t *testing.T) {
+	for _, streaming := range []bool{false, true} {
+		for _, workers := range []int{1, 4} {
+			for _, workFactor := range []int{1, 3} {
+				name := fmt.Sprintf("streaming_%t_workers_%d_work_factor_%d", streaming, workers, workFactor)
+				t.Run(name, func(t *testing.T) {
+					var lastHashed, lastTotal, calls int
+					progress := func(hashed int, total int) {
+						assert.GreaterOrEqual(t, hashed, lastHashed)
+						assert.LessOrEqual(t, hashed, total)
+						lastHashed, lastTotal = hashed, total
+						calls++
+					}
+					opts := []TreeOption{WithProgress(progress), WithWorkers(workers), WithWorkFactor(workFactor)}
+					if streaming {
+						opts = append(opts, WithStreamingMode())
+					}
+
+					// every leaf takes "workFactor" hash computations, every inner node takes one
+					buildHashes := 1<<15*workFactor + 1<<15 - 1
+					tree, err := NewTree("md5", 16, 5, "Tempus fugit", opts...)
+					require.NoError(t, err)
+					assert.Equal(t, buildHashes, lastHashed)
+					assert.Equal(t, buildHashes, lastTotal)
+					assert.Greater(t, calls, 1)
+
+					_, err = tree.GenerateProofOfWork()
+					require.NoError(t, err)
+					// a streaming tree recomputes subtrees for a proof
+					if streaming {
+						assert.Greater(t, lastTotal, buildHashes)
+					}
+					assert.Equal(t, lastTotal, lastHashed)
+				})
+			}
+		}
+	}
+}
+
+func TestBuildCancellation(t *testing.T) {
+	for _, streaming := range []bool{false, true} {
+		t.Run(fmt.Sprintf("streaming_%t", streaming), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var lastHashed int
+			opts := []TreeOption{WithWorkers(1), WithProgress(func(hashed int, total int) {
+				lastHashed = hashed
+				if hashed > total/4 {
+					cancel()
+				}
+			})}
+			if streaming {
+				opts = append(opts, WithStreamingMode())
+			}
+
+			_, err := NewTreeWithContext(ctx, "md5", 18, 5, "Tempus fugit", opts...)
+			require.ErrorIs(t, err, context.Canceled)
+			assert.Less(t, lastHashed, 1<<17)
+
+			_, err = NewTreeWithContext(ctx, "md5", 18, 5, "Tempus fugit")
+			assert.ErrorIs(t, err, context.Canceled)
+		})
+	}
+}
+
+func TestBuildCancellationWithLargeWorkFactor(t *testing.T) {
+	for _, streaming := range []bool{false, true} {
+		t.Run(fmt.Sprintf("streaming_%t", streaming), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// progress is reported by hash computations rather than by nodes,
+			// so a build is stopped long before a few thousands of heavy leaves are hashed
+			var lastHashed int
+			opts := []TreeOption{WithWorkers(1), WithWorkFactor(1024), WithProgress(func(hashed int, total int) {
+				lastHashed = hashed
+				cancel()
+			})}
+			if streaming {
+				opts = append(opts, WithStreamingMode())
+			}
+
+			_, err := NewTreeWithContext(ctx, "md5", 14, 5, "Tempus fugit", opts...)
+			require.ErrorIs(t, err, context.Canceled)
+			assert.Positive(t, lastHashed)
+			assert.LessOrEqual(t, lastHashed, trackInterval)
+		})
+	}
+}
@@ -18,11 +18,13 @@ type nodeStats struct {
 // proofOfWork stores information about a computed Merkle tree without storing the whole tree
 // Allows you to check that a prover has indeed computed the whole tree
 type proofOfWork struct {
+	VersionVal        int         `json:"version,omitempty"`
 	NodesStats        []nodeStats `json:"node_stats"`
-	HashName          string      `json:"hash_name"`
+	HashNameVal       string      `json:"hash_name"`
 	Description       string      `json:"description"`
 	DepthVal          int         `json:"depth"`
 	ProofLeavesNumVal int         `json:"proof_leaves_num"`
+	WorkFactorVal     int         `json:"work_factor,omitempty"`
 }
 
 // confirm interface's implementation
@@ -31,23 +33,68 @@ var _ merkle.ProofOfWork = (*proofOfWork)(nil)
 // Verify verifies that a Merkle tree was originally built and
 // a given proof of work was built from it
 func (rcv *proofOfWork) Verify() error {
-	hasher, err := hash.NameToHasher(rcv.HashName)
+	return rcv.VerifyWithLimits(DefaultLimits)
+}
+
+// VerifyWithLimits is the same as Verify, but it checks the proof of work's structure
+// against given limits before any hashing
+func (rcv *proofOfWork) VerifyWithLimits(limits merkle.Limits) error {
+	shape := proofShape{
+		version:        rcv.VersionVal,
+		hashName:       rcv.HashNameVal,
+		description:    rcv.Description,
+		depth:          rcv.DepthVal,
+		proofLeavesNum: rcv.ProofLeavesNumVal,
+		workFactor:     rcv.WorkFactor(),
+	}
+	leafNodeCount, hashSize, err := shape.check(limits)
 	if err != nil {
-		return fmt.Errorf("unable to get hasher: %w", err)
+		return fmt.Errorf("proof of work exceeds limits: %w", err)
 	}
-	seededHasher := hash.NewSeededHasher(hasher, rcv.Description, rcv.DepthVal, rcv.ProofLeavesNumVal)
+	nonLeafNodeCount := leafNodeCount - 1
+	nodeCount := nonLeafNodeCount + leafNodeCount
 
+	// every selected leaf brings at most "depth - 1" siblings
+	if len(rcv.NodesStats) > rcv.ProofLeavesNumVal*rcv.DepthVal {
+		return fmt.Errorf("too many nodes %d for %d proof leaves", len(rcv.NodesStats), rcv.ProofLeavesNumVal)
+	}
 	nodes := make(map[int]node, len(rcv.NodesStats))
+	selectedCount := 0
 	for _, nodeStats := range rcv.NodesStats {
-		newHashVal, err := hash.FromString(nodeStats.Value)
+		// the root is never a part of a proof
+		if nodeStats.Num < 1 || nodeStats.Num >= nodeCount {
+			return fmt.Errorf("node number %d is out of range [1, %d)", nodeStats.Num, nodeCount)
+		}
+		if _, ok := nodes[nodeStats.Num]; ok {
+			return fmt.Errorf("node %d is duplicated", nodeStats.Num)
+		}
+		if nodeStats.IsSelected {
+			if nodeStats.Num < nonLeafNodeCount {
+				return fmt.Errorf("selected node %d is not a leaf", nodeStats.Num)
+			}
+			selectedCount++
+		}
+		newHashVal, err := decodeHashValue(nodeStats.Value, hashSize)
 		if err != nil {
-			return err
+			return fmt.Errorf("malformed hash value of node %d: %w", nodeStats.Num, err)
 		}
 		nodes[nodeStats.Num] = node{
 			hashValue: newHashVal,
 		}
 	}
-	rootHash, err := computeHash(seededHasher, 0, rcv.DepthVal, nodes)
+	if selectedCount != rcv.ProofLeavesNumVal {
+		return fmt.Errorf("expected number %d and acutal number %d of selected leafs are different",
+			rcv.ProofLeavesNumVal, selectedCount)
+	}
+
+	// the structure is fine, hashing starts here
+	hasher, err := newNodeHasher(rcv.VersionVal, rcv.HashNameVal, rcv.Description, rcv.DepthVal, rcv.ProofLeavesNumVal,
+		rcv.WorkFactor())
+	if err != nil {
+		return fmt.Errorf("unable to get hasher: %w", err)
+	}
+
+	rootHash, err := computeHash(hasher, 0, rcv.DepthVal, nodes)
 	if err != nil {
 		return fmt.Errorf("failed to compute root hash, error: %w", err)
 	}
@@ -56,7 +103,8 @@ func (rcv *proofOfWork) Verify() error {
 		return fmt.Errorf("malfmed proof of work, not all nodes were used to compute root hash")
 	}
 
-	expectedSelectedLeafNodes, err := selectProofLeavesByHash(rootHash, rcv.DepthVal, rcv.ProofLeavesNumVal)
+	expectedSelectedLeafNodes, err := hasher.selectLeaves(rootHash, rcv.DepthVal, rcv.ProofLeavesNumVal,
+		shape.selectionBudget(limits))
 	if err != nil {
 		return fmt.Errorf("failed to select proof leaves, error: %w", err)
 	}
@@ -77,9 +125,28 @@ func (rcv *proofOfWork) Verify() error {
 		}
 	}
 
+	// seeded leaves do not depend on a description, there is no point to check them
+	if rcv.VersionVal == ProofVersionSeeded {
+		return nil
+	}
+	for _, nodeStats := range rcv.NodesStats {
+		if !nodeStats.IsSelected {
+			continue
+		}
+		// the value was successfully decoded above
+		leafHashVal, _ := hash.FromString(nodeStats.Value)
+		if !leafHashVal.EqualsTo(hasher.leafHash(nodeStats.Num)) {
+			return fmt.Errorf("selected leaf %d has incorrect hash value", nodeStats.Num)
+		}
+	}
+
 	return nil
 }
 
+func (rcv *proofOfWork) Version() int {
+	return rcv.VersionVal
+}
+
 func (rcv *proofOfWork) AccessToken() string {
 	return rcv.Description
 }
@@ -92,10 +159,36 @@ func (rcv *proofOfWork) ProofLeavesNum() int {
 	return rcv.ProofLeavesNumVal
 }
 
+// WorkFactor returns a number of hash computations per leaf,
+// proofs without a work factor were built with a single computation
+func (rcv *proofOfWork) WorkFactor() int {
+	if rcv.WorkFactorVal == 0 {
+		return 1
+	}
+	return rcv.WorkFactorVal
+}
+
+// HashName returns a name of a hash function a merkle tree was built with
+func (rcv *proofOfWork) HashName() string {
+	return rcv.HashNameVal
+}
+
+// RestoreProofOfWorkFromJSON decodes either a proof of work with listed nodes or a multiproof.
+// A multiproof is detected by presence of siblings
 func RestoreProofOfWorkFromJSON(jsonData []byte) (merkle.ProofOfWork, error) {
-	var res proofOfWork
-	if err := json.Unmarshal(jsonData, &res); err != nil {
+	var probe struct {
+		Siblings json.RawMessage `json:"siblings"`
+	}
+	if err := json.Unmarshal(jsonData, &probe); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal json value to proof of work: %w", err)
+	}
+
+	var res merkle.ProofOfWork = &proofOfWork{}
+	if probe.Siblings != nil {
+		res = &multiProof{}
+	}
+	if err := json.Unmarshal(jsonData, res); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal json value to proof of work: %w", err)
 	}
-	return &res, nil
+	return res, nil
 }
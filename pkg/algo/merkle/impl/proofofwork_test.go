@@ -22,7 +22,7 @@ func TestJSONMarshalling(t *testing.T) {
 				Value: "Merlin",
 			},
 		},
-		HashName:          "md5",
+		HashNameVal:       "md5",
 		Description:       "Excalibur",
 		DepthVal:          999,
 		ProofLeavesNumVal: 1,
@@ -42,3 +42,23 @@ func TestJSONMarshalling(t *testing.T) {
 	require.True(t, ok)
 	assert.Equal(t, *val, *restoredVal)
 }
+
+func FuzzRestoreProofOfWorkFromJSON(f *testing.F) {
+	pow, multi := getMultiProof(f, 8, 3, "Alea iacta est")
+	for _, seed := range []any{pow, multi} {
+		jsonData, err := json.Marshal(seed)
+		require.NoError(f, err)
+		f.Add(jsonData)
+	}
+	f.Add([]byte(`{"depth": 1000000, "proof_leaves_num": 1000000, "hash_name": "md5"}`))
+	f.Add([]byte(`{"node_stats": [{"num": -1}], "siblings": []}`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		restored, err := RestoreProofOfWorkFromJSON(data)
+		if err != nil {
+			return
+		}
+		// the only requirement is to finish quickly without panics
+		_ = restored.VerifyWithLimits(DefaultLimits)
+	})
+}
@@ -0,0 +1,162 @@
+package impl
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"sync"
+
+	"github.com/evilaffliction/merkle/pkg/algo/hash"
+	"github.com/evilaffliction/merkle/pkg/algo/merkle"
+)
+
+// streamingTree represents a merkle tree that stores its root hash only.
+// Hashes of any other node are recomputed on demand by a depth-first traversal of its subtree,
+// so at most O(depth) hash values are kept in memory by every worker
+type streamingTree struct {
+	version          int
+	depth            int
+	proofLeavesNum   int
+	workFactor       int
+	hashName         string
+	description      string
+	workers          int
+	hasher           nodeHasher
+	nonLeafNodeCount int
+	trackHeight      int
+	rootHash         hash.Value
+	// tracker is kept from a constructor since subtrees are recomputed during GenerateProofOfWork
+	tracker *buildTracker
+}
+
+// confirm interface's implementation
+var _ merkle.Tree = (*streamingTree)(nil)
+
+func (rcv *streamingTree) Depth() int {
+	return rcv.depth
+}
+
+// streamingTrackHeight returns a height of subtrees after building which a streaming tree reports progress.
+// A subtree of such height takes about trackInterval hash computations, but it has two leaves at least
+func streamingTrackHeight(workFactor int) int {
+	height := 2
+	for leaves := 4; leaves*workFactor+leaves-1 <= trackInterval; leaves *= 2 {
+		height++
+	}
+	return height
+}
+
+// subtreeHeight returns a number of levels in a node's subtree
+func (rcv *streamingTree) subtreeHeight(nodeNum int) int {
+	return rcv.depth - bits.Len(uint(nodeNum+1)) + 1
+}
+
+// subtreeHashes returns a number of hash computations of a subtree with a given number of levels
+func (rcv *streamingTree) subtreeHashes(height int) int {
+	leaves := 1 << (height - 1)
+	return leaves*rcv.workFactor + leaves - 1
+}
+
+// subtreeHash computes a hash value of a node out of its whole subtree
+// and registers all the subtree's hash computations in the tracker
+func (rcv *streamingTree) subtreeHash(nodeNum int, workers int) (hash.Value, error) {
+	result, err := rcv.computeSubtreeHash(nodeNum, workers)
+	if err != nil {
+		return nil, err
+	}
+
+	// hash computations of subtrees with a track height are already registered
+	height := rcv.subtreeHeight(nodeNum)
+	untracked := rcv.subtreeHashes(height)
+	if height >= rcv.trackHeight {
+		untracked -= (1 << (height - rcv.trackHeight)) * rcv.subtreeHashes(rcv.trackHeight)
+	}
+	if untracked > 0 {
+		if err := rcv.tracker.add(untracked); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// computeSubtreeHash traverses a subtree depth-first.
+// Subtrees of the first levels are split among "workers" goroutines
+func (rcv *streamingTree) computeSubtreeHash(nodeNum int, workers int) (hash.Value, error) {
+	if nodeNum >= rcv.nonLeafNodeCount {
+		return rcv.hasher.leafHash(nodeNum), nil
+	}
+
+	leftSonNum, rightSonNum, err := getChildrenNums(nodeNum, rcv.depth)
+	if err != nil {
+		// unreachable since we are sure that nodes have their children
+		panic(err)
+	}
+
+	var leftHash, rightHash hash.Value
+	var leftErr, rightErr error
+	if workers > 1 {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			leftHash, leftErr = rcv.computeSubtreeHash(leftSonNum, workers/2)
+		}()
+		rightHash, rightErr = rcv.computeSubtreeHash(rightSonNum, workers-workers/2)
+		wg.Wait()
+	} else {
+		leftHash, leftErr = rcv.computeSubtreeHash(leftSonNum, 1)
+		if leftErr == nil {
+			rightHash, rightErr = rcv.computeSubtreeHash(rightSonNum, 1)
+		}
+	}
+	if leftErr != nil {
+		return nil, leftErr
+	}
+	if rightErr != nil {
+		return nil, rightErr
+	}
+
+	result := rcv.hasher.innerHash(nodeNum, leftHash, rightHash)
+	if rcv.subtreeHeight(nodeNum) == rcv.trackHeight {
+		if err := rcv.tracker.add(rcv.subtreeHashes(rcv.trackHeight)); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// GenerateProofOfWork recomputes subtrees that are needed to generate a proof of work.
+// Subtrees of needed nodes do not intersect, so the total cost is at most one more tree build
+func (rcv *streamingTree) GenerateProofOfWork() (merkle.ProofOfWork, error) {
+	leaves, err := rcv.hasher.selectLeaves(rcv.rootHash, rcv.depth, rcv.proofLeavesNum, math.MaxInt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select leaves for verification, error: %w", err)
+	}
+	neededNodes, err := getProofNodeNums(leaves, rcv.depth)
+	if err != nil {
+		return nil, err
+	}
+
+	neededHashes := make(map[int]hash.Value, len(neededNodes))
+	for _, nodeNum := range neededNodes {
+		rcv.tracker.extend(rcv.subtreeHashes(rcv.subtreeHeight(nodeNum)))
+	}
+	for _, nodeNum := range neededNodes {
+		neededHashes[nodeNum], err = rcv.subtreeHash(nodeNum, rcv.workers)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute hash of node %d: %w", nodeNum, err)
+		}
+	}
+
+	return &proofOfWork{
+		VersionVal: rcv.version,
+		NodesStats: newNodesStats(neededNodes, leaves, func(nodeNum int) hash.Value {
+			return neededHashes[nodeNum]
+		}),
+		HashNameVal:       rcv.hashName,
+		Description:       rcv.description,
+		DepthVal:          rcv.depth,
+		ProofLeavesNumVal: rcv.proofLeavesNum,
+		WorkFactorVal:     rcv.workFactor,
+	}, nil
+}
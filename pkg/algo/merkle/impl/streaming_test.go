@@ -0,0 +1,55 @@
+package impl
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/evilaffliction/merkle/pkg/algo/hash"
+)
+
+func TestStreamingTreeProofOfWork(t *testing.T) {
+	for _, version := range []int{ProofVersionSeeded, ProofVersionKeyed, ProofVersionPortable} {
+		for _, hashName := range []string{hash.MD5Name, hash.SHA256Name} {
+			for _, workers := range []int{1, 3} {
+				t.Run(fmt.Sprintf("version_%d_%s_workers_%d", version, hashName, workers), func(t *testing.T) {
+					inMemory, err := NewTree(hashName, 14, 7, "Lux in tenebris", WithProofVersion(version))
+					require.NoError(t, err)
+					streaming, err := NewTree(hashName, 14, 7, "Lux in tenebris",
+						WithProofVersion(version), WithStreamingMode(), WithWorkers(workers))
+					require.NoError(t, err)
+					require.IsType(t, &streamingTree{}, streaming)
+					assert.Equal(t, inMemory.Depth(), streaming.Depth())
+
+					inMemoryPow, err := inMemory.GenerateProofOfWork()
+					require.NoError(t, err)
+					streamingPow, err := streaming.GenerateProofOfWork()
+					require.NoError(t, err)
+					require.NoError(t, streamingPow.Verify())
+
+					inMemoryJSON, err := json.Marshal(inMemoryPow)
+					require.NoError(t, err)
+					streamingJSON, err := json.Marshal(streamingPow)
+					require.NoError(t, err)
+					assert.Equal(t, string(inMemoryJSON), string(streamingJSON))
+				})
+			}
+		}
+	}
+}
+
+func Benchmark_MD5_StreamingGenerationProofOfWork(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		t, err := NewTree("md5", 20, 10, fmt.Sprintf("bench_%d", i), WithStreamingMode())
+		assert.NoError(b, err)
+		assert.NotNil(b, t)
+
+		pow, err := t.GenerateProofOfWork()
+		assert.NoError(b, err)
+		assert.NotNil(b, pow)
+	}
+}
@@ -0,0 +1,84 @@
+package impl
+
+import (
+	"runtime"
+)
+
+type treeConfig struct {
+	version    int
+	workFactor int
+	workers    int
+	streaming  bool
+	progress   ProgressFunc
+}
+
+func newTreeConfigFromOptions(opts ...TreeOption) treeConfig {
+	// default values
+	cfg := treeConfig{
+		version:    CurrentProofVersion,
+		workFactor: 1,
+		workers:    runtime.GOMAXPROCS(0),
+	}
+
+	// overrides
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return cfg
+}
+
+// TreeOption allows to customize a merkle tree build process
+type TreeOption func(cfg *treeConfig)
+
+// WithProofVersion allows to specify a version of a proof of work format, and thus
+// a hashing scheme that is used to build a tree
+func WithProofVersion(version int) TreeOption {
+	return func(cfg *treeConfig) {
+		cfg.version = version
+	}
+}
+
+// WithWorkFactor allows to make every leaf "workFactor" times more expensive to compute.
+// Along with a depth it gives a fine control of a prover's work, see EstimateHashComputations
+func WithWorkFactor(workFactor int) TreeOption {
+	return func(cfg *treeConfig) {
+		cfg.workFactor = workFactor
+	}
+}
+
+// EstimateHashComputations returns a number of hash computations needed to build a tree.
+// A tree with a given depth has 2^(depth-1) leaves and 2^(depth-1) - 1 internal nodes, so
+// e.g. a tree with depth 20 and work factor 10 costs 10% more than the one with work factor 9
+func EstimateHashComputations(depth int, workFactor int) int {
+	leafNodeCount := 1 << (depth - 1)
+	return leafNodeCount*workFactor + leafNodeCount - 1
+}
+
+// WithWorkers allows to specify a number of goroutines that hash nodes of a tree simultaneously.
+// The built tree does not depend on a number of workers
+func WithWorkers(workers int) TreeOption {
+	if workers < 1 {
+		workers = 1
+	}
+	return func(cfg *treeConfig) {
+		cfg.workers = workers
+	}
+}
+
+// WithStreamingMode makes a tree to keep only its root hash instead of all the nodes.
+// Memory usage becomes O(depth) at a price of recomputing subtrees needed for a proof of work,
+// which is at most one more tree build. Generated proofs of work are identical to the in-memory ones
+func WithStreamingMode() TreeOption {
+	return func(cfg *treeConfig) {
+		cfg.streaming = true
+	}
+}
+
+// WithProgress allows to track a build process of a tree.
+// Calls of "progress" are serialized, it should return quickly since it blocks hashing workers
+func WithProgress(progress ProgressFunc) TreeOption {
+	return func(cfg *treeConfig) {
+		cfg.progress = progress
+	}
+}
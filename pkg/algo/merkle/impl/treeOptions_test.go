@@ -0,0 +1,34 @@
+package impl
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTreeConfigCreation(t *testing.T) {
+	assert.Equal(t, treeConfig{
+		version:    CurrentProofVersion,
+		workFactor: 1,
+		workers:    runtime.GOMAXPROCS(0),
+	}, newTreeConfigFromOptions())
+
+	assert.Equal(t, treeConfig{
+		version:    ProofVersionSeeded,
+		workFactor: 7,
+		workers:    1,
+		streaming:  true,
+	}, newTreeConfigFromOptions(
+		WithProofVersion(ProofVersionSeeded),
+		WithWorkFactor(7),
+		WithWorkers(-3),
+		WithStreamingMode(),
+	))
+}
+
+func TestEstimateHashComputations(t *testing.T) {
+	assert.Equal(t, 1<<20-1, EstimateHashComputations(20, 1))
+	assert.Equal(t, 1<<19*10+1<<19-1, EstimateHashComputations(20, 10))
+	assert.InDelta(t, 1.1, float64(EstimateHashComputations(20, 10))/float64(EstimateHashComputations(20, 9)), 0.01)
+}
@@ -1,10 +1,25 @@
 package merkle
 
+// Limits bound a verifier's job. A proof of work is checked against them
+// before any hash value is computed
+type Limits struct {
+	MaxDepth             int
+	MaxProofLeavesNum    int
+	MaxWorkFactor        int
+	MaxDescriptionLength int
+	// MaxHashComputations bounds a total number of hash computations during a verification
+	MaxHashComputations int
+}
+
 type ProofOfWork interface {
 	Verify() error
+	VerifyWithLimits(limits Limits) error
+	Version() int
 	AccessToken() string
 	Depth() int
 	ProofLeavesNum() int
+	WorkFactor() int
+	HashName() string
 }
 
 type Tree interface {
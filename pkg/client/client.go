@@ -0,0 +1,237 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/evilaffliction/merkle/pkg/algo/merkle/impl"
+	"github.com/evilaffliction/merkle/pkg/middleware"
+	"github.com/evilaffliction/merkle/pkg/rest"
+)
+
+// Transport is an http.RoundTripper that attaches a fresh proof of work to every request.
+//
+// When a server rejects a request and advertises its requirements with a middleware.RequirementsHeaderName header,
+// the transport remembers them for a route, i.e. a host and a path, since routes of a host may have different
+// policies, and retries the request once with a proof of work that satisfies them.
+// Requests with a body are retried only when the body can be obtained again, see http.Request.GetBody.
+//
+// When a server issues a session token, see middleware.WithSessionKeys, the transport sends it instead of
+// proofs of work to the same route until it is rejected
+type Transport struct {
+	cfg          config
+	mu           sync.RWMutex
+	requirements map[string]middleware.Requirements
+	sessions     map[string]string
+}
+
+// NewTransport creates a Transport
+func NewTransport(opts ...Option) *Transport {
+	return &Transport{
+		cfg:          newConfigFromOptions(opts...),
+		requirements: make(map[string]middleware.Requirements),
+		sessions:     make(map[string]string),
+	}
+}
+
+// NewHTTPClient returns an http.Client that solves proofs of work with a Transport
+func NewHTTPClient(opts ...Option) *http.Client {
+	return &http.Client{Transport: NewTransport(opts...)}
+}
+
+// Requirements returns requirements advertised for a path of a host, if any
+func (rcv *Transport) Requirements(host string, path string) (middleware.Requirements, bool) {
+	rcv.mu.RLock()
+	defer rcv.mu.RUnlock()
+	requirements, ok := rcv.requirements[routeKey(host, path)]
+	return requirements, ok
+}
+
+func (rcv *Transport) learn(host string, path string, requirements middleware.Requirements) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	rcv.requirements[routeKey(host, path)] = requirements
+}
+
+// routeKey identifies a route of a host, a path always starts with a slash
+func routeKey(host string, path string) string {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return host + path
+}
+
+func (rcv *Transport) session(host string, path string) (string, bool) {
+	rcv.mu.RLock()
+	defer rcv.mu.RUnlock()
+	token, ok := rcv.sessions[routeKey(host, path)]
+	return token, ok
+}
+
+func (rcv *Transport) setSession(host string, path string, token string) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	if token == "" {
+		delete(rcv.sessions, routeKey(host, path))
+		return
+	}
+	rcv.sessions[routeKey(host, path)] = token
+}
+
+// RoundTrip implements http.RoundTripper interface
+func (rcv *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := rcv.attempt(req)
+	if err != nil || resp.StatusCode != http.StatusNotAcceptable {
+		return resp, err
+	}
+	// a rejected session token is exhausted, expired or signed with a retired key
+	rcv.setSession(req.URL.Host, req.URL.Path, "")
+
+	hint := resp.Header.Get(middleware.RequirementsHeaderName)
+	if hint == "" {
+		return resp, nil
+	}
+	requirements, err := middleware.ParseRequirements(hint)
+	if err != nil {
+		// a rejection is still a valid response
+		return resp, nil
+	}
+	rcv.learn(req.URL.Host, req.URL.Path, requirements)
+
+	retry := req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			// a body is consumed by the first attempt and can not be sent again
+			return resp, nil
+		}
+		if retry.Body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	return rcv.attempt(retry)
+}
+
+// attempt sends a request with a session token or a fresh proof of work, a given request is not modified
+func (rcv *Transport) attempt(req *http.Request) (*http.Response, error) {
+	// a binding may replace a body of a clone, see middleware.RequestBinding
+	req = req.Clone(req.Context())
+	if token, ok := rcv.session(req.URL.Host, req.URL.Path); ok {
+		req.Header.Set(middleware.SessionHeaderName, token)
+	} else {
+		header, err := rcv.generateHeader(req)
+		if err != nil {
+			// a round tripper has to close a body even on errors
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			return nil, err
+		}
+		req.Header.Set(middleware.MerkleHeaderName, header)
+	}
+
+	resp, err := rcv.cfg.base.RoundTrip(req)
+	if err == nil {
+		if token := resp.Header.Get(middleware.SessionHeaderName); token != "" {
+			rcv.setSession(req.URL.Host, req.URL.Path, token)
+		}
+	}
+	return resp, err
+}
+
+// generateHeader builds a proof of work for a request that satisfies known requirements of its route
+func (rcv *Transport) generateHeader(req *http.Request) (string, error) {
+	ctx := req.Context()
+	requirements, known := rcv.Requirements(req.URL.Host, req.URL.Path)
+	hashName := rcv.cfg.hashName
+	if known && len(requirements.Hashes) > 0 && !slices.Contains(requirements.Hashes, hashName) {
+		hashName = requirements.Hashes[0]
+	}
+
+	cfg := rcv.cfg
+	bind, boundHeaders := cfg.requestBinding, cfg.boundHeaders
+	if known && requirements.BindingRequired {
+		bind, boundHeaders = true, requirements.BoundHeaders
+	}
+	if bind {
+		binding, err := middleware.RequestBinding(req, boundHeaders...)
+		if err != nil {
+			return "", fmt.Errorf("failed to bind proof of work to a request, error: %w", err)
+		}
+		// a full slice expression makes append to copy configured options instead of sharing them between requests
+		cfg.headerOpts = append(cfg.headerOpts[:len(cfg.headerOpts):len(cfg.headerOpts)],
+			middleware.WithBinding(binding))
+	}
+
+	if rcv.cfg.challengeURL != "" {
+		challenge, err := rcv.getChallenge(ctx)
+		if err != nil {
+			return "", err
+		}
+		header, err := middleware.GenerateMerkleHeaderForChallenge(ctx, challenge, hashName, cfg.headerOpts...)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate proof of work for a challenge, error: %w", err)
+		}
+		return header, nil
+	}
+
+	// pre-mined proofs of work can not be bound to a request
+	if rcv.cfg.pool != nil && !bind && (!known || rcv.cfg.pool.satisfies(requirements)) {
+		return rcv.cfg.pool.Get(ctx)
+	}
+
+	depth, proofLeavesNum, workFactor := rcv.cfg.depth, rcv.cfg.proofLeavesNum, rcv.cfg.workFactor
+	if known {
+		depth = min(max(depth, requirements.MinDepth), requirements.MaxDepth)
+		proofLeavesNum = min(max(proofLeavesNum, requirements.MinProofLeavesNum), requirements.MaxProofLeavesNum)
+		workFactor = min(max(workFactor, requirements.MinWorkFactor), requirements.MaxWorkFactor)
+	}
+	return generateHeader(ctx, cfg, hashName, depth, proofLeavesNum, workFactor)
+}
+
+// generateHeader builds a proof of work for a client-chosen access token
+func generateHeader(
+	ctx context.Context,
+	cfg config,
+	hashName string,
+	depth int,
+	proofLeavesNum int,
+	workFactor int,
+) (string, error) {
+	// a full slice expression makes append to copy configured options instead of sharing them between requests
+	opts := append(cfg.headerOpts[:len(cfg.headerOpts):len(cfg.headerOpts)],
+		middleware.WithTreeOptions(impl.WithWorkFactor(workFactor)))
+	header, err := middleware.GenerateMerkleHeaderWithContext(ctx, depth, proofLeavesNum, hashName, opts...)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate proof of work, error: %w", err)
+	}
+	return header, nil
+}
+
+// getChallenge requests a challenge with a base transport
+func (rcv *Transport) getChallenge(ctx context.Context) (middleware.Challenge, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rcv.cfg.challengeURL, nil)
+	if err != nil {
+		return middleware.Challenge{}, fmt.Errorf("failed to create http request, error: %w", err)
+	}
+
+	resp, err := rcv.cfg.base.RoundTrip(req)
+	if err != nil {
+		return middleware.Challenge{}, fmt.Errorf("failed to get a challenge from %q, error: %w",
+			rcv.cfg.challengeURL, err)
+	}
+	defer resp.Body.Close()
+
+	var challenge middleware.Challenge
+	if err := rest.ReadResponse(resp, &challenge); err != nil {
+		return middleware.Challenge{}, fmt.Errorf("failed to read challenge, error: %w", err)
+	}
+	return challenge, nil
+}
@@ -0,0 +1,162 @@
+package client
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/evilaffliction/merkle/pkg/middleware"
+)
+
+type config struct {
+	base           http.RoundTripper
+	hashName       string
+	depth          int
+	proofLeavesNum int
+	workFactor     int
+	challengeURL   string
+	headerOpts     []middleware.HeaderOption
+	pool           *Pool
+	poolSize       int
+	poolWorkers    int
+	proofLifeTime  time.Duration
+	proofMargin    time.Duration
+	autoSize       bool
+	minPoolSize    int
+	maxPoolSize    int
+	rateWindow     time.Duration
+	clock          middleware.Clock
+	requestBinding bool
+	boundHeaders   []string
+}
+
+func newConfigFromOptions(opts ...Option) config {
+	// default values
+	cfg := config{
+		base:           http.DefaultTransport,
+		hashName:       "sha256",
+		depth:          20,
+		proofLeavesNum: 5,
+		workFactor:     1,
+		poolSize:       2,
+		poolWorkers:    1,
+		proofLifeTime:  5 * time.Second,
+		proofMargin:    time.Second,
+		rateWindow:     10 * time.Second,
+		clock:          middleware.SystemClock{},
+	}
+
+	// overrides
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return cfg
+}
+
+// Option allows to customize Transport
+type Option func(cfg *config)
+
+// WithBase allows to specify a transport that sends requests, http.DefaultTransport by default
+func WithBase(base http.RoundTripper) Option {
+	return func(cfg *config) {
+		cfg.base = base
+	}
+}
+
+// WithHash allows to specify a preferred hash function. If a server does not accept it,
+// the first hash function advertised by the server is used instead
+func WithHash(hashName string) Option {
+	return func(cfg *config) {
+		cfg.hashName = hashName
+	}
+}
+
+// WithDifficulty allows to specify a merkle tree that is built until a server advertises its requirements.
+// Advertised requirements then clamp these values
+func WithDifficulty(depth, proofLeavesNum, workFactor int) Option {
+	return func(cfg *config) {
+		cfg.depth = depth
+		cfg.proofLeavesNum = proofLeavesNum
+		cfg.workFactor = workFactor
+	}
+}
+
+// WithChallengeURL makes a transport to build proofs of work for challenges issued by a given URL,
+// see middleware.GetChallengeHandler
+func WithChallengeURL(challengeURL string) Option {
+	return func(cfg *config) {
+		cfg.challengeURL = challengeURL
+	}
+}
+
+// WithHeaderOptions allows to customize generation of merkle headers, e.g. with middleware.WithBinaryEncoding
+func WithHeaderOptions(opts ...middleware.HeaderOption) Option {
+	return func(cfg *config) {
+		cfg.headerOpts = append(cfg.headerOpts, opts...)
+	}
+}
+
+// WithPool makes a transport to take proofs of work out of a pool of pre-mined ones.
+// The pool is bypassed for challenges and for hosts whose requirements it does not satisfy
+func WithPool(pool *Pool) Option {
+	return func(cfg *config) {
+		cfg.pool = pool
+	}
+}
+
+// WithPoolSize allows to specify a number of proofs of work a pool keeps ready
+func WithPoolSize(size int) Option {
+	return func(cfg *config) {
+		cfg.poolSize = size
+	}
+}
+
+// WithPoolWorkers allows to specify a number of goroutines that mine proofs of work of a pool simultaneously
+func WithPoolWorkers(workers int) Option {
+	if workers < 1 {
+		workers = 1
+	}
+	return func(cfg *config) {
+		cfg.poolWorkers = workers
+	}
+}
+
+// WithProofLifeTime allows to specify how long a server accepts a proof of work after its access token
+// is created, see middleware.WithAccessTokenLifeTime. A pool discards proofs of work "margin" before that
+// to leave time for a request to reach a server
+func WithProofLifeTime(lifeTime, margin time.Duration) Option {
+	return func(cfg *config) {
+		cfg.proofLifeTime = lifeTime
+		cfg.proofMargin = margin
+	}
+}
+
+// WithAutoPoolSize makes a pool to follow a rate of requests observed over a window,
+// see SuggestPoolSize. The size is kept within a given range
+func WithAutoPoolSize(minSize, maxSize int, window time.Duration) Option {
+	if minSize > maxSize {
+		minSize, maxSize = maxSize, minSize
+	}
+	return func(cfg *config) {
+		cfg.autoSize = true
+		cfg.minPoolSize = minSize
+		cfg.maxPoolSize = maxSize
+		cfg.rateWindow = window
+	}
+}
+
+// WithClock allows to substitute time, e.g. with a fake clock in tests
+func WithClock(clock middleware.Clock) Option {
+	return func(cfg *config) {
+		cfg.clock = clock
+	}
+}
+
+// WithRequestBinding makes a transport to bind every proof of work to its request with given headers,
+// see middleware.WithRequestBinding. Otherwise proofs of work are bound only for hosts that require it
+func WithRequestBinding(headers ...string) Option {
+	return func(cfg *config) {
+		cfg.requestBinding = true
+		cfg.boundHeaders = headers
+	}
+}
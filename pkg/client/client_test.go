@@ -0,0 +1,210 @@
+package client
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/evilaffliction/merkle/pkg/middleware"
+)
+
+// newServer starts a server with a protected "/ping" route and a "/strict" route that requires depths from 12 to 14,
+// it counts requests that reach the middleware
+func newServer(t *testing.T, opts ...middleware.Option) (*httptest.Server, *atomic.Int32) {
+	var hits atomic.Int32
+	merkleMiddleware := middleware.NewMerkleMiddleware(opts...)
+	r := gin.New()
+	r.GET("/challenge", merkleMiddleware.ChallengeHandler())
+	count := func(c *gin.Context) {
+		hits.Add(1)
+	}
+	echo := func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(200, "pong"+string(body))
+	}
+	r.GET("/ping", count, merkleMiddleware.Handler(), echo)
+	r.POST("/ping", count, merkleMiddleware.Handler(), echo)
+	r.GET("/strict", count, merkleMiddleware.Handler(middleware.WithAllowedDepthRange(12, 14)), echo)
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return server, &hits
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestTransport(t *testing.T) {
+	t.Run("Proof_of_work_is_attached", func(t *testing.T) {
+		server, hits := newServer(t, middleware.WithAllowedDepthRange(10, 12))
+		httpClient := NewHTTPClient(WithDifficulty(11, 3, 1))
+		for i := 0; i < 2; i++ {
+			resp, err := httpClient.Get(server.URL + "/ping")
+			require.NoError(t, err)
+			assert.Equal(t, 200, resp.StatusCode)
+			assert.Equal(t, "pong", readBody(t, resp))
+		}
+		assert.Equal(t, int32(2), hits.Load())
+	})
+
+	t.Run("Advertised_requirements_are_learned", func(t *testing.T) {
+		server, hits := newServer(t, middleware.WithAllowedDepthRange(12, 14),
+			middleware.WithAllowedWorkFactorRange(2, 4))
+		transport := NewTransport(WithDifficulty(10, 3, 1), WithHash("md5"))
+		httpClient := &http.Client{Transport: transport}
+
+		resp, err := httpClient.Get(server.URL + "/ping")
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Equal(t, "pong", readBody(t, resp))
+		assert.Equal(t, int32(2), hits.Load(), "a too easy proof of work is retried")
+
+		serverURL, err := url.Parse(server.URL)
+		require.NoError(t, err)
+		requirements, ok := transport.Requirements(serverURL.Host, "/ping")
+		require.True(t, ok)
+		assert.Equal(t, 12, requirements.MinDepth)
+		assert.Equal(t, 2, requirements.MinWorkFactor)
+
+		resp, err = httpClient.Get(server.URL + "/ping")
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+		readBody(t, resp)
+		assert.Equal(t, int32(3), hits.Load(), "learned requirements are satisfied at once")
+	})
+
+	t.Run("Requirements_are_learned_per_route", func(t *testing.T) {
+		server, hits := newServer(t, middleware.WithAllowedDepthRange(10, 12))
+		httpClient := NewHTTPClient(WithDifficulty(10, 3, 1), WithHash("md5"))
+		get := func(path string) {
+			resp, err := httpClient.Get(server.URL + path)
+			require.NoError(t, err)
+			assert.Equal(t, 200, resp.StatusCode)
+			readBody(t, resp)
+		}
+
+		get("/strict")
+		assert.Equal(t, int32(2), hits.Load(), "a too easy proof of work is retried")
+		get("/ping")
+		assert.Equal(t, int32(3), hits.Load(), "requirements of another route are not applied")
+		get("/strict")
+		assert.Equal(t, int32(4), hits.Load(), "learned requirements are satisfied at once")
+
+		serverURL, err := url.Parse(server.URL)
+		require.NoError(t, err)
+		_, ok := httpClient.Transport.(*Transport).Requirements(serverURL.Host, "/ping")
+		assert.False(t, ok)
+	})
+
+	t.Run("Advertised_hash_is_used", func(t *testing.T) {
+		transport := NewTransport(WithDifficulty(10, 3, 1), WithHash("unknown"))
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		_, err := transport.generateHeader(req)
+		assert.Error(t, err)
+
+		transport.learn("example.com", "/", middleware.Requirements{
+			MinDepth: 10, MaxDepth: 10, MinProofLeavesNum: 3, MaxProofLeavesNum: 3,
+			MinWorkFactor: 1, MaxWorkFactor: 1, Hashes: []string{"md5"},
+		})
+		_, err = transport.generateHeader(req)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Body_is_sent_again", func(t *testing.T) {
+		server, hits := newServer(t, middleware.WithAllowedDepthRange(12, 14))
+		httpClient := NewHTTPClient(WithDifficulty(10, 3, 1))
+		resp, err := httpClient.Post(server.URL+"/ping", "text/plain", strings.NewReader(", Bob"))
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Equal(t, "pong, Bob", readBody(t, resp))
+		assert.Equal(t, int32(2), hits.Load())
+	})
+
+	t.Run("Rejection_is_retried_once", func(t *testing.T) {
+		server, hits := newServer(t, middleware.WithChallengeSecret([]byte("secret")),
+			middleware.WithRequiredChallenge())
+		httpClient := NewHTTPClient(WithDifficulty(10, 3, 1))
+		resp, err := httpClient.Get(server.URL + "/ping")
+		require.NoError(t, err)
+		assert.Equal(t, 406, resp.StatusCode)
+		readBody(t, resp)
+		assert.Equal(t, int32(2), hits.Load())
+	})
+
+	t.Run("Challenges_are_solved", func(t *testing.T) {
+		server, hits := newServer(t, middleware.WithChallengeSecret([]byte("secret")),
+			middleware.WithRequiredChallenge(), middleware.WithChallengeDifficulty(12, 3, 1))
+		httpClient := NewHTTPClient(WithChallengeURL(server.URL+"/challenge"),
+			WithHeaderOptions(middleware.WithBinaryEncoding()))
+		resp, err := httpClient.Get(server.URL + "/ping")
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+		readBody(t, resp)
+		assert.Equal(t, int32(1), hits.Load())
+	})
+	t.Run("Binding_is_learned", func(t *testing.T) {
+		server, hits := newServer(t, middleware.WithAllowedDepthRange(10, 12),
+			middleware.WithRequestBinding("Content-Type"))
+		// pre-mined proofs of work are not bound, so a pool is bypassed once a binding is required
+		pool := NewPool(WithDifficulty(10, 3, 1))
+		defer pool.Close()
+		httpClient := NewHTTPClient(WithDifficulty(10, 3, 1), WithPool(pool))
+		resp, err := httpClient.Post(server.URL+"/ping", "text/plain", strings.NewReader(", Bob"))
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Equal(t, "pong, Bob", readBody(t, resp))
+		assert.Equal(t, int32(2), hits.Load())
+
+		serverURL, err := url.Parse(server.URL)
+		require.NoError(t, err)
+		requirements, ok := httpClient.Transport.(*Transport).Requirements(serverURL.Host, "/ping")
+		require.True(t, ok)
+		assert.True(t, requirements.BindingRequired)
+	})
+
+	t.Run("Requests_are_bound", func(t *testing.T) {
+		server, hits := newServer(t, middleware.WithAllowedDepthRange(10, 12),
+			middleware.WithRequestBinding("Content-Type"))
+		httpClient := NewHTTPClient(WithDifficulty(10, 3, 1), WithRequestBinding("Content-Type"))
+		resp, err := httpClient.Post(server.URL+"/ping", "text/plain", strings.NewReader(", Bob"))
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Equal(t, "pong, Bob", readBody(t, resp))
+		assert.Equal(t, int32(1), hits.Load())
+	})
+	t.Run("Sessions_are_used", func(t *testing.T) {
+		server, hits := newServer(t, middleware.WithAllowedDepthRange(10, 12),
+			middleware.WithSessionKeys(middleware.SessionKey{ID: "k1", Secret: []byte("secret")}),
+			middleware.WithSessionLimits(2, time.Minute), middleware.WithSessionMinDepth(10))
+		host := strings.TrimPrefix(server.URL, "http://")
+		transport := NewTransport(WithDifficulty(10, 3, 1))
+		httpClient := &http.Client{Transport: transport}
+		var tokens []string
+		for i := 0; i < 4; i++ {
+			resp, err := httpClient.Get(server.URL + "/ping")
+			require.NoError(t, err)
+			assert.Equal(t, 200, resp.StatusCode)
+			readBody(t, resp)
+			token, ok := transport.session(host, "/ping")
+			require.True(t, ok)
+			tokens = append(tokens, token)
+		}
+		// the first request buys a session, the next two spend it
+		// and the last one buys a new session after a rejection
+		assert.Equal(t, int32(5), hits.Load())
+		assert.Equal(t, tokens[0], tokens[2])
+		assert.NotEqual(t, tokens[0], tokens[3])
+	})
+}
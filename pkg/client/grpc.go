@@ -0,0 +1,127 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/evilaffliction/merkle/pkg/middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// UnaryClientInterceptor returns a gRPC interceptor that attaches a session token or a fresh proof of work
+// to every unary call. Requirements and session tokens are remembered per a target of a connection and a full method
+// name, which is a path of a call, and a rejected call is retried once, exactly as Transport does for HTTP requests
+func (rcv *Transport) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption,
+	) error {
+		invoke := func() error {
+			callCtx, err := rcv.attachToCall(ctx, cc.Target(), method)
+			if err != nil {
+				return err
+			}
+			var header metadata.MD
+			err = invoker(callCtx, method, req, reply, cc, append(opts, grpc.Header(&header))...)
+			rcv.rememberSession(cc.Target(), method, header)
+			return err
+		}
+
+		err := invoke()
+		if !rcv.learnFromRejection(cc.Target(), method, err) {
+			return err
+		}
+		return invoke()
+	}
+}
+
+// StreamClientInterceptor returns a gRPC interceptor that attaches a session token or a fresh proof of work
+// to every stream. A rejection of a stream is known only once a message is received, so a stream is never retried,
+// but requirements of a rejection are used for the next streams
+func (rcv *Transport) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+		streamer grpc.Streamer, opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		callCtx, err := rcv.attachToCall(ctx, cc.Target(), method)
+		if err != nil {
+			return nil, err
+		}
+		stream, err := streamer(callCtx, desc, cc, method, opts...)
+		if err != nil {
+			rcv.learnFromRejection(cc.Target(), method, err)
+			return nil, err
+		}
+		return &clientStream{ClientStream: stream, transport: rcv, target: cc.Target(), method: method}, nil
+	}
+}
+
+// UnaryClientInterceptor returns an interceptor of a new Transport, see Transport.UnaryClientInterceptor
+func UnaryClientInterceptor(opts ...Option) grpc.UnaryClientInterceptor {
+	return NewTransport(opts...).UnaryClientInterceptor()
+}
+
+// StreamClientInterceptor returns an interceptor of a new Transport, see Transport.StreamClientInterceptor
+func StreamClientInterceptor(opts ...Option) grpc.StreamClientInterceptor {
+	return NewTransport(opts...).StreamClientInterceptor()
+}
+
+// attachToCall puts a session token or a fresh proof of work into an outgoing metadata of a call
+func (rcv *Transport) attachToCall(ctx context.Context, target string, method string) (context.Context, error) {
+	if token, ok := rcv.session(target, method); ok {
+		return metadata.AppendToOutgoingContext(ctx, middleware.SessionHeaderName, token), nil
+	}
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	req := middleware.GRPCRequest(ctx, method, md)
+	req.URL.Host = target
+	header, err := rcv.generateHeader(req)
+	if err != nil {
+		return nil, err
+	}
+	return metadata.AppendToOutgoingContext(ctx, middleware.MerkleHeaderName, header), nil
+}
+
+// rememberSession keeps a session token issued for a method in a response metadata
+func (rcv *Transport) rememberSession(target string, method string, header metadata.MD) {
+	if tokens := header.Get(middleware.SessionHeaderName); len(tokens) > 0 && tokens[0] != "" {
+		rcv.setSession(target, method, tokens[0])
+	}
+}
+
+// learnFromRejection remembers requirements of a rejected call of a method and reports whether it is worth to retry it
+func (rcv *Transport) learnFromRejection(target string, method string, err error) bool {
+	problem, _, ok := middleware.GRPCProblem(err)
+	if !ok {
+		return false
+	}
+	// a rejected session token is exhausted, expired or signed with a retired key
+	rcv.setSession(target, method, "")
+	rcv.learn(target, method, problem.Requirements)
+	// a saturated server does not get better from an immediate retry
+	return problem.Code != middleware.ErrorCodeSaturated && problem.Code != middleware.ErrorCodeInternal
+}
+
+// clientStream learns from a response of a server once the first message or an error is received
+type clientStream struct {
+	grpc.ClientStream
+	transport *Transport
+	target    string
+	method    string
+	once      sync.Once
+}
+
+// RecvMsg implements grpc.ClientStream interface
+func (rcv *clientStream) RecvMsg(m any) error {
+	err := rcv.ClientStream.RecvMsg(m)
+	rcv.once.Do(func() {
+		if header, headerErr := rcv.ClientStream.Header(); headerErr == nil {
+			rcv.transport.rememberSession(rcv.target, rcv.method, header)
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			rcv.transport.learnFromRejection(rcv.target, rcv.method, err)
+		}
+	})
+	return err
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/evilaffliction/merkle/internal/grpctest"
+	"github.com/evilaffliction/merkle/pkg/middleware"
+)
+
+// newGRPCHealthClient serves a health service behind merkle interceptors over an in-memory connection,
+// it counts calls that reach the service
+func newGRPCHealthClient(
+	t *testing.T,
+	transport *Transport,
+	opts ...middleware.Option,
+) (healthpb.HealthClient, *atomic.Int32) {
+	merkleMiddleware := middleware.NewMerkleMiddleware(opts...)
+	var served atomic.Int32
+	count := func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		served.Add(1)
+		return handler(ctx, req)
+	}
+	serverOpts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(merkleMiddleware.UnaryServerInterceptor(), count),
+		grpc.StreamInterceptor(merkleMiddleware.StreamServerInterceptor()),
+	}
+	healthClient := grpctest.NewHealthClient(t, serverOpts,
+		grpc.WithUnaryInterceptor(transport.UnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(transport.StreamClientInterceptor()))
+	return healthClient, &served
+}
+
+func TestGRPCInterceptors(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Proof_of_work_is_attached", func(t *testing.T) {
+		transport := NewTransport(WithHash("md5"), WithDifficulty(10, 3, 1))
+		healthClient, served := newGRPCHealthClient(t, transport, middleware.WithAllowedDepthRange(10, 12))
+		for i := 0; i < 3; i++ {
+			_, err := healthClient.Check(ctx, &healthpb.HealthCheckRequest{})
+			require.NoError(t, err)
+		}
+		assert.Equal(t, int32(3), served.Load())
+	})
+
+	t.Run("Advertised_requirements_are_learned", func(t *testing.T) {
+		transport := NewTransport(WithHash("md5"), WithDifficulty(10, 3, 1))
+		healthClient, served := newGRPCHealthClient(t, transport, middleware.WithAllowedDepthRange(11, 12))
+		_, err := healthClient.Check(ctx, &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, int32(1), served.Load(), "a rejected call is retried once")
+		requirements, ok := transport.Requirements(grpctest.Target, healthpb.Health_Check_FullMethodName)
+		require.True(t, ok)
+		assert.Equal(t, 11, requirements.MinDepth)
+
+		// a rejected stream is not retried, but the next one satisfies learned requirements
+		transport = NewTransport(WithHash("md5"), WithDifficulty(10, 3, 1))
+		healthClient, _ = newGRPCHealthClient(t, transport, middleware.WithAllowedDepthRange(11, 12))
+		watch := func() error {
+			streamCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			stream, err := healthClient.Watch(streamCtx, &healthpb.HealthCheckRequest{})
+			if err != nil {
+				return err
+			}
+			_, err = stream.Recv()
+			return err
+		}
+		problem, _, ok := middleware.GRPCProblem(watch())
+		require.True(t, ok)
+		assert.Equal(t, middleware.ErrorCodeTooEasy, problem.Code)
+		assert.NoError(t, watch())
+	})
+
+	t.Run("Calls_are_bound", func(t *testing.T) {
+		transport := NewTransport(WithHash("md5"), WithDifficulty(10, 3, 1), WithRequestBinding("X-Tenant"))
+		healthClient, served := newGRPCHealthClient(t, transport, middleware.WithAllowedDepthRange(10, 12),
+			middleware.WithRequestBinding("X-Tenant"))
+		_, err := healthClient.Check(metadata.AppendToOutgoingContext(ctx, "x-tenant", "alpha"),
+			&healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, int32(1), served.Load())
+	})
+
+	t.Run("Sessions_are_used", func(t *testing.T) {
+		transport := NewTransport(WithHash("md5"), WithDifficulty(10, 3, 1))
+		healthClient, served := newGRPCHealthClient(t, transport, middleware.WithAllowedDepthRange(10, 12),
+			middleware.WithSessionKeys(middleware.SessionKey{ID: "k1", Secret: []byte("secret")}),
+			middleware.WithSessionLimits(2, time.Minute), middleware.WithSessionMinDepth(10))
+		_, err := healthClient.Check(ctx, &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		token, ok := transport.session(grpctest.Target, healthpb.Health_Check_FullMethodName)
+		require.True(t, ok)
+
+		// two uses of a session and a fresh proof of work once it is exhausted
+		for i := 0; i < 3; i++ {
+			_, err = healthClient.Check(ctx, &healthpb.HealthCheckRequest{})
+			require.NoError(t, err)
+		}
+		assert.Equal(t, int32(4), served.Load())
+		newToken, ok := transport.session(grpctest.Target, healthpb.Health_Check_FullMethodName)
+		require.True(t, ok)
+		assert.NotEqual(t, token, newToken)
+	})
+}
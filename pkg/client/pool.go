@@ -0,0 +1,314 @@
+package client
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/evilaffliction/merkle/pkg/algo/merkle/impl"
+	"github.com/evilaffliction/merkle/pkg/middleware"
+)
+
+// minedProof is a ready merkle header
+type minedProof struct {
+	header    string
+	expiresAt time.Time
+}
+
+// PoolStats describe how useful pre-mining is
+type PoolStats struct {
+	// Hits is a number of proofs of work handed out at once
+	Hits int64
+	// Misses is a number of proofs of work mined on demand since a pool was empty
+	Misses int64
+	// Mined is a number of proofs of work mined in the background
+	Mined int64
+	// Expired is a number of proofs of work discarded before they were handed out
+	Expired int64
+	// WastedHashComputations is a number of hash computations spent on expired proofs of work
+	WastedHashComputations int64
+}
+
+// HitRate returns a share of requests served without waiting for a proof of work
+func (rcv PoolStats) HitRate() float64 {
+	if rcv.Hits+rcv.Misses == 0 {
+		return 0
+	}
+	return float64(rcv.Hits) / float64(rcv.Hits+rcv.Misses)
+}
+
+// WasteRate returns a share of proofs of work mined in the background that expired
+func (rcv PoolStats) WasteRate() float64 {
+	if rcv.Mined == 0 {
+		return 0
+	}
+	return float64(rcv.Expired) / float64(rcv.Mined)
+}
+
+// SuggestPoolSize returns a number of proofs of work to keep ready for a given request rate:
+// enough to serve requests while replacements are mined, but no more than requests consume
+// before proofs of work expire
+func SuggestPoolSize(requestRate float64, miningTime time.Duration, usableLifeTime time.Duration) int {
+	needed := math.Ceil(requestRate * miningTime.Seconds())
+	consumable := math.Floor(requestRate * usableLifeTime.Seconds())
+	return int(min(needed, consumable))
+}
+
+// getBucket counts proofs of work requested from a pool within a single second
+type getBucket struct {
+	second int64
+	hits   int
+	misses int
+}
+
+// getCounter counts hits and misses of a pool over a sliding window of whole seconds,
+// it is guarded by a lock of a pool
+type getCounter struct {
+	buckets []getBucket
+}
+
+func newGetCounter(window time.Duration) getCounter {
+	return getCounter{buckets: make([]getBucket, max(int(window/time.Second), 1))}
+}
+
+func (rcv *getCounter) add(now time.Time, hit bool) {
+	second := now.Unix()
+	bucket := &rcv.buckets[second%int64(len(rcv.buckets))]
+	if bucket.second != second {
+		*bucket = getBucket{second: second}
+	}
+	if hit {
+		bucket.hits++
+	} else {
+		bucket.misses++
+	}
+}
+
+// rate returns a number of proofs of work requested per second within a window
+func (rcv *getCounter) rate(now time.Time) float64 {
+	oldestSecond := now.Unix() - int64(len(rcv.buckets)) + 1
+	var gets int
+	for _, bucket := range rcv.buckets {
+		if bucket.second >= oldestSecond {
+			gets += bucket.hits + bucket.misses
+		}
+	}
+	return float64(gets) / float64(len(rcv.buckets))
+}
+
+// Pool mines proofs of work for client-chosen access tokens in the background and hands them out
+// instantly. Proofs of work are discarded before a server stops accepting them, see WithProofLifeTime.
+//
+// A pool uses WithHash, WithDifficulty and WithHeaderOptions of a client, its size is set by WithPoolSize
+// or follows an observed request rate with WithAutoPoolSize.
+// A pool has to be closed to stop mining
+type Pool struct {
+	cfg        config
+	gets       getCounter
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+	mu         sync.Mutex
+	ready      []minedProof
+	mining     int
+	miningTime time.Duration
+	stats      PoolStats
+	changed    chan struct{}
+}
+
+// NewPool creates a Pool and starts mining
+func NewPool(opts ...Option) *Pool {
+	cfg := newConfigFromOptions(opts...)
+	ctx, cancel := context.WithCancel(context.Background())
+	rcv := &Pool{
+		cfg:     cfg,
+		gets:    newGetCounter(cfg.rateWindow),
+		cancel:  cancel,
+		changed: make(chan struct{}),
+	}
+	rcv.wg.Add(cfg.poolWorkers)
+	for i := 0; i < cfg.poolWorkers; i++ {
+		go rcv.mine(ctx)
+	}
+	return rcv
+}
+
+// Close stops mining and waits for workers to finish
+func (rcv *Pool) Close() {
+	rcv.cancel()
+	rcv.wg.Wait()
+}
+
+// Get returns a ready proof of work at once, or mines one when a pool is empty
+func (rcv *Pool) Get(ctx context.Context) (string, error) {
+	rcv.mu.Lock()
+	now := rcv.cfg.clock.Now()
+	rcv.discardExpired(now)
+	if len(rcv.ready) > 0 {
+		proof := rcv.ready[0]
+		rcv.ready = rcv.ready[1:]
+		rcv.stats.Hits++
+		rcv.gets.add(now, true)
+		rcv.notify()
+		rcv.mu.Unlock()
+		return proof.header, nil
+	}
+	rcv.stats.Misses++
+	rcv.gets.add(now, false)
+	rcv.notify()
+	rcv.mu.Unlock()
+
+	header, _, err := rcv.generate(ctx)
+	return header, err
+}
+
+// Ready returns a number of proofs of work that can be handed out at once
+func (rcv *Pool) Ready() int {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	rcv.discardExpired(rcv.cfg.clock.Now())
+	return len(rcv.ready)
+}
+
+// Stats returns statistics of a pool
+func (rcv *Pool) Stats() PoolStats {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	rcv.discardExpired(rcv.cfg.clock.Now())
+	return rcv.stats
+}
+
+// Size returns a number of proofs of work a pool is going to keep ready at the moment
+func (rcv *Pool) Size() int {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return rcv.size()
+}
+
+// size has to be called under the lock
+func (rcv *Pool) size() int {
+	if !rcv.cfg.autoSize {
+		return rcv.cfg.poolSize
+	}
+	suggested := SuggestPoolSize(rcv.gets.rate(rcv.cfg.clock.Now()), rcv.miningTime, rcv.usableLifeTime())
+	return min(max(suggested, rcv.cfg.minPoolSize), rcv.cfg.maxPoolSize)
+}
+
+func (rcv *Pool) usableLifeTime() time.Duration {
+	return rcv.cfg.proofLifeTime - rcv.cfg.proofMargin
+}
+
+// satisfies reports whether proofs of work of a pool are acceptable for a host with given requirements
+func (rcv *Pool) satisfies(requirements middleware.Requirements) bool {
+	return !requirements.ChallengeRequired &&
+		rcv.cfg.depth >= requirements.MinDepth && rcv.cfg.depth <= requirements.MaxDepth &&
+		rcv.cfg.proofLeavesNum >= requirements.MinProofLeavesNum &&
+		rcv.cfg.proofLeavesNum <= requirements.MaxProofLeavesNum &&
+		rcv.cfg.workFactor >= requirements.MinWorkFactor && rcv.cfg.workFactor <= requirements.MaxWorkFactor &&
+		(len(requirements.Hashes) == 0 || slices.Contains(requirements.Hashes, rcv.cfg.hashName))
+}
+
+// notify wakes up idle workers, it has to be called under the lock
+func (rcv *Pool) notify() {
+	close(rcv.changed)
+	rcv.changed = make(chan struct{})
+}
+
+// discardExpired has to be called under the lock
+func (rcv *Pool) discardExpired(now time.Time) {
+	expired := sort.Search(len(rcv.ready), func(i int) bool {
+		return rcv.ready[i].expiresAt.After(now)
+	})
+	if expired == 0 {
+		return
+	}
+	rcv.ready = rcv.ready[expired:]
+	rcv.stats.Expired += int64(expired)
+	rcv.stats.WastedHashComputations += int64(expired) *
+		int64(impl.EstimateHashComputations(rcv.cfg.depth, rcv.cfg.workFactor))
+	rcv.notify()
+}
+
+// generate mines a proof of work and remembers how long it took,
+// the proof of work is accepted by a server until a returned time
+func (rcv *Pool) generate(ctx context.Context) (string, time.Time, error) {
+	// an access token is created after the start, so it lives a bit longer than estimated
+	start := rcv.cfg.clock.Now()
+	header, err := generateHeader(ctx, rcv.cfg, rcv.cfg.hashName, rcv.cfg.depth, rcv.cfg.proofLeavesNum,
+		rcv.cfg.workFactor)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	elapsed := rcv.cfg.clock.Now().Sub(start)
+
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	if rcv.miningTime == 0 {
+		rcv.miningTime = elapsed
+	} else {
+		// an exponential moving average smooths out outliers
+		rcv.miningTime = (rcv.miningTime*7 + elapsed) / 8
+	}
+	return header, start.Add(rcv.usableLifeTime()), nil
+}
+
+// mine keeps a pool full until a context is cancelled
+func (rcv *Pool) mine(ctx context.Context) {
+	defer rcv.wg.Done()
+	for ctx.Err() == nil {
+		rcv.mu.Lock()
+		now := rcv.cfg.clock.Now()
+		rcv.discardExpired(now)
+		if len(rcv.ready)+rcv.mining < rcv.size() {
+			rcv.mining++
+			rcv.mu.Unlock()
+			rcv.mineOne(ctx)
+			continue
+		}
+		changed := rcv.changed
+		// a size may follow a request rate, so it is rechecked from time to time
+		wait := rcv.usableLifeTime()
+		if len(rcv.ready) > 0 {
+			wait = min(wait, rcv.ready[0].expiresAt.Sub(now))
+		}
+		rcv.mu.Unlock()
+
+		timer := time.NewTimer(max(wait, time.Millisecond))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-changed:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+func (rcv *Pool) mineOne(ctx context.Context) {
+	header, expiresAt, err := rcv.generate(ctx)
+
+	rcv.mu.Lock()
+	rcv.mining--
+	if err == nil {
+		// proofs of work are kept sorted by expiration, so the oldest ones are handed out first
+		i := sort.Search(len(rcv.ready), func(i int) bool {
+			return rcv.ready[i].expiresAt.After(expiresAt)
+		})
+		rcv.ready = slices.Insert(rcv.ready, i, minedProof{header: header, expiresAt: expiresAt})
+		rcv.stats.Mined++
+	}
+	rcv.mu.Unlock()
+
+	if err != nil && ctx.Err() == nil {
+		// e.g. an unknown hash, there is no point to retry at once
+		timer := time.NewTimer(time.Second)
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/evilaffliction/merkle/pkg/algo/merkle/impl"
+	"github.com/evilaffliction/merkle/pkg/middleware"
+)
+
+func TestPool(t *testing.T) {
+	clock := middleware.NewManualClock(time.Unix(1700000000, 0))
+	pool := NewPool(WithDifficulty(10, 3, 2), WithHash("md5"), WithPoolSize(3), WithPoolWorkers(2),
+		WithProofLifeTime(5*time.Second, time.Second), WithClock(clock))
+	defer pool.Close()
+
+	require.Eventually(t, func() bool { return pool.Ready() == 3 }, 5*time.Second, time.Millisecond)
+	header, err := pool.Get(context.Background())
+	require.NoError(t, err)
+	assert.NotEmpty(t, header)
+	stats := pool.Stats()
+	assert.Equal(t, int64(1), stats.Hits)
+	assert.Equal(t, 1.0, stats.HitRate())
+
+	// a pool is refilled
+	require.Eventually(t, func() bool { return pool.Ready() == 3 }, 5*time.Second, time.Millisecond)
+	assert.Equal(t, int64(4), pool.Stats().Mined)
+
+	// proofs of work are discarded a margin before they expire
+	clock.Advance(4 * time.Second)
+	require.Eventually(t, func() bool { return pool.Stats().Expired == 3 }, 5*time.Second, time.Millisecond)
+	stats = pool.Stats()
+	assert.Equal(t, int64(3*impl.EstimateHashComputations(10, 2)), stats.WastedHashComputations)
+	assert.Greater(t, stats.WasteRate(), 0.0)
+
+	require.Eventually(t, func() bool { return pool.Ready() == 3 }, 5*time.Second, time.Millisecond)
+	pool.Close()
+	for i := 0; i < 4; i++ {
+		_, err := pool.Get(context.Background())
+		require.NoError(t, err)
+	}
+	stats = pool.Stats()
+	assert.Equal(t, int64(4), stats.Hits)
+	assert.Equal(t, int64(1), stats.Misses, "an empty pool mines on demand")
+	assert.Equal(t, 0.8, stats.HitRate())
+}
+
+func TestPoolSize(t *testing.T) {
+	assert.Equal(t, 0, SuggestPoolSize(0, time.Second, 4*time.Second))
+	assert.Equal(t, 5, SuggestPoolSize(10, 500*time.Millisecond, 4*time.Second))
+	// there is no point to keep proofs of work that expire before they are requested
+	assert.Equal(t, 2, SuggestPoolSize(0.5, 10*time.Second, 4*time.Second))
+
+	clock := middleware.NewManualClock(time.Unix(1700000000, 0))
+	pool := NewPool(WithDifficulty(10, 3, 1), WithHash("md5"), WithAutoPoolSize(8, 1, 2*time.Second),
+		WithClock(clock))
+	defer pool.Close()
+	assert.Equal(t, 1, pool.Size())
+
+	for i := 0; i < 20; i++ {
+		_, err := pool.Get(context.Background())
+		require.NoError(t, err)
+	}
+	// a fake clock makes mining to take no time
+	pool.mu.Lock()
+	pool.miningTime = 500 * time.Millisecond
+	pool.mu.Unlock()
+	// 10 requests per second are served while a replacement is mined for half a second
+	assert.Equal(t, 5, pool.Size())
+
+	// requests leave a window
+	clock.Advance(2 * time.Second)
+	assert.Equal(t, 1, pool.Size())
+}
+
+func TestTransportWithPool(t *testing.T) {
+	server, hits := newServer(t, middleware.WithAllowedDepthRange(10, 12))
+	pool := NewPool(WithDifficulty(11, 3, 1), WithHash("md5"), WithPoolSize(1))
+	defer pool.Close()
+	require.Eventually(t, func() bool { return pool.Ready() == 1 }, 5*time.Second, time.Millisecond)
+
+	httpClient := &http.Client{Transport: NewTransport(WithPool(pool))}
+	resp, err := httpClient.Get(server.URL + "/ping")
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	readBody(t, resp)
+	assert.Equal(t, int64(1), pool.Stats().Hits)
+
+	// a pool that does not satisfy requirements of a host is bypassed
+	server, hits = newServer(t, middleware.WithAllowedDepthRange(12, 14))
+	for i := 0; i < 2; i++ {
+		resp, err = httpClient.Get(server.URL + "/ping")
+		require.NoError(t, err)
+		assert.Equal(t, 200, resp.StatusCode)
+		readBody(t, resp)
+	}
+	assert.Equal(t, int32(3), hits.Load())
+	stats := pool.Stats()
+	assert.Equal(t, int64(2), stats.Hits+stats.Misses, "only the first request to the host is served by the pool")
+}
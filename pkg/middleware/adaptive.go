@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+)
+
+// LoadThresholds define when a server is overloaded, zero values are ignored
+type LoadThresholds struct {
+	RequestRate         float64
+	InFlight            int
+	VerificationLatency time.Duration
+}
+
+// exceeded reports whether any of the thresholds multiplied by "ratio" is exceeded by a load
+func (rcv LoadThresholds) exceeded(load Load, ratio float64) bool {
+	return (rcv.RequestRate > 0 && load.RequestRate > rcv.RequestRate*ratio) ||
+		(rcv.InFlight > 0 && float64(load.InFlight) > float64(rcv.InFlight)*ratio) ||
+		(rcv.VerificationLatency > 0 && float64(load.VerificationLatency) > float64(rcv.VerificationLatency)*ratio)
+}
+
+// AdaptiveDifficulty raises min acceptable depth and proof leaves num of a merkle middleware
+// step by step while a server is overloaded, and lowers them back when the load goes down.
+//
+// Hysteresis avoids flapping: a level is raised as soon as any threshold is exceeded,
+// but it is lowered only when every load value is below its threshold multiplied by a lower ratio.
+// Levels change at most once per cooldown period
+type AdaptiveDifficulty struct {
+	mu         sync.Mutex
+	cfg        adaptiveConfig
+	signal     LoadSignal
+	level      int
+	lastChange time.Time
+}
+
+// NewAdaptiveDifficulty creates a controller driven by a given load signal, see WithAdaptiveDifficulty
+func NewAdaptiveDifficulty(signal LoadSignal, opts ...AdaptiveOption) *AdaptiveDifficulty {
+	cfg := newAdaptiveConfigFromOptions(opts...)
+	return &AdaptiveDifficulty{
+		cfg:        cfg,
+		signal:     signal,
+		lastChange: cfg.clock.Now(),
+	}
+}
+
+// Level re-evaluates a load and returns a current level, 0 means no extra difficulty
+func (rcv *AdaptiveDifficulty) Level() int {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	now := rcv.cfg.clock.Now()
+	if now.Sub(rcv.lastChange) < rcv.cfg.cooldown {
+		return rcv.level
+	}
+
+	load := rcv.signal.Load()
+	switch {
+	case rcv.level < rcv.cfg.maxLevel && rcv.cfg.thresholds.exceeded(load, 1):
+		rcv.level++
+		rcv.lastChange = now
+	case rcv.level > 0 && !rcv.cfg.thresholds.exceeded(load, rcv.cfg.lowerRatio):
+		rcv.level--
+		rcv.lastChange = now
+	}
+	return rcv.level
+}
+
+// ExtraDifficulty returns how much min acceptable depth and proof leaves num are raised at the moment
+func (rcv *AdaptiveDifficulty) ExtraDifficulty() (extraDepth int, extraProofLeavesNum int) {
+	level := rcv.Level()
+	return level * rcv.cfg.depthStep, level * rcv.cfg.proofLeavesNumStep
+}
+
+// retryAfter returns how long it takes for a raised level to be lowered at the earliest
+func (rcv *AdaptiveDifficulty) retryAfter() time.Duration {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	if rcv.level == 0 {
+		return 0
+	}
+	return max(rcv.cfg.cooldown-rcv.cfg.clock.Now().Sub(rcv.lastChange), time.Second)
+}
+
+// observer returns the load signal if it measures requests by itself
+func (rcv *AdaptiveDifficulty) observer() RequestObserver {
+	observer, _ := rcv.signal.(RequestObserver)
+	return observer
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"time"
+)
+
+type adaptiveConfig struct {
+	thresholds         LoadThresholds
+	lowerRatio         float64
+	cooldown           time.Duration
+	depthStep          int
+	proofLeavesNumStep int
+	maxLevel           int
+	clock              Clock
+}
+
+func newAdaptiveConfigFromOptions(opts ...AdaptiveOption) adaptiveConfig {
+	// default values
+	cfg := adaptiveConfig{
+		lowerRatio:         0.5,
+		cooldown:           10 * time.Second,
+		depthStep:          1,
+		proofLeavesNumStep: 0,
+		maxLevel:           5,
+		clock:              SystemClock{},
+	}
+
+	// overrides
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return cfg
+}
+
+// AdaptiveOption allows to customize AdaptiveDifficulty
+type AdaptiveOption func(cfg *adaptiveConfig)
+
+// WithLoadThresholds allows to specify a load that makes difficulty to be raised
+func WithLoadThresholds(thresholds LoadThresholds) AdaptiveOption {
+	return func(cfg *adaptiveConfig) {
+		cfg.thresholds = thresholds
+	}
+}
+
+// WithLowerRatio allows to specify a hysteresis: difficulty is lowered only when every load value
+// is below its threshold multiplied by "ratio"
+func WithLowerRatio(ratio float64) AdaptiveOption {
+	return func(cfg *adaptiveConfig) {
+		cfg.lowerRatio = ratio
+	}
+}
+
+// WithCooldown allows to specify min time between two changes of difficulty
+func WithCooldown(d time.Duration) AdaptiveOption {
+	return func(cfg *adaptiveConfig) {
+		cfg.cooldown = d
+	}
+}
+
+// WithDifficultyStep allows to specify how much min depth and proof leaves num are raised per level
+func WithDifficultyStep(depthStep, proofLeavesNumStep int) AdaptiveOption {
+	return func(cfg *adaptiveConfig) {
+		cfg.depthStep = depthStep
+		cfg.proofLeavesNumStep = proofLeavesNumStep
+	}
+}
+
+// WithMaxLevel allows to specify how many times difficulty can be raised
+func WithMaxLevel(level int) AdaptiveOption {
+	return func(cfg *adaptiveConfig) {
+		cfg.maxLevel = level
+	}
+}
+
+// WithClock allows to substitute time, e.g. with a fake clock in tests
+func WithClock(clock Clock) AdaptiveOption {
+	return func(cfg *adaptiveConfig) {
+		cfg.clock = clock
+	}
+}
@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLoadSignal returns whatever load a test sets
+type fakeLoadSignal struct {
+	load Load
+}
+
+func (rcv *fakeLoadSignal) Load() Load {
+	return rcv.load
+}
+
+func TestAdaptiveDifficulty(t *testing.T) {
+	clock := newFakeClock()
+	signal := &fakeLoadSignal{}
+	controller := NewAdaptiveDifficulty(signal,
+		WithLoadThresholds(LoadThresholds{RequestRate: 100, InFlight: 10, VerificationLatency: 10 * time.Millisecond}),
+		WithCooldown(time.Second),
+		WithDifficultyStep(2, 1),
+		WithMaxLevel(2),
+		WithClock(clock),
+	)
+	expectLevel := func(level int) {
+		t.Helper()
+		assert.Equal(t, level, controller.Level())
+	}
+
+	clock.Advance(time.Second)
+	expectLevel(0)
+
+	// every threshold raises a level on its own, but not more often than once per cooldown
+	signal.load = Load{RequestRate: 150}
+	expectLevel(1)
+	signal.load = Load{InFlight: 11}
+	expectLevel(1)
+	clock.Advance(time.Second)
+	expectLevel(2)
+	extraDepth, extraProofLeavesNum := controller.ExtraDifficulty()
+	assert.Equal(t, 4, extraDepth)
+	assert.Equal(t, 2, extraProofLeavesNum)
+
+	// max level is never exceeded
+	signal.load = Load{VerificationLatency: time.Second}
+	clock.Advance(time.Second)
+	expectLevel(2)
+
+	// hysteresis: a load below thresholds, but above their half keeps a level
+	signal.load = Load{RequestRate: 60, InFlight: 4, VerificationLatency: time.Millisecond}
+	clock.Advance(time.Second)
+	expectLevel(2)
+
+	signal.load = Load{RequestRate: 40, InFlight: 4, VerificationLatency: time.Millisecond}
+	clock.Advance(time.Second)
+	expectLevel(1)
+	clock.Advance(time.Second)
+	expectLevel(0)
+	clock.Advance(time.Second)
+	expectLevel(0)
+}
+
+func TestMerkleAdaptiveDifficulty(t *testing.T) {
+	clock := newFakeClock()
+	meter := NewRequestMeter(time.Second, clock)
+	controller := NewAdaptiveDifficulty(meter,
+		WithLoadThresholds(LoadThresholds{RequestRate: 2}),
+		WithCooldown(0),
+		WithDifficultyStep(5, 0),
+		WithMaxLevel(1),
+		WithClock(clock),
+	)
+	r := gin.New()
+	r.Use(GetMerkleMiddleware(WithAllowedDepthRange(10, 12), WithAdaptiveDifficulty(controller)))
+	r.GET("/ping", func(c *gin.Context) {
+		c.String(200, "pong")
+	})
+	call := func(depth int) int {
+		headerPayload, err := GenerateMerkleHeader(depth, 3, "md5")
+		require.NoError(t, err)
+		req, err := http.NewRequest("GET", "/ping", nil)
+		require.NoError(t, err)
+		req.Header.Set(MerkleHeaderName, headerPayload)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, 200, call(10))
+	assert.Equal(t, 200, call(10))
+	assert.Equal(t, 0, meter.Load().InFlight)
+
+	// the third request within a second overloads a server, min depth is raised up to max allowed depth
+	assert.Equal(t, 406, call(10))
+	assert.Equal(t, 406, call(11))
+	assert.Equal(t, 200, call(12))
+
+	clock.Advance(time.Minute)
+	assert.Equal(t, 200, call(10))
+}
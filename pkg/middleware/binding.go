@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// bindingSeparator separates a request binding from an access token or a challenge in a merkle tree description,
+// neither of base64 alphabets has a tilde
+const bindingSeparator = "~"
+
+// bindingVersion allows to change a way a binding is computed without confusing old provers
+const bindingVersion = "b2"
+
+// bindDescription appends a request binding to an access token or a challenge
+func bindDescription(token string, binding string) string {
+	return token + bindingSeparator + binding
+}
+
+// splitDescription returns an access token or a challenge of a merkle tree description and its binding, if any
+func splitDescription(description string) (string, string) {
+	token, binding, _ := strings.Cut(description, bindingSeparator)
+	return token, binding
+}
+
+// RequestBinding returns a value that ties a proof of work to a request: its method, its path with a query,
+// given headers and its body. A binding is passed to a prover with WithBinding.
+//
+// A body is read with http.Request.GetBody when it is set, otherwise it is read and replaced
+// with an equal one, so a request can still be sent or handled
+func RequestBinding(req *http.Request, headers ...string) (string, error) {
+	return requestBinding(req, headers, -1)
+}
+
+// requestBinding reads at most "maxBodySize" bytes of a body, a negative size means no limit
+func requestBinding(req *http.Request, headers []string, maxBodySize int64) (string, error) {
+	bodyHash, err := hashBody(req, maxBodySize)
+	if err != nil {
+		return "", err
+	}
+
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	names := make([]string, 0, len(headers))
+	for _, name := range headers {
+		names = append(names, strings.ToLower(name))
+	}
+	// a binding does not depend on an order of headers in a configuration
+	slices.Sort(names)
+
+	digest := sha256.New()
+	fmt.Fprintf(digest, "%s\n%s\n%s\n", bindingVersion, method, req.URL.RequestURI())
+	for _, name := range slices.Compact(names) {
+		// every value is length-prefixed, so several values of a header differ from a single joined one
+		values := req.Header.Values(name)
+		fmt.Fprintf(digest, "%s:%d", name, len(values))
+		for _, value := range values {
+			fmt.Fprintf(digest, ":%d:%s", len(value), value)
+		}
+		fmt.Fprintln(digest)
+	}
+	fmt.Fprintf(digest, "%s\n", bodyHash)
+	return bindingVersion + "." + base64.RawURLEncoding.EncodeToString(digest.Sum(nil)), nil
+}
+
+// hashBody returns a hex SHA-256 of a request body
+func hashBody(req *http.Request, maxBodySize int64) (string, error) {
+	digest := sha256.New()
+	if req.Body == nil || req.Body == http.NoBody {
+		return hex.EncodeToString(digest.Sum(nil)), nil
+	}
+
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return "", fmt.Errorf("failed to get request body: %w", err)
+		}
+		defer body.Close()
+		if _, err := io.Copy(digest, body); err != nil {
+			return "", fmt.Errorf("failed to read request body: %w", err)
+		}
+		return hex.EncodeToString(digest.Sum(nil)), nil
+	}
+
+	reader := io.Reader(req.Body)
+	if maxBodySize >= 0 {
+		reader = io.LimitReader(reader, maxBodySize+1)
+	}
+	data, err := io.ReadAll(reader)
+	// the rest of a body, if any, is kept for a handler
+	req.Body = readCloser{Reader: io.MultiReader(bytes.NewReader(data), req.Body), Closer: req.Body}
+	if err != nil {
+		return "", fmt.Errorf("failed to read request body: %w", err)
+	}
+	if maxBodySize >= 0 && int64(len(data)) > maxBodySize {
+		return "", newVerificationError(ErrorCodeBindingMismatch,
+			"request body is too large to be bound, max allowed size: %d", maxBodySize)
+	}
+	digest.Write(data)
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// VerifyRequestBinding checks that a proof of work of a merkle header was built for a given request,
+// see RequestBinding. The whole body of the request is read
+func VerifyRequestBinding(req *http.Request, header string, headers ...string) error {
+	pow, err := restoreProofOfWorkFromHeader(header)
+	if err != nil {
+		return newVerificationError(ErrorCodeMalformedHeader, "unexpected merkle header struct: %w", err)
+	}
+	_, binding := splitDescription(pow.AccessToken())
+	return validateBinding(req, binding, headers, -1)
+}
+
+// validateBinding compares a binding of a proof of work with a binding of a request
+func validateBinding(req *http.Request, binding string, headers []string, maxBodySize int64) error {
+	if binding == "" {
+		return newVerificationError(ErrorCodeBindingMismatch, "proof of work is not bound to a request")
+	}
+	expected, err := requestBinding(req, headers, maxBodySize)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(binding), []byte(expected)) != 1 {
+		return newVerificationError(ErrorCodeBindingMismatch, "proof of work is bound to another request")
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestBinding(t *testing.T) {
+	newRequest := func(method, target, body string) *http.Request {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("X-Tenant", "alpha")
+		req.Header.Set("Content-Type", "text/plain")
+		return req
+	}
+	binding := func(req *http.Request, headers ...string) string {
+		result, err := RequestBinding(req, headers...)
+		require.NoError(t, err)
+		return result
+	}
+
+	original := binding(newRequest("POST", "/ping?q=1", "data"), "X-Tenant")
+	assert.True(t, strings.HasPrefix(original, bindingVersion+"."))
+	assert.Equal(t, original, binding(newRequest("POST", "/ping?q=1", "data"), "X-Tenant"))
+	assert.Equal(t, original, binding(newRequest("POST", "http://example.com/ping?q=1", "data"), "x-tenant"),
+		"a host is not bound and header names are case insensitive")
+
+	for name, req := range map[string]*http.Request{
+		"method": newRequest("PUT", "/ping?q=1", "data"),
+		"path":   newRequest("POST", "/pong?q=1", "data"),
+		"query":  newRequest("POST", "/ping?q=2", "data"),
+		"body":   newRequest("POST", "/ping?q=1", "date"),
+	} {
+		assert.NotEqual(t, original, binding(req, "X-Tenant"), name)
+	}
+	otherTenant := newRequest("POST", "/ping?q=1", "data")
+	otherTenant.Header.Set("X-Tenant", "beta")
+	assert.NotEqual(t, original, binding(otherTenant, "X-Tenant"))
+	assert.Equal(t, binding(otherTenant), binding(newRequest("POST", "/ping?q=1", "data")),
+		"headers are bound only when listed")
+
+	t.Run("Values_of_header_are_not_joined", func(t *testing.T) {
+		joined := newRequest("GET", "/", "")
+		joined.Header.Set("X-Tenant", "alpha,beta")
+		several := newRequest("GET", "/", "")
+		several.Header.Add("X-Tenant", "beta")
+		assert.NotEqual(t, binding(joined, "X-Tenant"), binding(several, "X-Tenant"))
+	})
+
+	t.Run("Order_of_headers_does_not_matter", func(t *testing.T) {
+		req := newRequest("GET", "/", "")
+		assert.Equal(t, binding(req, "X-Tenant", "Content-Type"), binding(req, "Content-Type", "X-Tenant"))
+	})
+
+	t.Run("Body_is_kept", func(t *testing.T) {
+		// a server-side request has no GetBody, so its body is replaced with an equal one
+		req := newRequest("POST", "/", "data")
+		req.GetBody = nil
+		binding(req)
+		body, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "data", string(body))
+
+		// a client-side request has GetBody, so its body is not touched
+		req, err = http.NewRequest("POST", "http://example.com/", strings.NewReader("data"))
+		require.NoError(t, err)
+		body1 := req.Body
+		binding(req)
+		assert.Equal(t, body1, req.Body)
+	})
+
+	t.Run("Large_body_is_not_bound", func(t *testing.T) {
+		req := newRequest("POST", "/", "data")
+		req.GetBody = nil
+		_, err := requestBinding(req, nil, 3)
+		assert.Equal(t, ErrorCodeBindingMismatch, ErrorCodeOf(err))
+		body, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "data", string(body), "a body is kept even when it is too large")
+	})
+
+	t.Run("Verification", func(t *testing.T) {
+		headerPayload, err := GenerateMerkleHeader(10, 3, "md5",
+			WithBinding(binding(newRequest("POST", "/ping", "data"), "X-Tenant")))
+		require.NoError(t, err)
+		assert.NoError(t, VerifyRequestBinding(newRequest("POST", "/ping", "data"), headerPayload, "X-Tenant"))
+		err = VerifyRequestBinding(newRequest("POST", "/ping", "date"), headerPayload, "X-Tenant")
+		assert.Equal(t, ErrorCodeBindingMismatch, ErrorCodeOf(err))
+
+		unbound, err := GenerateMerkleHeader(10, 3, "md5")
+		require.NoError(t, err)
+		err = VerifyRequestBinding(newRequest("POST", "/ping", "data"), unbound, "X-Tenant")
+		assert.Equal(t, ErrorCodeBindingMismatch, ErrorCodeOf(err))
+	})
+}
+
+func TestMerkleRequestBinding(t *testing.T) {
+	merkleMiddleware := NewMerkleMiddleware(WithAllowedDepthRange(10, 12), WithRequestBinding("X-Tenant"),
+		WithMaxBoundBodySize(16))
+	echo := func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(200, "pong"+string(body))
+	}
+	r := gin.New()
+	r.GET("/cheap", merkleMiddleware.Handler(), echo)
+	r.POST("/expensive", merkleMiddleware.Handler(), echo)
+
+	newRequest := func(method, target, body string) *http.Request {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("X-Tenant", "alpha")
+		return req
+	}
+	send := func(req *http.Request, opts ...HeaderOption) *httptest.ResponseRecorder {
+		headerPayload, err := GenerateMerkleHeader(10, 3, "md5", opts...)
+		require.NoError(t, err)
+		req.Header.Set(MerkleHeaderName, headerPayload)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	bindTo := func(req *http.Request) HeaderOption {
+		binding, err := RequestBinding(req, "X-Tenant")
+		require.NoError(t, err)
+		return WithBinding(binding)
+	}
+	errorCode := func(w *httptest.ResponseRecorder) ErrorCode {
+		var problem Problem
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+		return problem.Code
+	}
+
+	t.Run("Bound_proof_of_work_is_accepted", func(t *testing.T) {
+		w := send(newRequest("POST", "/expensive", "data"), bindTo(newRequest("POST", "/expensive", "data")))
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "pongdata", w.Body.String(), "a handler reads a whole body")
+	})
+
+	t.Run("Requirements_are_advertised", func(t *testing.T) {
+		w := send(newRequest("GET", "/cheap", ""))
+		assert.Equal(t, 406, w.Code)
+		assert.Equal(t, ErrorCodeBindingMismatch, errorCode(w))
+		requirements, err := ParseRequirements(w.Header().Get(RequirementsHeaderName))
+		require.NoError(t, err)
+		assert.True(t, requirements.BindingRequired)
+		assert.Equal(t, []string{"X-Tenant"}, requirements.BoundHeaders)
+	})
+
+	for name, testCase := range map[string]struct {
+		req   *http.Request
+		bound *http.Request
+	}{
+		"Proof_of_work_for_cheap_route_is_rejected": {
+			newRequest("POST", "/expensive", ""), newRequest("GET", "/cheap", ""),
+		},
+		"Proof_of_work_for_another_body_is_rejected": {
+			newRequest("POST", "/expensive", "data"), newRequest("POST", "/expensive", "date"),
+		},
+		"Proof_of_work_for_another_tenant_is_rejected": {
+			newRequest("GET", "/cheap", ""), httptest.NewRequest("GET", "/cheap", nil),
+		},
+		"Large_body_is_rejected": {
+			newRequest("POST", "/expensive", strings.Repeat("a", 17)),
+			newRequest("POST", "/expensive", strings.Repeat("a", 17)),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := send(testCase.req, bindTo(testCase.bound))
+			assert.Equal(t, 406, w.Code)
+			assert.Equal(t, ErrorCodeBindingMismatch, errorCode(w))
+		})
+	}
+}
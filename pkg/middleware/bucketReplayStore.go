@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrReplayStoreSaturated is returned by a ReplayStore that can't remember one more token
+// without forgetting a token that is still valid
+var ErrReplayStoreSaturated = errors.New("replay store is saturated")
+
+// BucketReplayStore is an in-process ReplayStore that never forgets a token before its ttl passes.
+//
+// Tokens are put into buckets by their expiration time, and a bucket is dropped as a whole
+// once all of its tokens are expired. Memory is bounded by a max number of tokens: a full store
+// rejects new tokens with ErrReplayStoreSaturated instead of evicting valid ones
+type BucketReplayStore struct {
+	mu          sync.Mutex
+	cfg         bucketConfig
+	maxTokens   int
+	tokens      map[string]struct{}
+	buckets     map[int64][]string
+	lastSlot    int64
+	saturations int64
+}
+
+// confirm interfaces' implementation
+var _ ReplayStore = (*BucketReplayStore)(nil)
+
+// NewBucketReplayStore creates a BucketReplayStore that keeps at most "maxTokens" tokens
+func NewBucketReplayStore(maxTokens int, opts ...BucketOption) *BucketReplayStore {
+	return &BucketReplayStore{
+		cfg:       newBucketConfigFromOptions(opts...),
+		maxTokens: maxTokens,
+		tokens:    make(map[string]struct{}),
+		buckets:   make(map[int64][]string),
+	}
+}
+
+// slot returns a number of a bucket that contains a given moment
+func (rcv *BucketReplayStore) slot(t time.Time) int64 {
+	return t.UnixNano() / int64(rcv.cfg.bucketWidth)
+}
+
+// rotate drops buckets that ended before a given slot, it has to be called under the lock
+func (rcv *BucketReplayStore) rotate(currentSlot int64) {
+	if currentSlot == rcv.lastSlot {
+		return
+	}
+	rcv.lastSlot = currentSlot
+	for slot, tokens := range rcv.buckets {
+		if slot >= currentSlot {
+			continue
+		}
+		for _, token := range tokens {
+			delete(rcv.tokens, token)
+		}
+		delete(rcv.buckets, slot)
+	}
+}
+
+// MarkUsed implements ReplayStore interface
+func (rcv *BucketReplayStore) MarkUsed(_ context.Context, token string, ttl time.Duration) (bool, error) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	now := rcv.cfg.clock.Now()
+	rcv.rotate(rcv.slot(now))
+	if _, ok := rcv.tokens[token]; ok {
+		return false, nil
+	}
+	if len(rcv.tokens) >= rcv.maxTokens {
+		rcv.saturations++
+		return false, ErrReplayStoreSaturated
+	}
+
+	// a bucket of an expiration moment is dropped only after the moment passes
+	slot := rcv.slot(now.Add(ttl))
+	rcv.tokens[token] = struct{}{}
+	rcv.buckets[slot] = append(rcv.buckets[slot], token)
+	return true, nil
+}
+
+// Len returns a number of remembered tokens
+func (rcv *BucketReplayStore) Len() int {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	rcv.rotate(rcv.slot(rcv.cfg.clock.Now()))
+	return len(rcv.tokens)
+}
+
+// Cap returns a max number of remembered tokens
+func (rcv *BucketReplayStore) Cap() int {
+	return rcv.maxTokens
+}
+
+// Saturations returns a number of tokens rejected since a store was full
+func (rcv *BucketReplayStore) Saturations() int64 {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return rcv.saturations
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"time"
+)
+
+type bucketConfig struct {
+	bucketWidth time.Duration
+	clock       Clock
+}
+
+func newBucketConfigFromOptions(opts ...BucketOption) bucketConfig {
+	// default values
+	cfg := bucketConfig{
+		bucketWidth: time.Second,
+		clock:       SystemClock{},
+	}
+
+	// overrides
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return cfg
+}
+
+// BucketOption allows to customize BucketReplayStore
+type BucketOption func(cfg *bucketConfig)
+
+// WithBucketWidth allows to specify a time span of a bucket. Narrow buckets free memory sooner,
+// wide ones make rotations less frequent
+func WithBucketWidth(d time.Duration) BucketOption {
+	if d <= 0 {
+		d = time.Second
+	}
+	return func(cfg *bucketConfig) {
+		cfg.bucketWidth = d
+	}
+}
+
+// WithBucketClock allows to substitute time, e.g. with a fake clock in tests
+func WithBucketClock(clock Clock) BucketOption {
+	return func(cfg *bucketConfig) {
+		cfg.clock = clock
+	}
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBucketReplayStore(t *testing.T) {
+	t.Run("Contract", func(t *testing.T) {
+		testReplayStore(t, NewBucketReplayStore(100, WithBucketWidth(10*time.Millisecond)))
+	})
+
+	t.Run("Tokens_are_remembered_for_whole_ttl", func(t *testing.T) {
+		clock := newFakeClock()
+		store := NewBucketReplayStore(100, WithBucketClock(clock))
+		ctx := context.Background()
+		clock.Advance(700 * time.Millisecond)
+		for _, token := range []string{"a", "b"} {
+			fresh, err := store.MarkUsed(ctx, token, 5*time.Second)
+			require.NoError(t, err)
+			require.True(t, fresh)
+		}
+		fresh, err := store.MarkUsed(ctx, "c", time.Minute)
+		require.NoError(t, err)
+		require.True(t, fresh)
+
+		for elapsed := time.Duration(0); elapsed < 5*time.Second; elapsed += 100 * time.Millisecond {
+			fresh, err := store.MarkUsed(ctx, "a", 5*time.Second)
+			require.NoError(t, err)
+			require.False(t, fresh, "a token is forgotten too early, elapsed %v", elapsed)
+			clock.Advance(100 * time.Millisecond)
+		}
+		// a bucket is dropped as soon as it ends
+		clock.Advance(time.Second)
+		assert.Equal(t, 1, store.Len())
+		fresh, err = store.MarkUsed(ctx, "b", 5*time.Second)
+		require.NoError(t, err)
+		assert.True(t, fresh)
+		fresh, err = store.MarkUsed(ctx, "c", time.Minute)
+		require.NoError(t, err)
+		assert.False(t, fresh)
+	})
+
+	t.Run("Saturation_is_reported", func(t *testing.T) {
+		clock := newFakeClock()
+		store := NewBucketReplayStore(3, WithBucketClock(clock))
+		ctx := context.Background()
+		for i := 0; i < 3; i++ {
+			fresh, err := store.MarkUsed(ctx, fmt.Sprint(i), 5*time.Second)
+			require.NoError(t, err)
+			require.True(t, fresh)
+		}
+		_, err := store.MarkUsed(ctx, "3", 5*time.Second)
+		assert.ErrorIs(t, err, ErrReplayStoreSaturated)
+		assert.Equal(t, int64(1), store.Saturations())
+		// a saturated store still rejects replays
+		fresh, err := store.MarkUsed(ctx, "0", 5*time.Second)
+		require.NoError(t, err)
+		assert.False(t, fresh)
+
+		clock.Advance(6 * time.Second)
+		fresh, err = store.MarkUsed(ctx, "3", 5*time.Second)
+		require.NoError(t, err)
+		assert.True(t, fresh, "expired tokens free memory")
+	})
+}
@@ -0,0 +1,182 @@
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/evilaffliction/merkle/pkg/algo/merkle"
+	"github.com/evilaffliction/merkle/pkg/algo/merkle/impl"
+	"github.com/evilaffliction/merkle/pkg/rest"
+)
+
+// challengePrefix distinguishes server-issued challenges from client-chosen access tokens,
+// the latter always start with a digit
+const challengePrefix = "c1."
+
+// challengeNonceSize is a number of random bytes in every challenge
+const challengeNonceSize = 16
+
+// Challenge is a server-issued task for a prover. Its Value is used as a description of a merkle tree,
+// and the tree has to be built with the given depth, proof leaves num and work factor.
+// The Value is signed by a server, so the server needs no state to check it
+type Challenge struct {
+	Value           string `json:"challenge"`
+	Depth           int    `json:"depth"`
+	ProofLeavesNum  int    `json:"proof_leaves_num"`
+	WorkFactor      int    `json:"work_factor"`
+	ExpiresAtMicros int64  `json:"expires_at_micros"`
+}
+
+// signedChallenge is a parsed Value of a Challenge:
+//
+//	c1.<expires at micros>.<depth>.<proof leaves num>.<work factor>.<base64url nonce>.<base64url HMAC-SHA256>
+//
+// HMAC covers everything before the last dot
+type signedChallenge struct {
+	expiresAtMicros int64
+	depth           int
+	proofLeavesNum  int
+	workFactor      int
+	nonce           []byte
+}
+
+func (rcv signedChallenge) payload() string {
+	return fmt.Sprintf("%s%d.%d.%d.%d.%s", challengePrefix, rcv.expiresAtMicros, rcv.depth, rcv.proofLeavesNum,
+		rcv.workFactor, base64.RawURLEncoding.EncodeToString(rcv.nonce))
+}
+
+func (rcv signedChallenge) sign(secret []byte) string {
+	payload := rcv.payload()
+	return payload + "." + base64.RawURLEncoding.EncodeToString(challengeMAC(secret, payload))
+}
+
+func challengeMAC(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+func isChallenge(accessToken string) bool {
+	return strings.HasPrefix(accessToken, challengePrefix)
+}
+
+// newChallenge issues a fresh challenge for a client with difficulty from a config
+func newChallenge(cfg config, clientKey string) (Challenge, error) {
+	nonce := make([]byte, challengeNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return Challenge{}, fmt.Errorf("failed to generate challenge nonce: %w", err)
+	}
+	// a challenge should be acceptable at the moment of its issue
+	requirements := cfg.currentRequirements(clientKey)
+	signed := signedChallenge{
+		expiresAtMicros: time.Now().Add(cfg.challengeLifeTime).UnixMicro(),
+		depth:           max(cfg.challengeDepth, requirements.MinDepth),
+		proofLeavesNum:  max(cfg.challengeProofLeavesNum, requirements.MinProofLeavesNum),
+		workFactor:      cfg.challengeWorkFactor,
+		nonce:           nonce,
+	}
+	return Challenge{
+		Value:           signed.sign(cfg.challengeSecret),
+		Depth:           signed.depth,
+		ProofLeavesNum:  signed.proofLeavesNum,
+		WorkFactor:      signed.workFactor,
+		ExpiresAtMicros: signed.expiresAtMicros,
+	}, nil
+}
+
+// restoreChallenge parses a challenge and checks its signature
+func restoreChallenge(s string, secret []byte) (signedChallenge, error) {
+	if !isChallenge(s) {
+		return signedChallenge{}, fmt.Errorf("challenge is expected to start with %q", challengePrefix)
+	}
+	lastDot := strings.LastIndexByte(s, '.')
+	payload, encodedMAC := s[:lastDot], s[lastDot+1:]
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil {
+		return signedChallenge{}, fmt.Errorf("failed to decode challenge signature: %w", err)
+	}
+	if !hmac.Equal(mac, challengeMAC(secret, payload)) {
+		return signedChallenge{}, fmt.Errorf("challenge signature is invalid")
+	}
+
+	parts := strings.Split(strings.TrimPrefix(payload, challengePrefix), ".")
+	if len(parts) != 5 {
+		return signedChallenge{}, fmt.Errorf("challenge is expected to have 5 parts, actual number %d", len(parts))
+	}
+	var result signedChallenge
+	if result.expiresAtMicros, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+		return signedChallenge{}, fmt.Errorf("failed to parse challenge expiration: %w", err)
+	}
+	for i, field := range []*int{&result.depth, &result.proofLeavesNum, &result.workFactor} {
+		if *field, err = strconv.Atoi(parts[i+1]); err != nil {
+			return signedChallenge{}, fmt.Errorf("failed to parse challenge difficulty: %w", err)
+		}
+	}
+	if result.nonce, err = base64.RawURLEncoding.DecodeString(parts[4]); err != nil {
+		return signedChallenge{}, fmt.Errorf("failed to decode challenge nonce: %w", err)
+	}
+	return result, nil
+}
+
+// validateChallenge checks that a proof of work was built for a valid challenge with requested difficulty
+// and returns when the challenge expires
+func validateChallenge(token string, pow merkle.ProofOfWork, cfg config) (time.Time, error) {
+	if len(cfg.challengeSecret) == 0 {
+		return time.Time{}, newVerificationError(ErrorCodeInvalidChallenge, "challenges are not supported")
+	}
+	challenge, err := restoreChallenge(token, cfg.challengeSecret)
+	if err != nil {
+		return time.Time{}, newVerificationError(ErrorCodeInvalidChallenge, "failed to restore challenge: %w", err)
+	}
+	if time.Now().UnixMicro() > challenge.expiresAtMicros {
+		return time.Time{}, newVerificationError(ErrorCodeExpired, "challenge is expired")
+	}
+	if pow.Depth() != challenge.depth || pow.ProofLeavesNum() != challenge.proofLeavesNum ||
+		pow.WorkFactor() != challenge.workFactor {
+		return time.Time{}, newVerificationError(ErrorCodeInvalidChallenge, "proof of work with depth %d, "+
+			"proof leaves num %d and work factor %d does not match challenge with depth %d, "+
+			"proof leaves num %d and work factor %d",
+			pow.Depth(), pow.ProofLeavesNum(), pow.WorkFactor(),
+			challenge.depth, challenge.proofLeavesNum, challenge.workFactor)
+	}
+	return time.UnixMicro(challenge.expiresAtMicros), nil
+}
+
+// GetChallengeHandler returns a gin-gonic handler that issues challenges for a middleware from GetMerkleMiddleware.
+// Both of them should be created with the same WithChallengeSecret option
+func GetChallengeHandler(opts ...Option) gin.HandlerFunc {
+	return gin.WrapH(GetChallengeHTTPHandler(opts...))
+}
+
+// GetChallengeHTTPHandler is GetChallengeHandler for net/http, see GetHTTPMiddleware
+func GetChallengeHTTPHandler(opts ...Option) http.Handler {
+	cfg := newConfigFromOptions(opts...)
+	return rest.HandlerWrapper(func(req *http.Request) (any, error) {
+		if len(cfg.challengeSecret) == 0 {
+			return nil, fmt.Errorf("challenges are not supported")
+		}
+		return newChallenge(cfg, cfg.clientKey(req))
+	})
+}
+
+// GenerateMerkleHeaderForChallenge generates a header for a server-issued challenge,
+// see GetChallengeHandler
+func GenerateMerkleHeaderForChallenge(
+	ctx context.Context,
+	challenge Challenge,
+	hashFunc string,
+	opts ...HeaderOption,
+) (string, error) {
+	opts = append(opts, WithTreeOptions(impl.WithWorkFactor(challenge.WorkFactor)))
+	return generateMerkleHeader(ctx, challenge.Value, challenge.Depth, challenge.ProofLeavesNum, hashFunc, opts...)
+}
@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChallengeSignature(t *testing.T) {
+	cfg := newConfigFromOptions(WithChallengeSecret([]byte("Sic semper tyrannis")), WithChallengeDifficulty(12, 3, 2))
+	challenge, err := newChallenge(cfg, "")
+	require.NoError(t, err)
+	assert.True(t, isChallenge(challenge.Value))
+	assert.Equal(t, 12, challenge.Depth)
+	assert.Equal(t, 3, challenge.ProofLeavesNum)
+	assert.Equal(t, 2, challenge.WorkFactor)
+
+	restored, err := restoreChallenge(challenge.Value, cfg.challengeSecret)
+	require.NoError(t, err)
+	assert.Equal(t, challenge.ExpiresAtMicros, restored.expiresAtMicros)
+	assert.Equal(t, 12, restored.depth)
+	assert.Equal(t, 3, restored.proofLeavesNum)
+	assert.Equal(t, 2, restored.workFactor)
+	assert.Len(t, restored.nonce, challengeNonceSize)
+
+	otherChallenge, err := newChallenge(cfg, "")
+	require.NoError(t, err)
+	assert.NotEqual(t, challenge.Value, otherChallenge.Value)
+
+	_, err = restoreChallenge(challenge.Value, []byte("Et tu, Brute?"))
+	assert.Error(t, err)
+
+	// any change of difficulty breaks a signature
+	tampered := strings.Replace(challenge.Value, ".12.3.2.", ".10.3.2.", 1)
+	require.NotEqual(t, challenge.Value, tampered)
+	_, err = restoreChallenge(tampered, cfg.challengeSecret)
+	assert.Error(t, err)
+
+	for _, malformed := range []string{"", "c1.", "c1.1.2.3", "1_2", challenge.Value + "!"} {
+		_, err = restoreChallenge(malformed, cfg.challengeSecret)
+		assert.Error(t, err, malformed)
+	}
+}
+
+func TestMerkleChallenge(t *testing.T) {
+	secret := []byte("Sic semper tyrannis")
+	getChallenge := func(t *testing.T, r *gin.Engine) Challenge {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/challenge", nil)
+		require.NoError(t, err)
+		r.ServeHTTP(w, req)
+		require.Equal(t, 200, w.Code)
+		var challenge Challenge
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &challenge))
+		return challenge
+	}
+	ping := func(r *gin.Engine, headerPayload string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/ping", nil)
+		req.Header.Set(MerkleHeaderName, headerPayload)
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	newEngine := func(opts ...Option) *gin.Engine {
+		opts = append(opts, WithChallengeSecret(secret), WithChallengeDifficulty(12, 3, 2))
+		r := gin.New()
+		r.GET("/challenge", GetChallengeHandler(opts...))
+		r.GET("/ping", GetMerkleMiddleware(opts...), func(c *gin.Context) {
+			c.String(200, "pong")
+		})
+		return r
+	}
+
+	t.Run("Both_modes_work_side_by_side", func(t *testing.T) {
+		r := newEngine()
+		headerPayload, err := GenerateMerkleHeaderForChallenge(context.Background(), getChallenge(t, r), "md5")
+		require.NoError(t, err)
+		assert.Equal(t, 200, ping(r, headerPayload))
+		assert.Equal(t, 406, ping(r, headerPayload), "reusal is prohibited")
+
+		headerPayload, err = GenerateMerkleHeader(12, 3, "md5")
+		require.NoError(t, err)
+		assert.Equal(t, 200, ping(r, headerPayload))
+	})
+
+	t.Run("Required_challenge_rejects_access_tokens", func(t *testing.T) {
+		r := newEngine(WithRequiredChallenge())
+		headerPayload, err := GenerateMerkleHeader(12, 3, "md5")
+		require.NoError(t, err)
+		assert.Equal(t, 406, ping(r, headerPayload))
+
+		headerPayload, err = GenerateMerkleHeaderForChallenge(context.Background(), getChallenge(t, r), "md5",
+			WithBinaryEncoding())
+		require.NoError(t, err)
+		assert.Equal(t, 200, ping(r, headerPayload))
+	})
+
+	t.Run("Challenge_difficulty_is_enforced", func(t *testing.T) {
+		r := newEngine()
+		challenge := getChallenge(t, r)
+		challenge.Depth--
+		headerPayload, err := GenerateMerkleHeaderForChallenge(context.Background(), challenge, "md5")
+		require.NoError(t, err)
+		assert.Equal(t, 406, ping(r, headerPayload))
+	})
+
+	t.Run("Expired_challenge_is_rejected", func(t *testing.T) {
+		r := newEngine(WithChallengeLifeTime(-time.Second))
+		headerPayload, err := GenerateMerkleHeaderForChallenge(context.Background(), getChallenge(t, r), "md5")
+		require.NoError(t, err)
+		assert.Equal(t, 406, ping(r, headerPayload))
+	})
+
+	t.Run("Challenge_of_other_server_is_rejected", func(t *testing.T) {
+		other := gin.New()
+		other.GET("/challenge", GetChallengeHandler(WithChallengeSecret([]byte("Et tu, Brute?")),
+			WithChallengeDifficulty(12, 3, 2)))
+		headerPayload, err := GenerateMerkleHeaderForChallenge(context.Background(), getChallenge(t, other), "md5")
+		require.NoError(t, err)
+		assert.Equal(t, 406, ping(newEngine(), headerPayload))
+	})
+
+	t.Run("Challenges_are_disabled_without_secret", func(t *testing.T) {
+		r := gin.New()
+		r.GET("/challenge", GetChallengeHandler())
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/challenge", nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, 500, w.Code)
+	})
+}
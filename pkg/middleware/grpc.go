@@ -0,0 +1,188 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// GRPCErrorDomain is a domain of error details of gRPC rejections, see GRPCProblem
+const GRPCErrorDomain = "merkle"
+
+// keys of a metadata of an errdetails.ErrorInfo of a gRPC rejection
+const (
+	grpcRequirementsKey = "requirements"
+	grpcDetailKey       = "detail"
+)
+
+// UnaryServerInterceptor returns a gRPC interceptor of unary calls for a service with a given policy.
+// A proof of work and a session token are read from metadata with the same names as HTTP headers, so
+// every call is verified exactly as an HTTP request with a path of a full gRPC method name and an empty body.
+// Bound metadata is bound as headers, a message itself is never bound
+func (rcv *MerkleMiddleware) UnaryServerInterceptor(policy ...Option) grpc.UnaryServerInterceptor {
+	guard := rcv.newGuard(policy)
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		header := http.Header{}
+		result, finish := guard.admit(header, grpcRequest(ctx, info.FullMethod))
+		defer finish()
+		if len(header) > 0 || result.err != nil {
+			_ = grpc.SetHeader(ctx, grpcResponseMetadata(header, result))
+		}
+		if result.err != nil {
+			return nil, grpcRejection(result, guard.cfg.debugErrors)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a gRPC interceptor of streams for a service with a given policy,
+// a proof of work is verified once when a stream is opened, see UnaryServerInterceptor
+func (rcv *MerkleMiddleware) StreamServerInterceptor(policy ...Option) grpc.StreamServerInterceptor {
+	guard := rcv.newGuard(policy)
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		header := http.Header{}
+		result, finish := guard.admit(header, grpcRequest(ss.Context(), info.FullMethod))
+		defer finish()
+		if len(header) > 0 || result.err != nil {
+			_ = ss.SetHeader(grpcResponseMetadata(header, result))
+		}
+		if result.err != nil {
+			return grpcRejection(result, guard.cfg.debugErrors)
+		}
+		return handler(srv, ss)
+	}
+}
+
+// GetUnaryServerInterceptor is GetMerkleMiddleware for unary gRPC calls
+func GetUnaryServerInterceptor(opts ...Option) grpc.UnaryServerInterceptor {
+	return NewMerkleMiddleware(opts...).UnaryServerInterceptor()
+}
+
+// GetStreamServerInterceptor is GetMerkleMiddleware for gRPC streams
+func GetStreamServerInterceptor(opts ...Option) grpc.StreamServerInterceptor {
+	return NewMerkleMiddleware(opts...).StreamServerInterceptor()
+}
+
+// GRPCRequest describes a gRPC call as an HTTP request the way server interceptors see it:
+// a POST request of a full method name with incoming metadata as headers and without a body.
+// It is useful to bind a proof of work to a call with RequestBinding
+func GRPCRequest(ctx context.Context, fullMethod string, md metadata.MD) *http.Request {
+	header := http.Header{}
+	for key, values := range md {
+		if strings.HasPrefix(key, ":") {
+			// pseudo-headers of HTTP/2 are not metadata of a call
+			continue
+		}
+		header[textproto.CanonicalMIMEHeaderKey(key)] = values
+	}
+	req := &http.Request{
+		Method:     http.MethodPost,
+		URL:        &url.URL{Path: fullMethod},
+		RequestURI: fullMethod,
+		Header:     header,
+		Body:       http.NoBody,
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		req.RemoteAddr = p.Addr.String()
+	}
+	return req.WithContext(ctx)
+}
+
+// grpcRequest describes an incoming gRPC call as an HTTP request
+func grpcRequest(ctx context.Context, fullMethod string) *http.Request {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return GRPCRequest(ctx, fullMethod, md)
+}
+
+// grpcResponseMetadata converts response headers and requirements of a rejection to a response metadata
+func grpcResponseMetadata(header http.Header, result verdict) metadata.MD {
+	md := metadata.MD{}
+	for key, values := range header {
+		md.Append(key, values...)
+	}
+	if result.err != nil {
+		md.Set(RequirementsHeaderName, result.requirements.String())
+	}
+	return md
+}
+
+// grpcRejection is a gRPC status of a rejected call. Its details carry an error code and current requirements
+// in an errdetails.ErrorInfo and, when it is worth to wait before a retry, an errdetails.RetryInfo
+func grpcRejection(result verdict, debugErrors bool) error {
+	problem := newProblem(result.err, result.requirements, debugErrors)
+	code := codes.Unauthenticated
+	switch problem.Code {
+	case ErrorCodeSaturated, ErrorCodeSessionExhausted:
+		code = codes.ResourceExhausted
+	case ErrorCodeInternal:
+		code = codes.Unavailable
+	}
+
+	info := &errdetails.ErrorInfo{
+		Reason: string(problem.Code),
+		Domain: GRPCErrorDomain,
+		Metadata: map[string]string{
+			grpcRequirementsKey: problem.Requirements.String(),
+		},
+	}
+	if problem.Detail != "" {
+		info.Metadata[grpcDetailKey] = problem.Detail
+	}
+	details := []protoadapt.MessageV1{info}
+	if retryAfter := rejectionRetryAfter(problem.Code, result.retryAfter); retryAfter > 0 {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)})
+	}
+	st := status.New(code, problem.Title)
+	// a status without details is still a rejection
+	if detailed, err := st.WithDetails(details...); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// GRPCProblem restores a rejection from an error of a gRPC call, it reports whether an error is a rejection
+// of a proof of work at all. A status of a problem is never set and a retry delay is zero when it is not hinted
+func GRPCProblem(err error) (Problem, time.Duration, bool) {
+	st, ok := status.FromError(err)
+	if !ok || err == nil {
+		return Problem{}, 0, false
+	}
+	var problem Problem
+	var retryAfter time.Duration
+	found := false
+	for _, detail := range st.Details() {
+		switch detail := detail.(type) {
+		case *errdetails.ErrorInfo:
+			if detail.GetDomain() != GRPCErrorDomain {
+				continue
+			}
+			requirements, err := ParseRequirements(detail.GetMetadata()[grpcRequirementsKey])
+			if err != nil {
+				return Problem{}, 0, false
+			}
+			problem = Problem{
+				Type:         "urn:merkle:error:" + detail.GetReason(),
+				Title:        st.Message(),
+				Detail:       detail.GetMetadata()[grpcDetailKey],
+				Code:         ErrorCode(detail.GetReason()),
+				Requirements: requirements,
+			}
+			found = true
+		case *errdetails.RetryInfo:
+			retryAfter = detail.GetRetryDelay().AsDuration()
+		}
+	}
+	return problem, retryAfter, found
+}
@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/evilaffliction/merkle/internal/grpctest"
+)
+
+// newGRPCHealthClient serves a health service behind interceptors of a middleware over an in-memory connection
+func newGRPCHealthClient(t *testing.T, merkleMiddleware *MerkleMiddleware) healthpb.HealthClient {
+	return grpctest.NewHealthClient(t, []grpc.ServerOption{
+		grpc.UnaryInterceptor(merkleMiddleware.UnaryServerInterceptor()),
+		grpc.StreamInterceptor(merkleMiddleware.StreamServerInterceptor()),
+	})
+}
+
+func TestGRPCInterceptors(t *testing.T) {
+	withProof := func(t *testing.T, ctx context.Context, depth int) context.Context {
+		headerPayload, err := GenerateMerkleHeader(depth, 3, "md5")
+		require.NoError(t, err)
+		return metadata.AppendToOutgoingContext(ctx, MerkleHeaderName, headerPayload)
+	}
+
+	t.Run("Unary_calls_are_verified", func(t *testing.T) {
+		healthClient := newGRPCHealthClient(t, NewMerkleMiddleware(WithAllowedDepthRange(10, 12)))
+		ctx := context.Background()
+
+		resp, err := healthClient.Check(withProof(t, ctx, 11), &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.GetStatus())
+
+		var header metadata.MD
+		_, err = healthClient.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.Header(&header))
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		problem, _, ok := GRPCProblem(err)
+		require.True(t, ok)
+		assert.Equal(t, ErrorCodeMissingHeader, problem.Code)
+		assert.Equal(t, errorTitles[ErrorCodeMissingHeader], problem.Title)
+		assert.Equal(t, 10, problem.Requirements.MinDepth)
+		assert.Equal(t, []string{problem.Requirements.String()}, header.Get(RequirementsHeaderName))
+
+		_, err = healthClient.Check(withProof(t, ctx, 9), &healthpb.HealthCheckRequest{})
+		problem, _, ok = GRPCProblem(err)
+		require.True(t, ok)
+		assert.Equal(t, ErrorCodeTooEasy, problem.Code)
+	})
+
+	t.Run("Streams_are_verified", func(t *testing.T) {
+		healthClient := newGRPCHealthClient(t, NewMerkleMiddleware(WithAllowedDepthRange(10, 12)))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		stream, err := healthClient.Watch(withProof(t, ctx, 11), &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		resp, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.GetStatus())
+
+		stream, err = healthClient.Watch(ctx, &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		problem, _, ok := GRPCProblem(err)
+		require.True(t, ok)
+		assert.Equal(t, ErrorCodeMissingHeader, problem.Code)
+	})
+
+	t.Run("Replayed_proof_of_work_is_rejected", func(t *testing.T) {
+		healthClient := newGRPCHealthClient(t, NewMerkleMiddleware(WithAllowedDepthRange(10, 12)))
+		ctx := withProof(t, context.Background(), 11)
+		_, err := healthClient.Check(ctx, &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		_, err = healthClient.Check(ctx, &healthpb.HealthCheckRequest{})
+		problem, _, ok := GRPCProblem(err)
+		require.True(t, ok)
+		assert.Equal(t, ErrorCodeReplayed, problem.Code)
+	})
+
+	t.Run("Sessions", func(t *testing.T) {
+		healthClient := newGRPCHealthClient(t, NewMerkleMiddleware(WithAllowedDepthRange(10, 12),
+			WithSessionKeys(SessionKey{ID: "k1", Secret: []byte("secret")}), WithSessionLimits(1, time.Minute),
+			WithSessionMinDepth(11)))
+		ctx := context.Background()
+
+		var header metadata.MD
+		_, err := healthClient.Check(withProof(t, ctx, 11), &healthpb.HealthCheckRequest{}, grpc.Header(&header))
+		require.NoError(t, err)
+		require.Len(t, header.Get(SessionHeaderName), 1)
+		ctx = metadata.AppendToOutgoingContext(ctx, SessionHeaderName, header.Get(SessionHeaderName)[0])
+
+		_, err = healthClient.Check(ctx, &healthpb.HealthCheckRequest{})
+		require.NoError(t, err)
+		_, err = healthClient.Check(ctx, &healthpb.HealthCheckRequest{})
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		problem, _, ok := GRPCProblem(err)
+		require.True(t, ok)
+		assert.Equal(t, ErrorCodeSessionExhausted, problem.Code)
+	})
+
+	t.Run("Saturation_hints_retry_delay", func(t *testing.T) {
+		err := grpcRejection(verdict{err: newVerificationError(ErrorCodeSaturated, "full")}, true)
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		problem, retryAfter, ok := GRPCProblem(err)
+		require.True(t, ok)
+		assert.Equal(t, ErrorCodeSaturated, problem.Code)
+		assert.Equal(t, "saturated: full", problem.Detail)
+		assert.Equal(t, time.Second, retryAfter)
+	})
+
+	t.Run("Other_errors_are_not_problems", func(t *testing.T) {
+		_, _, ok := GRPCProblem(status.Error(codes.Unauthenticated, "no"))
+		assert.False(t, ok)
+		_, _, ok = GRPCProblem(nil)
+		assert.False(t, ok)
+	})
+}
@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/evilaffliction/merkle/pkg/algo/merkle"
+)
+
+// guard verifies requests of a route, it knows nothing about web frameworks:
+// both a gin-gonic middleware and a net/http one are thin wrappers over it
+type guard struct {
+	cfg          config
+	observer     RequestObserver
+	replayStore  ReplayStore
+	sessionStore SessionStore
+	sessions     bool
+}
+
+// newGuard creates a guard for a route with a given policy
+func (rcv *MerkleMiddleware) newGuard(policy []Option) *guard {
+	cfg := newConfigFromOptions(rcv.routeOptions(policy)...)
+	var observer RequestObserver
+	if cfg.adaptiveDifficulty != nil {
+		observer = cfg.adaptiveDifficulty.observer()
+	}
+	return &guard{
+		cfg:          cfg,
+		observer:     observer,
+		replayStore:  rcv.replayStore,
+		sessionStore: rcv.sessionStore,
+		// a session store is created only when session keys are among default options
+		sessions: cfg.sessionsEnabled() && rcv.sessionStore != nil,
+	}
+}
+
+// verdict is an outcome of a verification of a request
+type verdict struct {
+	err          error
+	requirements Requirements
+	retryAfter   time.Duration
+}
+
+// serve calls "next" for a request with a valid proof of work or a valid session token,
+// otherwise it writes a rejection. It reports whether "next" was called
+func (rcv *guard) serve(w http.ResponseWriter, req *http.Request, next func()) bool {
+	result, finish := rcv.admit(w.Header(), req)
+	defer finish()
+	if result.err != nil {
+		reject(w, result.err, result.requirements, result.retryAfter, rcv.cfg.debugErrors)
+		return false
+	}
+
+	next()
+	return true
+}
+
+// admit verifies a request of any transport, headers of a response such as a session token are put into "header".
+// "finish" has to be called once a request is served
+func (rcv *guard) admit(header http.Header, req *http.Request) (verdict, func()) {
+	if rcv.observer != nil {
+		rcv.observer.RequestStarted()
+	}
+	clientKey := rcv.cfg.clientKey(req)
+	requirements := rcv.cfg.currentRequirements(clientKey)
+	start := time.Now()
+	pow, session, err := rcv.verify(header, req, requirements)
+	verificationLatency := time.Since(start)
+	if rcv.cfg.reputation != nil {
+		// failures of a server such as an unavailable replay store are not a client's fault
+		var verificationErr *VerificationError
+		if err == nil || errors.As(err, &verificationErr) {
+			rcv.cfg.reputation.Observe(clientKey, !isInvalidProof(err))
+		}
+	}
+	if rcv.cfg.metrics != nil {
+		verification := Verification{Session: session, Latency: verificationLatency}
+		for _, value := range req.Header[MerkleHeaderName] {
+			verification.HeaderSize += len(value)
+		}
+		switch {
+		case err != nil:
+			verification.Code = ErrorCodeOf(err)
+		case pow != nil:
+			verification.Depth = pow.Depth()
+		}
+		rcv.cfg.metrics.ObserveVerification(verification)
+	}
+
+	result := verdict{err: err, requirements: requirements}
+	if err != nil {
+		result.retryAfter = rcv.cfg.retryAfter(clientKey)
+	}
+	return result, func() {
+		if rcv.observer != nil {
+			// a whole request is in flight, but only verification is a latency of the middleware
+			rcv.observer.RequestFinished(verificationLatency)
+		}
+	}
+}
+
+// verify checks a session token of a request without a proof of work, or a proof of work otherwise.
+// A valid proof of work may buy a session token. It returns a verified proof of work, if any,
+// and whether a session token was checked instead
+func (rcv *guard) verify(
+	header http.Header,
+	req *http.Request,
+	requirements Requirements,
+) (merkle.ProofOfWork, bool, error) {
+	sessions := rcv.sessionsAllowed(requirements)
+	if sessions && len(req.Header[MerkleHeaderName]) == 0 {
+		if sessionToken := sessionTokenOf(req, rcv.cfg); sessionToken != "" {
+			return nil, true, validateSession(req, sessionToken, requirements, rcv.sessionStore, rcv.cfg)
+		}
+	}
+
+	pow, err := validateMerkleHeader(req, rcv.replayStore, rcv.cfg, requirements)
+	if err != nil {
+		return nil, false, err
+	}
+	if sessions && pow.Depth() >= rcv.cfg.sessionMinDepth {
+		issueSession(header, req, pow, rcv.cfg)
+	}
+	return pow, false, nil
+}
+
+// sessionsAllowed reports whether session tokens are accepted and issued under given requirements.
+// A session token is neither a challenge nor bound to a request, so routes that require either of them
+// have no sessions unless they opt in with WithSessionsOnStrictRoutes
+func (rcv *guard) sessionsAllowed(requirements Requirements) bool {
+	return rcv.sessions &&
+		(rcv.cfg.sessionsOnStrictRoutes || !requirements.ChallengeRequired && !requirements.BindingRequired)
+}
+
+// isInvalidProof reports whether a request was rejected for a broken, forged or replayed proof of work
+// or session token rather than for not meeting current requirements, e.g. after they were raised
+func isInvalidProof(err error) bool {
+	switch ErrorCodeOf(err) {
+	case ErrorCodeMalformedHeader, ErrorCodeInvalidAccessToken, ErrorCodeInvalidChallenge, ErrorCodeBindingMismatch,
+		ErrorCodeInvalidSession, ErrorCodeInvalidProof, ErrorCodeReplayed:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"net/http"
+)
+
+// HTTPHandler returns a net/http middleware for a route, e.g. for chi or http.ServeMux.
+// It behaves exactly as a gin-gonic middleware of Handler with the same policy
+func (rcv *MerkleMiddleware) HTTPHandler(policy ...Option) func(http.Handler) http.Handler {
+	guard := rcv.newGuard(policy)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			guard.serve(w, req, func() {
+				next.ServeHTTP(w, req)
+			})
+		})
+	}
+}
+
+// ChallengeHTTPHandler returns a net/http handler that issues challenges for a route with a given policy,
+// see GetChallengeHTTPHandler
+func (rcv *MerkleMiddleware) ChallengeHTTPHandler(policy ...Option) http.Handler {
+	return GetChallengeHTTPHandler(rcv.routeOptions(policy)...)
+}
+
+// GetHTTPMiddleware is GetMerkleMiddleware for net/http, it wraps a handler that is called only
+// for requests with valid proofs of work
+func GetHTTPMiddleware(opts ...Option) func(http.Handler) http.Handler {
+	return NewMerkleMiddleware(opts...).HTTPHandler()
+}
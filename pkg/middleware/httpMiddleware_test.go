@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPMiddleware(t *testing.T) {
+	pong := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("pong"))
+	})
+	ping := func(handler http.Handler, setup func(req *http.Request)) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/ping", nil)
+		setup(req)
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	withProof := func(depth int) func(req *http.Request) {
+		return func(req *http.Request) {
+			headerPayload, err := GenerateMerkleHeader(depth, 3, "md5")
+			require.NoError(t, err)
+			req.Header.Set(MerkleHeaderName, headerPayload)
+		}
+	}
+
+	t.Run("Proof_of_work_is_verified", func(t *testing.T) {
+		mux := http.NewServeMux()
+		mux.Handle("/ping", GetHTTPMiddleware(WithAllowedDepthRange(10, 12))(pong))
+		w := ping(mux, withProof(11))
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "pong", w.Body.String())
+
+		w = ping(mux, withProof(9))
+		assert.Equal(t, 406, w.Code)
+		var problem Problem
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+		assert.Equal(t, ErrorCodeTooEasy, problem.Code)
+		assert.NotEmpty(t, w.Header().Get(RequirementsHeaderName))
+	})
+
+	t.Run("Responses_match_gin_middleware", func(t *testing.T) {
+		opts := []Option{WithAllowedDepthRange(10, 12), WithDebugErrors(),
+			WithSessionKeys(SessionKey{ID: "k1", Secret: []byte("secret")}), WithSessionMinDepth(11)}
+		r := gin.New()
+		r.GET("/ping", GetMerkleMiddleware(opts...), gin.WrapH(pong))
+		handler := GetHTTPMiddleware(opts...)(pong)
+
+		for name, setup := range map[string]func(req *http.Request){
+			"Missing_header": func(*http.Request) {},
+			"Too_easy":       withProof(9),
+			"Too_hard":       withProof(13),
+			"Invalid_session": func(req *http.Request) {
+				req.Header.Set(SessionHeaderName, "s2.junk")
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				ginResponse, httpResponse := ping(r, setup), ping(handler, setup)
+				assert.Equal(t, 406, httpResponse.Code)
+				assert.Equal(t, ginResponse.Code, httpResponse.Code)
+				assert.Equal(t, ginResponse.Header(), httpResponse.Header())
+				assert.Equal(t, ginResponse.Body.String(), httpResponse.Body.String())
+			})
+		}
+
+		setup := withProof(11)
+		ginResponse, httpResponse := ping(r, setup), ping(handler, setup)
+		assert.Equal(t, 200, httpResponse.Code)
+		assert.Equal(t, ginResponse.Body.String(), httpResponse.Body.String())
+		assert.NotEmpty(t, ginResponse.Header().Get(SessionHeaderName))
+		assert.NotEmpty(t, httpResponse.Header().Get(SessionHeaderName))
+	})
+
+	t.Run("Routes_share_replay_store", func(t *testing.T) {
+		merkleMiddleware := NewMerkleMiddleware(WithAllowedDepthRange(10, 12))
+		mux := http.NewServeMux()
+		mux.Handle("/ping", merkleMiddleware.HTTPHandler()(pong))
+		mux.Handle("/pong", merkleMiddleware.HTTPHandler(WithAllowedDepthRange(11, 12))(pong))
+
+		headerPayload, err := GenerateMerkleHeader(11, 3, "md5")
+		require.NoError(t, err)
+		setup := func(req *http.Request) {
+			req.Header.Set(MerkleHeaderName, headerPayload)
+		}
+		assert.Equal(t, 200, ping(mux, setup).Code)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/pong", nil)
+		setup(req)
+		mux.ServeHTTP(w, req)
+		assert.Equal(t, 406, w.Code)
+	})
+
+	t.Run("Challenges", func(t *testing.T) {
+		merkleMiddleware := NewMerkleMiddleware(WithChallengeSecret([]byte("secret")), WithRequiredChallenge(),
+			WithChallengeDifficulty(11, 3, 1), WithChallengeLifeTime(time.Minute))
+		mux := http.NewServeMux()
+		mux.Handle("/challenge", merkleMiddleware.ChallengeHTTPHandler())
+		mux.Handle("/ping", merkleMiddleware.HTTPHandler()(pong))
+
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/challenge", nil))
+		require.Equal(t, 200, w.Code)
+		assert.Equal(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+		var challenge Challenge
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &challenge))
+
+		headerPayload, err := GenerateMerkleHeaderForChallenge(context.Background(), challenge, "md5")
+		require.NoError(t, err)
+		w = ping(mux, func(req *http.Request) {
+			req.Header.Set(MerkleHeaderName, headerPayload)
+		})
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "pong", w.Body.String())
+	})
+}
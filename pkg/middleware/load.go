@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock allows to substitute time, e.g. in tests
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is a Clock of the system time
+type SystemClock struct{}
+
+func (rcv SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// ManualClock is a Clock that moves only when it is asked to, e.g. in tests
+type ManualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewManualClock creates a ManualClock that shows a given time
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (rcv *ManualClock) Now() time.Time {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return rcv.now
+}
+
+// Advance moves a clock forward
+func (rcv *ManualClock) Advance(d time.Duration) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	rcv.now = rcv.now.Add(d)
+}
+
+// Load is a snapshot of a server's load
+type Load struct {
+	// RequestRate is a number of requests per second
+	RequestRate float64
+	// InFlight is a number of requests being served at the moment
+	InFlight int
+	// VerificationLatency is an average time spent on verification of a proof of work
+	VerificationLatency time.Duration
+}
+
+// LoadSignal provides a current load of a server to AdaptiveDifficulty
+type LoadSignal interface {
+	Load() Load
+}
+
+// RequestObserver is notified by a merkle middleware about every request.
+// A LoadSignal may implement it in order to measure a load by itself, see RequestMeter
+type RequestObserver interface {
+	RequestStarted()
+	RequestFinished(verificationLatency time.Duration)
+}
+
+// requestBucket aggregates requests of a single second
+type requestBucket struct {
+	second     int64
+	requests   int
+	finished   int
+	latencySum time.Duration
+}
+
+// RequestMeter is a LoadSignal that measures requests passed through a merkle middleware.
+// A request rate and a latency are computed over a sliding window of whole seconds
+type RequestMeter struct {
+	mu       sync.Mutex
+	clock    Clock
+	buckets  []requestBucket
+	inFlight int
+}
+
+// confirm interfaces' implementation
+var (
+	_ LoadSignal      = (*RequestMeter)(nil)
+	_ RequestObserver = (*RequestMeter)(nil)
+)
+
+// NewRequestMeter creates a RequestMeter with a given window, a nil clock means the system one
+func NewRequestMeter(window time.Duration, clock Clock) *RequestMeter {
+	if clock == nil {
+		clock = SystemClock{}
+	}
+	seconds := int(window / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return &RequestMeter{
+		clock:   clock,
+		buckets: make([]requestBucket, seconds),
+	}
+}
+
+// bucket returns a bucket of the current second, it has to be called under the lock
+func (rcv *RequestMeter) bucket() *requestBucket {
+	second := rcv.clock.Now().Unix()
+	result := &rcv.buckets[second%int64(len(rcv.buckets))]
+	if result.second != second {
+		*result = requestBucket{second: second}
+	}
+	return result
+}
+
+func (rcv *RequestMeter) RequestStarted() {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	rcv.inFlight++
+	rcv.bucket().requests++
+}
+
+func (rcv *RequestMeter) RequestFinished(verificationLatency time.Duration) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	rcv.inFlight--
+	bucket := rcv.bucket()
+	bucket.finished++
+	bucket.latencySum += verificationLatency
+}
+
+func (rcv *RequestMeter) Load() Load {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	oldestSecond := rcv.clock.Now().Unix() - int64(len(rcv.buckets)) + 1
+	var requests, finished int
+	var latencySum time.Duration
+	for _, bucket := range rcv.buckets {
+		if bucket.second < oldestSecond {
+			continue
+		}
+		requests += bucket.requests
+		finished += bucket.finished
+		latencySum += bucket.latencySum
+	}
+
+	result := Load{
+		RequestRate: float64(requests) / float64(len(rcv.buckets)),
+		InFlight:    rcv.inFlight,
+	}
+	if finished > 0 {
+		result.VerificationLatency = latencySum / time.Duration(finished)
+	}
+	return result
+}
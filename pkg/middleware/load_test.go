@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newFakeClock() *ManualClock {
+	return NewManualClock(time.Unix(1700000000, 0))
+}
+
+func TestRequestMeter(t *testing.T) {
+	clock := newFakeClock()
+	meter := NewRequestMeter(4*time.Second, clock)
+	assert.Equal(t, Load{}, meter.Load())
+
+	for i := 0; i < 8; i++ {
+		meter.RequestStarted()
+	}
+	for i := 0; i < 6; i++ {
+		meter.RequestFinished(time.Duration(i+1) * time.Millisecond)
+	}
+	assert.Equal(t, Load{RequestRate: 2, InFlight: 2, VerificationLatency: 3500 * time.Microsecond}, meter.Load())
+
+	clock.Advance(2 * time.Second)
+	meter.RequestStarted()
+	assert.Equal(t, Load{RequestRate: 2.25, InFlight: 3, VerificationLatency: 3500 * time.Microsecond}, meter.Load())
+
+	// the first second leaves the window
+	clock.Advance(2 * time.Second)
+	assert.Equal(t, Load{RequestRate: 0.25, InFlight: 3}, meter.Load())
+
+	clock.Advance(time.Hour)
+	assert.Equal(t, Load{InFlight: 3}, meter.Load())
+}
@@ -1,111 +1,269 @@
 package middleware
 
 import (
+	"context"
+	"encoding"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/evilaffliction/merkle/pkg/algo/merkle"
 	"github.com/evilaffliction/merkle/pkg/algo/merkle/impl"
 	"github.com/gin-gonic/gin"
-
-	"github.com/evilaffliction/merkle/pkg/rest"
-
-	"github.com/bluele/gcache"
 )
 
 // MerkleHeaderName represents a name for a header that contains PoW
 const MerkleHeaderName = "Merkle-Check"
 
+// validateMerkleHeader runs checks from the cheapest to the most expensive ones:
+// syntactic checks of a header, a freshness of an access token or a challenge, a request binding,
+// a verification of a proof of work and an atomic replay mark. It returns a verified proof of work
 func validateMerkleHeader(
-	header []string,
-	accessTokenCache gcache.Cache,
+	req *http.Request,
+	replayStore ReplayStore,
 	cfg config,
-) error {
+	requirements Requirements,
+) (merkle.ProofOfWork, error) {
+	// syntactic checks
+	header := req.Header[MerkleHeaderName]
 	if len(header) == 0 {
-		return fmt.Errorf("no merkle auth header")
+		return nil, newVerificationError(ErrorCodeMissingHeader, "no merkle auth header")
 	}
 
 	if len(header) > 1 {
-		return fmt.Errorf("unexpected merkle header struct")
+		return nil, newVerificationError(ErrorCodeMalformedHeader, "unexpected merkle header struct")
 	}
 
-	pow, err := impl.RestoreProofOfWorkFromJSON([]byte(header[0]))
+	if len(header[0]) > cfg.maxHeaderSize {
+		return nil, newVerificationError(ErrorCodeMalformedHeader,
+			"merkle header of %d bytes is too large, max allowed size: %d", len(header[0]), cfg.maxHeaderSize)
+	}
+
+	pow, err := restoreProofOfWorkFromHeader(header[0])
 	if err != nil {
-		return fmt.Errorf("unexpected merkle header struct: %w", err)
+		return nil, newVerificationError(ErrorCodeMalformedHeader, "unexpected merkle header struct: %w", err)
 	}
 
-	accessTokenStr := pow.AccessToken()
-	_, err = accessTokenCache.Get(accessTokenStr)
-	switch {
-	case errors.Is(err, gcache.KeyNotFoundError):
-		// all is good, access token is fresh
-	case err != nil:
-		return fmt.Errorf("failed to verify request in cache history, error: %w", err)
-	default:
-		return fmt.Errorf("access tokent %s was already used", accessTokenStr)
+	if pow.Version() < requirements.MinProofVersion {
+		return nil, newVerificationError(ErrorCodeOutdatedVersion,
+			"proof of work version %d is outdated, min allowed version is %d",
+			pow.Version(), requirements.MinProofVersion)
+	}
+
+	if !slices.Contains(requirements.Hashes, pow.HashName()) {
+		return nil, newVerificationError(ErrorCodeUnsupportedHash, "hash function %q is not allowed, allowed: %s",
+			pow.HashName(), strings.Join(requirements.Hashes, ", "))
+	}
+
+	if pow.Depth() < requirements.MinDepth {
+		return nil, newVerificationError(ErrorCodeTooEasy, "prover depth %d is too small, min allowed: %d",
+			pow.Depth(), requirements.MinDepth)
+	}
+
+	if pow.Depth() > requirements.MaxDepth {
+		return nil, newVerificationError(ErrorCodeTooHard, "prover depth %d is too large, max allowed: %d",
+			pow.Depth(), requirements.MaxDepth)
+	}
+
+	if pow.ProofLeavesNum() < requirements.MinProofLeavesNum {
+		return nil, newVerificationError(ErrorCodeTooEasy, "prover proof leaves num %d is too small, min allowed: %d",
+			pow.ProofLeavesNum(), requirements.MinProofLeavesNum)
+	}
+
+	if pow.ProofLeavesNum() > requirements.MaxProofLeavesNum {
+		return nil, newVerificationError(ErrorCodeTooHard, "prover proof leaves num %d is too large, max allowed: %d",
+			pow.ProofLeavesNum(), requirements.MaxProofLeavesNum)
+	}
+
+	if pow.WorkFactor() < requirements.MinWorkFactor {
+		return nil, newVerificationError(ErrorCodeTooEasy, "prover work factor %d is too small, min allowed: %d",
+			pow.WorkFactor(), requirements.MinWorkFactor)
 	}
 
-	if err := accessTokenCache.Set(accessTokenStr, struct{}{}); err != nil {
-		return fmt.Errorf("failed to set cache, error: %w", err)
+	if pow.WorkFactor() > requirements.MaxWorkFactor {
+		return nil, newVerificationError(ErrorCodeTooHard, "prover work factor %d is too large, max allowed: %d",
+			pow.WorkFactor(), requirements.MaxWorkFactor)
 	}
 
-	if pow.Depth() < 10 || pow.ProofLeavesNum() < 3 {
-		return fmt.Errorf("prover work volume is too small")
+	// freshness
+	accessTokenStr, binding := splitDescription(pow.AccessToken())
+	var expiresAt time.Time
+	if isChallenge(accessTokenStr) {
+		if expiresAt, err = validateChallenge(accessTokenStr, pow, cfg); err != nil {
+			return nil, err
+		}
+	} else {
+		if requirements.ChallengeRequired {
+			return nil, newVerificationError(ErrorCodeChallengeRequired,
+				"proof of work is expected to be built for a server-issued challenge")
+		}
+		if expiresAt, err = validateAccessToken(accessTokenStr, cfg); err != nil {
+			return nil, err
+		}
 	}
 
-	if pow.Depth() > 25 || pow.ProofLeavesNum() > 10 {
-		return fmt.Errorf("verifier is expected to have large amount of work")
+	// binding
+	if requirements.BindingRequired {
+		if err := validateBinding(req, binding, requirements.BoundHeaders, cfg.maxBoundBodySize); err != nil {
+			return nil, err
+		}
+	}
+
+	// verification
+	if err := pow.VerifyWithLimits(cfg.verificationLimits()); err != nil {
+		return nil, newVerificationError(ErrorCodeInvalidProof, "failed to verify pow: %w", err)
+	}
+
+	// a token is marked as used only after a successful verification, otherwise junk requests
+	// would fill a store and a malformed request would burn a token of a valid one
+	fresh, err := replayStore.MarkUsed(req.Context(), accessTokenStr, cfg.replayTTL(accessTokenStr, expiresAt))
+	switch {
+	case errors.Is(err, ErrReplayStoreSaturated):
+		return nil, newVerificationError(ErrorCodeSaturated, "failed to remember access token: %w", err)
+	case err != nil:
+		return nil, fmt.Errorf("failed to verify request in replay history, error: %w", err)
+	}
+	if !fresh {
+		return nil, newVerificationError(ErrorCodeReplayed, "access tokent %s was already used", accessTokenStr)
 	}
 
+	return pow, nil
+}
+
+// validateAccessToken checks a client-chosen access token and returns when it expires
+func validateAccessToken(accessTokenStr string, cfg config) (time.Time, error) {
 	accessToken, err := restoreAccessToken(accessTokenStr)
 	if err != nil {
-		return fmt.Errorf("failed to parse access token: %w", err)
+		return time.Time{}, newVerificationError(ErrorCodeInvalidAccessToken, "failed to parse access token: %w", err)
 	}
 
 	now := time.Now().UnixMicro()
 	if now < accessToken.TimeStampMicros {
-		return fmt.Errorf("prover time stamp is in future")
+		return time.Time{}, newVerificationError(ErrorCodeInvalidAccessToken, "prover time stamp is in future")
 	}
 
 	// 5 seconds
 	if now-accessToken.TimeStampMicros > cfg.accessTokenLifeTime.Microseconds() {
-		return fmt.Errorf("prover time stamp is dated")
+		return time.Time{}, newVerificationError(ErrorCodeExpired, "prover time stamp is dated")
+	}
+	return time.UnixMicro(accessToken.TimeStampMicros).Add(cfg.accessTokenLifeTime), nil
+}
+
+// MerkleMiddleware verifies proofs of work of several routes with different policies.
+// All of its handlers share the same store of used access tokens, so a proof of work
+// accepted by one route can not be reused for another one
+type MerkleMiddleware struct {
+	opts         []Option
+	replayStore  ReplayStore
+	sessionStore SessionStore
+}
+
+// NewMerkleMiddleware creates a MerkleMiddleware, "opts" define a default policy of its routes
+func NewMerkleMiddleware(opts ...Option) *MerkleMiddleware {
+	cfg := newConfigFromOptions(opts...)
+	replayStore := cfg.replayStore
+	if replayStore == nil {
+		replayStore = NewBucketReplayStore(cfg.accessTokenCacheSize)
+	}
+	sessionStore := cfg.sessionStore
+	if sessionStore == nil && cfg.sessionsEnabled() {
+		sessionStore = NewGCacheSessionStore(cfg.accessTokenCacheSize)
+	}
+	if watcher, ok := cfg.metrics.(ReplayStoreWatcher); ok {
+		watcher.WatchReplayStore(replayStore)
 	}
+	return &MerkleMiddleware{
+		opts:         opts,
+		replayStore:  replayStore,
+		sessionStore: sessionStore,
+	}
+}
 
-	if err := pow.Verify(); err != nil {
-		return fmt.Errorf("failed to verify pow: %w", err)
+// Handler returns a gin-gonic middleware for a route. "policy" overrides the default policy,
+// e.g. WithAllowedDepthRange allows to demand more work for expensive routes.
+// WithAccessTokenCacheSize, WithReplayStore and WithSessionStore have no effect here since stores are shared.
+//
+// A request is served either with a valid proof of work or with a valid session token, see WithSessionKeys.
+// A proof of work takes precedence, so a client with an exhausted session token can buy a new one at once
+func (rcv *MerkleMiddleware) Handler(policy ...Option) gin.HandlerFunc {
+	guard := rcv.newGuard(policy)
+	return func(ctx *gin.Context) {
+		if !guard.serve(ctx.Writer, ctx.Request, ctx.Next) {
+			ctx.Abort()
+		}
 	}
+}
+
+// ChallengeHandler returns a gin-gonic handler that issues challenges for a route with a given policy,
+// see GetChallengeHandler
+func (rcv *MerkleMiddleware) ChallengeHandler(policy ...Option) gin.HandlerFunc {
+	return GetChallengeHandler(rcv.routeOptions(policy)...)
+}
+
+// Requirements returns the least acceptable parameters of a proof of work for a client that sent a request.
+// "policy" is a route's policy, see Handler
+func (rcv *MerkleMiddleware) Requirements(req *http.Request, policy ...Option) Requirements {
+	cfg := newConfigFromOptions(rcv.routeOptions(policy)...)
+	return cfg.currentRequirements(cfg.clientKey(req))
+}
 
-	return nil
+// routeOptions puts a route's policy on top of default options
+func (rcv *MerkleMiddleware) routeOptions(policy []Option) []Option {
+	// a full slice expression makes append to copy default options instead of sharing them between routes
+	return append(rcv.opts[:len(rcv.opts):len(rcv.opts)], policy...)
 }
 
 // GetMerkleMiddleware returns a fully ready gin-gonic middleware for a POW
 // functionality based on merkle trees.
-// One should use GenerateMerkleHeader to build a correct header for this middleware
+// One should use GenerateMerkleHeader to build a correct header for this middleware.
+// Use NewMerkleMiddleware to apply different policies to different routes
 func GetMerkleMiddleware(opts ...Option) gin.HandlerFunc {
-	cfg := newConfigFromOptions(opts...)
-	accessTokenCache := gcache.New(cfg.accessTokenCacheSize).Expiration(time.Minute).Build()
-	return func(ctx *gin.Context) {
-		if err := validateMerkleHeader(ctx.Request.Header[MerkleHeaderName], accessTokenCache, cfg); err != nil {
-			// TODO: remove err details from a response for a better security
-			rest.EndpointSecurityResponse(ctx, fmt.Errorf("merkle tree verification failed, error: %w", err))
-			return
-		}
-
-		ctx.Next()
-	}
+	return NewMerkleMiddleware(opts...).Handler()
 }
 
 // GenerateMerkleHeader generates compact, serialized PoW based on Merkle trees.
 // Header from this function is supposed to be served by a middleware from GetMerkleMiddlware
-func GenerateMerkleHeader(depth int, proofLeavesNum int, hashFunc string) (string, error) {
-	accessToken := newAccessToken()
-	tree, err := impl.NewTree(
+func GenerateMerkleHeader(depth int, proofLeavesNum int, hashFunc string, opts ...HeaderOption) (string, error) {
+	return GenerateMerkleHeaderWithContext(context.Background(), depth, proofLeavesNum, hashFunc, opts...)
+}
+
+// GenerateMerkleHeaderWithContext is the same as GenerateMerkleHeader, but stops generation
+// as soon as a given context is cancelled
+func GenerateMerkleHeaderWithContext(
+	ctx context.Context,
+	depth int,
+	proofLeavesNum int,
+	hashFunc string,
+	opts ...HeaderOption,
+) (string, error) {
+	return generateMerkleHeader(ctx, newAccessToken().String(), depth, proofLeavesNum, hashFunc, opts...)
+}
+
+// generateMerkleHeader builds a merkle tree for a given description and serializes its proof of work
+func generateMerkleHeader(
+	ctx context.Context,
+	description string,
+	depth int,
+	proofLeavesNum int,
+	hashFunc string,
+	opts ...HeaderOption,
+) (string, error) {
+	cfg := newHeaderConfigFromOptions(opts...)
+	if cfg.binding != "" {
+		description = bindDescription(description, cfg.binding)
+	}
+	tree, err := impl.NewTreeWithContext(
+		ctx,
 		hashFunc,
 		depth,
 		proofLeavesNum,
-		accessToken.String(),
+		description,
+		cfg.treeOpts...,
 	)
 	if err != nil {
 		return "", fmt.Errorf("failed to create new merkle tree: %w", err)
@@ -115,6 +273,30 @@ func GenerateMerkleHeader(depth int, proofLeavesNum int, hashFunc string) (strin
 	if err != nil {
 		return "", fmt.Errorf("failed to generate proof of work: %w", err)
 	}
+	if cfg.multiProof {
+		pow, err = impl.NewMultiProof(pow)
+		if err != nil {
+			return "", fmt.Errorf("failed to convert proof of work to a multiproof: %w", err)
+		}
+	}
+
+	return encodeProofOfWorkToHeader(pow, cfg.binaryEncoding)
+}
+
+// encodeProofOfWorkToHeader serializes a proof of work either as JSON or
+// as base64url encoded binary data
+func encodeProofOfWorkToHeader(pow merkle.ProofOfWork, binaryEncoding bool) (string, error) {
+	if binaryEncoding {
+		marshaler, ok := pow.(encoding.BinaryMarshaler)
+		if !ok {
+			return "", fmt.Errorf("proof of work of type %T has no binary encoding", pow)
+		}
+		binaryData, err := marshaler.MarshalBinary()
+		if err != nil {
+			return "", fmt.Errorf("failed to binary marshal merkle header, error: %w", err)
+		}
+		return base64.RawURLEncoding.EncodeToString(binaryData), nil
+	}
 
 	jsonData, err := json.Marshal(pow)
 	if err != nil {
@@ -123,3 +305,17 @@ func GenerateMerkleHeader(depth int, proofLeavesNum int, hashFunc string) (strin
 
 	return string(jsonData), nil
 }
+
+// restoreProofOfWorkFromHeader detects an encoding of a header, a JSON header is always an object
+// and base64url alphabet has no curly brackets
+func restoreProofOfWorkFromHeader(header string) (merkle.ProofOfWork, error) {
+	if strings.HasPrefix(strings.TrimSpace(header), "{") {
+		return impl.RestoreProofOfWorkFromJSON([]byte(header))
+	}
+
+	binaryData, err := base64.RawURLEncoding.DecodeString(header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64url header: %w", err)
+	}
+	return impl.RestoreProofOfWorkFromBinary(binaryData)
+}
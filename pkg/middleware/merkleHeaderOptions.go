@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"github.com/evilaffliction/merkle/pkg/algo/merkle/impl"
+)
+
+type headerConfig struct {
+	treeOpts       []impl.TreeOption
+	binaryEncoding bool
+	multiProof     bool
+	binding        string
+}
+
+func newHeaderConfigFromOptions(opts ...HeaderOption) headerConfig {
+	var cfg headerConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// HeaderOption allows to customize generation of a merkle header
+type HeaderOption func(cfg *headerConfig)
+
+// WithTreeOptions allows to customize a build process of a merkle tree behind a header
+func WithTreeOptions(opts ...impl.TreeOption) HeaderOption {
+	return func(cfg *headerConfig) {
+		cfg.treeOpts = append(cfg.treeOpts, opts...)
+	}
+}
+
+// WithProgress allows to track generation of a header,
+// "progress" is called with a number of done hash computations of a merkle tree and their total number
+func WithProgress(progress impl.ProgressFunc) HeaderOption {
+	return WithTreeOptions(impl.WithProgress(progress))
+}
+
+// WithBinaryEncoding makes a header to be a base64url encoded binary proof of work instead of JSON.
+// It is about twice more compact and helps to fit into header size limits of proxies
+func WithBinaryEncoding() HeaderOption {
+	return func(cfg *headerConfig) {
+		cfg.binaryEncoding = true
+	}
+}
+
+// WithMultiProof makes a header to contain a multiproof, i.e. indexes of selected leaves
+// and hash values of their siblings only. It is even more compact than a regular proof of work
+func WithMultiProof() HeaderOption {
+	return func(cfg *headerConfig) {
+		cfg.multiProof = true
+	}
+}
+
+// WithBinding ties a proof of work to a request, "binding" is a result of RequestBinding for the request
+func WithBinding(binding string) HeaderOption {
+	return func(cfg *headerConfig) {
+		cfg.binding = binding
+	}
+}
@@ -1,7 +1,15 @@
 package middleware
 
 import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
 	"time"
+
+	"github.com/evilaffliction/merkle/pkg/algo/hash"
+	"github.com/evilaffliction/merkle/pkg/algo/merkle"
+	"github.com/evilaffliction/merkle/pkg/algo/merkle/impl"
 )
 
 type config struct {
@@ -11,17 +19,56 @@ type config struct {
 	maxAllowedDepth          int
 	minAllowedProofLeavesNum int
 	maxAllowedProofLeavesNum int
+	minAllowedWorkFactor     int
+	maxAllowedWorkFactor     int
+	minAllowedProofVersion   int
+	allowedHashes            []string
+	maxHeaderSize            int
+	challengeSecret          []byte
+	challengeRequired        bool
+	challengeLifeTime        time.Duration
+	challengeDepth           int
+	challengeProofLeavesNum  int
+	challengeWorkFactor      int
+	adaptiveDifficulty       *AdaptiveDifficulty
+	reputation               *ClientReputation
+	debugErrors              bool
+	replayStore              ReplayStore
+	requestBinding           bool
+	boundHeaders             []string
+	maxBoundBodySize         int64
+	sessionKeys              []SessionKey
+	sessionMaxUses           int
+	sessionLifeTime          time.Duration
+	sessionMinDepth          int
+	sessionScope             string
+	sessionsOnStrictRoutes   bool
+	sessionStore             SessionStore
+	sessionCookie            bool
+	metrics                  MetricsSink
 }
 
 func newConfigFromOptions(opts ...Option) config {
 	// default values
 	cfg := config{
-		accessTokenCacheSize:     1000,
+		accessTokenCacheSize:     100000,
 		accessTokenLifeTime:      5 * time.Second,
 		minAllowedDepth:          10,
 		maxAllowedDepth:          25,
 		minAllowedProofLeavesNum: 3,
 		maxAllowedProofLeavesNum: 10,
+		minAllowedWorkFactor:     1,
+		maxAllowedWorkFactor:     16,
+		minAllowedProofVersion:   impl.ProofVersionKeyed,
+		maxHeaderSize:            16 << 10,
+		challengeLifeTime:        30 * time.Second,
+		challengeDepth:           20,
+		challengeProofLeavesNum:  5,
+		challengeWorkFactor:      1,
+		maxBoundBodySize:         1 << 20,
+		sessionMaxUses:           100,
+		sessionLifeTime:          5 * time.Minute,
+		sessionMinDepth:          math.MaxInt, // no session is issued until a min depth is chosen explicitly
 	}
 
 	// overrides
@@ -29,20 +76,123 @@ func newConfigFromOptions(opts ...Option) config {
 		opt(&cfg)
 	}
 
+	// a misconfigured key would silently reject every session it signs
+	if err := validateSessionKeys(cfg.sessionKeys); err != nil {
+		panic(fmt.Errorf("invalid session keys, error: %w", err))
+	}
+
 	return cfg
 }
 
+// currentRequirements raises allowed min values according to a server's load and a client's reputation,
+// but never above max values
+func (rcv config) currentRequirements(clientKey string) Requirements {
+	extraDepth, extraProofLeavesNum := 0, 0
+	if rcv.adaptiveDifficulty != nil {
+		extraDepth, extraProofLeavesNum = rcv.adaptiveDifficulty.ExtraDifficulty()
+	}
+	if rcv.reputation != nil {
+		clientExtraDepth, clientExtraProofLeavesNum := rcv.reputation.ExtraDifficulty(clientKey)
+		extraDepth += clientExtraDepth
+		extraProofLeavesNum += clientExtraProofLeavesNum
+	}
+	return Requirements{
+		MinDepth:          min(rcv.minAllowedDepth+extraDepth, rcv.maxAllowedDepth),
+		MaxDepth:          rcv.maxAllowedDepth,
+		MinProofLeavesNum: min(rcv.minAllowedProofLeavesNum+extraProofLeavesNum, rcv.maxAllowedProofLeavesNum),
+		MaxProofLeavesNum: rcv.maxAllowedProofLeavesNum,
+		MinWorkFactor:     rcv.minAllowedWorkFactor,
+		MaxWorkFactor:     rcv.maxAllowedWorkFactor,
+		MinProofVersion:   rcv.minAllowedProofVersion,
+		Hashes:            rcv.hashes(),
+		ChallengeRequired: rcv.challengeRequired,
+		BindingRequired:   rcv.requestBinding,
+		BoundHeaders:      rcv.boundHeaders,
+	}
+}
+
+// hashes returns sorted names of hash functions a proof of work may be built with,
+// all the registered ones by default
+func (rcv config) hashes() []string {
+	if rcv.allowedHashes == nil {
+		return hash.RegisteredHashers()
+	}
+	return rcv.allowedHashes
+}
+
+// retryAfter returns how long it takes for raised requirements of a client to be lowered,
+// zero means that requirements are not raised
+func (rcv config) retryAfter(clientKey string) time.Duration {
+	var candidates []time.Duration
+	if rcv.adaptiveDifficulty != nil {
+		candidates = append(candidates, rcv.adaptiveDifficulty.retryAfter())
+	}
+	if rcv.reputation != nil {
+		candidates = append(candidates, rcv.reputation.retryAfter(clientKey))
+	}
+	var result time.Duration
+	for _, d := range candidates {
+		if d > 0 && (result == 0 || d < result) {
+			result = d
+		}
+	}
+	return result
+}
+
+// clientKey returns a key of a client for a reputation, it is empty without a reputation
+func (rcv config) clientKey(req *http.Request) string {
+	if rcv.reputation == nil {
+		return ""
+	}
+	return rcv.reputation.Key(req)
+}
+
+// replayTTL returns how long a valid token has to be remembered: until it expires, but no longer than
+// a challenge life time for a challenge or an access token life time for an access token. A challenge is
+// signed with its own expiration, so a challenge issued with a longer life time is remembered for a route's one
+func (rcv config) replayTTL(token string, expiresAt time.Time) time.Duration {
+	maxTTL := rcv.accessTokenLifeTime
+	if isChallenge(token) {
+		maxTTL = rcv.challengeLifeTime
+	}
+	return min(max(time.Until(expiresAt), 0), maxTTL)
+}
+
+// sessionsEnabled reports whether a middleware accepts and issues session tokens
+func (rcv config) sessionsEnabled() bool {
+	return len(rcv.sessionKeys) > 0
+}
+
+// verificationLimits bounds a verifier's job according to the allowed ranges
+func (rcv config) verificationLimits() merkle.Limits {
+	limits := impl.DefaultLimits
+	limits.MaxDepth = rcv.maxAllowedDepth
+	limits.MaxProofLeavesNum = rcv.maxAllowedProofLeavesNum
+	limits.MaxWorkFactor = rcv.maxAllowedWorkFactor
+	return limits
+}
+
 // Option allows to customize Merkle middleware
 type Option func(cfg *config)
 
-// WithAccessTokenCacheSize allows to specify cache size for access token.
-// Access tokens prohibit to reuse compute POW to create microbursts
+// WithAccessTokenCacheSize allows to specify max number of remembered access tokens.
+// Access tokens prohibit to reuse compute POW to create microbursts. A token is remembered while it is valid,
+// so the size should exceed a number of requests per access token life time, otherwise
+// a default store is saturated and rejects new requests, see BucketReplayStore
 func WithAccessTokenCacheSize(size int) Option {
 	return func(cfg *config) {
 		cfg.accessTokenCacheSize = size
 	}
 }
 
+// WithReplayStore allows to share used access tokens and challenges between several servers,
+// e.g. with a RedisReplayStore. An in-process BucketReplayStore of WithAccessTokenCacheSize is used by default
+func WithReplayStore(store ReplayStore) Option {
+	return func(cfg *config) {
+		cfg.replayStore = store
+	}
+}
+
 // WithAccessTokenLifeTime allows to specify how long access token will be accepted
 // after creation
 func WithAccessTokenLifeTime(d time.Duration) Option {
@@ -74,3 +224,192 @@ func WithAllowedProofLeavesNum(minLeavesNum, maxLeavesNum int) Option {
 		cfg.maxAllowedProofLeavesNum = maxLeavesNum
 	}
 }
+
+// WithAllowedWorkFactorRange allows to specify acceptable range of a merkle tree's work factor,
+// i.e. a number of hash computations per leaf. Unlike a depth, it allows to change a prover's job
+// with a linear granularity, see impl.EstimateHashComputations.
+// Verifier's job grows linearly with a max work factor too
+func WithAllowedWorkFactorRange(minWorkFactor, maxWorkFactor int) Option {
+	if minWorkFactor > maxWorkFactor {
+		minWorkFactor, maxWorkFactor = maxWorkFactor, minWorkFactor
+	}
+	return func(cfg *config) {
+		cfg.minAllowedWorkFactor = minWorkFactor
+		cfg.maxAllowedWorkFactor = maxWorkFactor
+	}
+}
+
+// WithMinProofVersion allows to specify the oldest accepted proof of work format version.
+// Proofs of impl.ProofVersionSeeded can be precomputed, so they are rejected by default
+func WithMinProofVersion(version int) Option {
+	return func(cfg *config) {
+		cfg.minAllowedProofVersion = version
+	}
+}
+
+// WithAllowedHashes allows to specify names of hash functions a proof of work may be built with,
+// e.g. to reject fast ones such as md5. All the registered hash functions are allowed by default
+func WithAllowedHashes(names ...string) Option {
+	names = slices.Clone(names)
+	slices.Sort(names)
+	return func(cfg *config) {
+		cfg.allowedHashes = names
+	}
+}
+
+// WithMaxHeaderSize allows to specify max size of a merkle header in bytes.
+// Larger headers are rejected before parsing
+func WithMaxHeaderSize(size int) Option {
+	return func(cfg *config) {
+		cfg.maxHeaderSize = size
+	}
+}
+
+// WithChallengeSecret enables server-issued challenges, see GetChallengeHandler.
+// Challenges are signed with HMAC-SHA256 and the given secret, so the secret should be shared
+// by all the servers that accept challenges of each other.
+// Client-chosen access tokens are still accepted unless WithRequiredChallenge is used
+func WithChallengeSecret(secret []byte) Option {
+	return func(cfg *config) {
+		cfg.challengeSecret = secret
+	}
+}
+
+// WithRequiredChallenge makes a middleware to accept proofs of work for server-issued challenges only.
+// Client-chosen access tokens can be mined ahead of time right up to the access token life time
+func WithRequiredChallenge() Option {
+	return func(cfg *config) {
+		cfg.challengeRequired = true
+	}
+}
+
+// WithChallengeLifeTime allows to specify how long a challenge will be accepted after its issue
+func WithChallengeLifeTime(d time.Duration) Option {
+	return func(cfg *config) {
+		cfg.challengeLifeTime = d
+	}
+}
+
+// WithChallengeDifficulty allows to specify a merkle tree that a prover has to build for a challenge
+func WithChallengeDifficulty(depth, proofLeavesNum, workFactor int) Option {
+	return func(cfg *config) {
+		cfg.challengeDepth = depth
+		cfg.challengeProofLeavesNum = proofLeavesNum
+		cfg.challengeWorkFactor = workFactor
+	}
+}
+
+// WithAdaptiveDifficulty allows to raise min acceptable depth and proof leaves num while a server is overloaded.
+// A controller may be shared by several routes and middlewares
+func WithAdaptiveDifficulty(controller *AdaptiveDifficulty) Option {
+	return func(cfg *config) {
+		cfg.adaptiveDifficulty = controller
+	}
+}
+
+// WithClientReputation allows to raise min acceptable depth and proof leaves num for clients
+// that send many requests or many invalid proofs of work.
+// A reputation may be shared by several routes and middlewares
+func WithClientReputation(reputation *ClientReputation) Option {
+	return func(cfg *config) {
+		cfg.reputation = reputation
+	}
+}
+
+// WithDebugErrors makes rejections to include a detailed reason. The details disclose internals
+// of a verification, so they should not be exposed to untrusted clients
+func WithDebugErrors() Option {
+	return func(cfg *config) {
+		cfg.debugErrors = true
+	}
+}
+
+// WithMetrics makes a middleware to report an outcome of every verification to a sink, e.g. Metrics.
+// A sink of a default policy that is a ReplayStoreWatcher watches a replay store of a middleware as well
+func WithMetrics(sink MetricsSink) Option {
+	return func(cfg *config) {
+		cfg.metrics = sink
+	}
+}
+
+// WithRequestBinding makes a middleware to accept only proofs of work bound to requests they are sent with,
+// so a proof of work mined for a cheap route can not be spent on an expensive one. A binding covers a method,
+// a path with a query, a body and given headers, see RequestBinding
+func WithRequestBinding(headers ...string) Option {
+	return func(cfg *config) {
+		cfg.requestBinding = true
+		cfg.boundHeaders = headers
+	}
+}
+
+// WithMaxBoundBodySize allows to specify max size of a body in bytes that is read to check a request binding.
+// Requests with larger bodies are rejected
+func WithMaxBoundBodySize(size int64) Option {
+	return func(cfg *config) {
+		cfg.maxBoundBodySize = size
+	}
+}
+
+// WithSessionKeys enables session tokens: a valid proof of work of at least WithSessionMinDepth buys a token
+// signed with HMAC-SHA256 and the current key, the token is accepted instead of a proof of work for
+// WithSessionLimits uses or time on routes of its scope, see WithSessionScope.
+// Tokens signed with previous keys are accepted as well, so keys can be rotated: a new key becomes the current one
+// and an old one stays previous for a session life time. All the servers that accept tokens of each other
+// should share the keys and a SessionStore. A middleware panics when a key has an empty ID, an ID with a dot
+// or an empty secret
+func WithSessionKeys(current SessionKey, previous ...SessionKey) Option {
+	return func(cfg *config) {
+		cfg.sessionKeys = append([]SessionKey{current}, previous...)
+	}
+}
+
+// WithSessionLimits allows to specify how many times and how long a session token is accepted after its issue
+func WithSessionLimits(maxUses int, lifeTime time.Duration) Option {
+	return func(cfg *config) {
+		cfg.sessionMaxUses = maxUses
+		cfg.sessionLifeTime = lifeTime
+	}
+}
+
+// WithSessionMinDepth allows to issue session tokens only for proofs of work with at least a given depth,
+// so a session costs more than a single request. Shallower proofs of work are still accepted for their requests.
+// No session token is issued without this option
+func WithSessionMinDepth(depth int) Option {
+	return func(cfg *config) {
+		cfg.sessionMinDepth = depth
+	}
+}
+
+// WithSessionScope allows routes to share session tokens: a token is accepted only by routes of the scope
+// it was issued for, and a scope is a path of a request by default. A token bought with a proof of work
+// easier than current requirements of a route is rejected
+func WithSessionScope(scope string) Option {
+	return func(cfg *config) {
+		cfg.sessionScope = scope
+	}
+}
+
+// WithSessionsOnStrictRoutes allows session tokens on routes that require challenges or request binding,
+// see WithRequiredChallenge and WithRequestBinding. A session token is neither a challenge nor bound
+// to a request, so it weakens such routes
+func WithSessionsOnStrictRoutes() Option {
+	return func(cfg *config) {
+		cfg.sessionsOnStrictRoutes = true
+	}
+}
+
+// WithSessionStore allows to share counters of session token uses between several servers,
+// e.g. with a RedisReplayStore. An in-process GCacheSessionStore of WithAccessTokenCacheSize is used by default
+func WithSessionStore(store SessionStore) Option {
+	return func(cfg *config) {
+		cfg.sessionStore = store
+	}
+}
+
+// WithSessionCookie makes a middleware to set session tokens as cookies in addition to a SessionHeaderName header
+// and to accept them from cookies, which suits browsers
+func WithSessionCookie() Option {
+	return func(cfg *config) {
+		cfg.sessionCookie = true
+	}
+}
@@ -4,15 +4,44 @@ import (
 	"testing"
 	"time"
 
+	"github.com/evilaffliction/merkle/pkg/algo/hash"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestConfigCreation(t *testing.T) {
+	adaptiveDifficulty := NewAdaptiveDifficulty(NewRequestMeter(time.Second, nil))
+	reputation := NewClientReputation()
+	replayStore := NewGCacheReplayStore(1)
+	sessionStore := NewGCacheSessionStore(1)
+	metrics := NewMetrics()
+	sessionKeys := []SessionKey{{ID: "k2", Secret: []byte("new")}, {ID: "k1", Secret: []byte("old")}}
 	cfg := newConfigFromOptions(
 		WithAccessTokenCacheSize(42),
 		WithAccessTokenLifeTime(10*time.Minute),
 		WithAllowedDepthRange(3, 33),
 		WithAllowedProofLeavesNum(77, 7),
+		WithAllowedWorkFactorRange(20, 2),
+		WithMinProofVersion(0),
+		WithAllowedHashes("sha256", "blake2b-256"),
+		WithMaxHeaderSize(1024),
+		WithChallengeSecret([]byte("secret")),
+		WithRequiredChallenge(),
+		WithChallengeLifeTime(time.Minute),
+		WithChallengeDifficulty(15, 4, 2),
+		WithAdaptiveDifficulty(adaptiveDifficulty),
+		WithClientReputation(reputation),
+		WithDebugErrors(),
+		WithReplayStore(replayStore),
+		WithRequestBinding("Content-Type"),
+		WithMaxBoundBodySize(4096),
+		WithSessionKeys(sessionKeys[0], sessionKeys[1]),
+		WithSessionLimits(10, time.Hour),
+		WithSessionMinDepth(20),
+		WithSessionScope("api"),
+		WithSessionsOnStrictRoutes(),
+		WithSessionStore(sessionStore),
+		WithSessionCookie(),
+		WithMetrics(metrics),
 	)
 	assert.Equal(t, config{
 		accessTokenCacheSize:     42,
@@ -21,5 +50,54 @@ func TestConfigCreation(t *testing.T) {
 		maxAllowedDepth:          33,
 		minAllowedProofLeavesNum: 7,
 		maxAllowedProofLeavesNum: 77,
+		minAllowedWorkFactor:     2,
+		maxAllowedWorkFactor:     20,
+		minAllowedProofVersion:   0,
+		allowedHashes:            []string{"blake2b-256", "sha256"},
+		maxHeaderSize:            1024,
+		challengeSecret:          []byte("secret"),
+		challengeRequired:        true,
+		challengeLifeTime:        time.Minute,
+		challengeDepth:           15,
+		challengeProofLeavesNum:  4,
+		challengeWorkFactor:      2,
+		adaptiveDifficulty:       adaptiveDifficulty,
+		reputation:               reputation,
+		debugErrors:              true,
+		replayStore:              replayStore,
+		requestBinding:           true,
+		boundHeaders:             []string{"Content-Type"},
+		maxBoundBodySize:         4096,
+		sessionKeys:              sessionKeys,
+		sessionMaxUses:           10,
+		sessionLifeTime:          time.Hour,
+		sessionMinDepth:          20,
+		sessionScope:             "api",
+		sessionsOnStrictRoutes:   true,
+		sessionStore:             sessionStore,
+		sessionCookie:            true,
+		metrics:                  metrics,
 	}, cfg)
 }
+
+func TestAllowedHashes(t *testing.T) {
+	assert.Equal(t, hash.RegisteredHashers(), newConfigFromOptions().currentRequirements("").Hashes)
+	assert.Equal(t, []string{"sha256"}, newConfigFromOptions(WithAllowedHashes("sha256")).currentRequirements("").Hashes)
+}
+
+func TestReplayTTL(t *testing.T) {
+	cfg := newConfigFromOptions(WithAccessTokenLifeTime(5*time.Second), WithChallengeLifeTime(time.Minute))
+	accessToken := newAccessToken().String()
+	challenge := "c1.1.12.3.1.bm9uY2U.c2lnbmF0dXJl"
+
+	// a token is remembered only while it is valid
+	assert.InDelta(t, 3*time.Second, cfg.replayTTL(accessToken, time.Now().Add(3*time.Second)),
+		float64(100*time.Millisecond))
+	assert.InDelta(t, 10*time.Second, cfg.replayTTL(challenge, time.Now().Add(10*time.Second)),
+		float64(100*time.Millisecond))
+	assert.Zero(t, cfg.replayTTL(challenge, time.Now().Add(-time.Second)))
+
+	// but no longer than a configured life time
+	assert.Equal(t, 5*time.Second, cfg.replayTTL(accessToken, time.Now().Add(time.Hour)))
+	assert.Equal(t, time.Minute, cfg.replayTTL(challenge, time.Now().Add(time.Hour)))
+}
@@ -1,12 +1,21 @@
 package middleware
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/evilaffliction/merkle/pkg/algo/merkle/impl"
 )
 
 func TestMekleHeader(t *testing.T) {
@@ -48,3 +57,288 @@ func TestMekleHeader(t *testing.T) {
 		})
 	})
 }
+
+func TestMerkleHeaderEncodings(t *testing.T) {
+	r := gin.New()
+	r.Use(GetMerkleMiddleware())
+	r.GET("/ping", func(c *gin.Context) {
+		c.String(200, "pong")
+	})
+
+	jsonHeader, err := GenerateMerkleHeader(16, 5, "md5")
+	assert.NoError(t, err)
+	binaryHeader, err := GenerateMerkleHeader(16, 5, "md5", WithBinaryEncoding())
+	assert.NoError(t, err)
+	assert.Less(t, len(binaryHeader), len(jsonHeader))
+	assert.NotContains(t, binaryHeader, "{")
+	jsonMultiProofHeader, err := GenerateMerkleHeader(16, 5, "md5", WithMultiProof())
+	assert.NoError(t, err)
+	binaryMultiProofHeader, err := GenerateMerkleHeader(16, 5, "md5", WithMultiProof(), WithBinaryEncoding())
+	assert.NoError(t, err)
+	assert.Less(t, len(binaryMultiProofHeader), len(binaryHeader))
+	validHeaders := map[string]struct{}{
+		jsonHeader:             {},
+		binaryHeader:           {},
+		jsonMultiProofHeader:   {},
+		binaryMultiProofHeader: {},
+	}
+
+	for _, headerPayload := range []string{
+		jsonHeader, binaryHeader, jsonMultiProofHeader, binaryMultiProofHeader, "bm90IGEgcHJvb2Y", "{not a json",
+	} {
+		req, err := http.NewRequest("GET", "/ping", nil)
+		assert.NoError(t, err)
+		req.Header.Set(MerkleHeaderName, headerPayload)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if _, ok := validHeaders[headerPayload]; ok {
+			assert.Equal(t, 200, w.Code)
+		} else {
+			assert.Equal(t, 406, w.Code)
+		}
+	}
+}
+
+func TestMerkleWorkFactor(t *testing.T) {
+	r := gin.New()
+	r.Use(GetMerkleMiddleware(WithAllowedWorkFactorRange(2, 4)))
+	r.GET("/ping", func(c *gin.Context) {
+		c.String(200, "pong")
+	})
+
+	for workFactor, expectedCode := range map[int]int{1: 406, 3: 200, 5: 406} {
+		headerPayload, err := GenerateMerkleHeader(12, 3, "md5",
+			WithTreeOptions(impl.WithWorkFactor(workFactor)))
+		assert.NoError(t, err)
+
+		req, err := http.NewRequest("GET", "/ping", nil)
+		assert.NoError(t, err)
+		req.Header.Set(MerkleHeaderName, headerPayload)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, expectedCode, w.Code, "work factor %d", workFactor)
+	}
+}
+
+func TestGenerateMerkleHeaderWithContext(t *testing.T) {
+	t.Run("Progress_is_reported", func(t *testing.T) {
+		var lastHashed, lastTotal int
+		_, err := GenerateMerkleHeaderWithContext(context.Background(), 12, 3, "md5",
+			WithProgress(func(hashed int, total int) {
+				lastHashed, lastTotal = hashed, total
+			}))
+		assert.NoError(t, err)
+		assert.Equal(t, 1<<12-1, lastTotal)
+		assert.Equal(t, lastTotal, lastHashed)
+	})
+
+	t.Run("Cancelled_context_stops_generation", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := GenerateMerkleHeaderWithContext(ctx, 20, 3, "md5",
+			WithTreeOptions(impl.WithStreamingMode()))
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestMerkleMaxHeaderSize(t *testing.T) {
+	headerPayload, err := GenerateMerkleHeader(12, 3, "md5")
+	assert.NoError(t, err)
+
+	for maxHeaderSize, expectedCode := range map[int]int{len(headerPayload) - 1: 406, len(headerPayload): 200} {
+		r := gin.New()
+		r.Use(GetMerkleMiddleware(WithMaxHeaderSize(maxHeaderSize)))
+		r.GET("/ping", func(c *gin.Context) {
+			c.String(200, "pong")
+		})
+
+		req, err := http.NewRequest("GET", "/ping", nil)
+		assert.NoError(t, err)
+		req.Header.Set(MerkleHeaderName, headerPayload)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, expectedCode, w.Code, "max header size %d", maxHeaderSize)
+	}
+}
+
+func TestMerkleAllowedRanges(t *testing.T) {
+	r := gin.New()
+	r.Use(GetMerkleMiddleware(WithAllowedDepthRange(11, 13), WithAllowedProofLeavesNum(2, 4)))
+	r.GET("/ping", func(c *gin.Context) {
+		c.String(200, "pong")
+	})
+
+	for _, testCase := range []struct {
+		depth          int
+		proofLeavesNum int
+		expectedCode   int
+	}{
+		{depth: 10, proofLeavesNum: 3, expectedCode: 406},
+		{depth: 11, proofLeavesNum: 3, expectedCode: 200},
+		{depth: 13, proofLeavesNum: 3, expectedCode: 200},
+		{depth: 14, proofLeavesNum: 3, expectedCode: 406},
+		{depth: 12, proofLeavesNum: 1, expectedCode: 406},
+		{depth: 12, proofLeavesNum: 2, expectedCode: 200},
+		{depth: 12, proofLeavesNum: 4, expectedCode: 200},
+		{depth: 12, proofLeavesNum: 5, expectedCode: 406},
+	} {
+		headerPayload, err := GenerateMerkleHeader(testCase.depth, testCase.proofLeavesNum, "md5")
+		assert.NoError(t, err)
+
+		req, err := http.NewRequest("GET", "/ping", nil)
+		assert.NoError(t, err)
+		req.Header.Set(MerkleHeaderName, headerPayload)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, testCase.expectedCode, w.Code, "depth %d, proof leaves num %d",
+			testCase.depth, testCase.proofLeavesNum)
+	}
+}
+
+func TestMerkleAllowedHashes(t *testing.T) {
+	store := &countingReplayStore{ReplayStore: NewBucketReplayStore(100)}
+	r := gin.New()
+	r.Use(GetMerkleMiddleware(WithAllowedHashes("sha256"), WithReplayStore(store)))
+	r.GET("/ping", func(c *gin.Context) {
+		c.String(200, "pong")
+	})
+	call := func(hashName string) *httptest.ResponseRecorder {
+		headerPayload, err := GenerateMerkleHeader(10, 3, hashName)
+		require.NoError(t, err)
+		req := httptest.NewRequest("GET", "/ping", nil)
+		req.Header.Set(MerkleHeaderName, headerPayload)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := call("md5")
+	assert.Equal(t, 406, w.Code)
+	var problem Problem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	assert.Equal(t, ErrorCodeUnsupportedHash, problem.Code)
+	assert.Equal(t, []string{"sha256"}, problem.Requirements.Hashes)
+	assert.Equal(t, int32(0), store.marks.Load(), "a proof of work is rejected before a verification")
+
+	assert.Equal(t, 200, call("sha256").Code)
+}
+
+func TestMerkleRoutePolicies(t *testing.T) {
+	merkleMiddleware := NewMerkleMiddleware(WithAllowedDepthRange(10, 12))
+	r := gin.New()
+	r.GET("/cheap", merkleMiddleware.Handler(), func(c *gin.Context) {
+		c.String(200, "cheap")
+	})
+	r.GET("/expensive", merkleMiddleware.Handler(WithAllowedDepthRange(12, 16)), func(c *gin.Context) {
+		c.String(200, "expensive")
+	})
+	call := func(route string, depth int) int {
+		headerPayload, err := GenerateMerkleHeader(depth, 3, "md5")
+		assert.NoError(t, err)
+		req, err := http.NewRequest("GET", route, nil)
+		assert.NoError(t, err)
+		req.Header.Set(MerkleHeaderName, headerPayload)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, 200, call("/cheap", 10))
+	assert.Equal(t, 406, call("/expensive", 10))
+	assert.Equal(t, 406, call("/cheap", 14))
+	assert.Equal(t, 200, call("/expensive", 14))
+
+	t.Run("Access_tokens_are_shared_between_routes", func(t *testing.T) {
+		headerPayload, err := GenerateMerkleHeader(12, 3, "md5")
+		assert.NoError(t, err)
+		for _, testCase := range []struct {
+			route        string
+			expectedCode int
+		}{
+			{route: "/cheap", expectedCode: 200},
+			{route: "/expensive", expectedCode: 406},
+		} {
+			req, err := http.NewRequest("GET", testCase.route, nil)
+			assert.NoError(t, err)
+			req.Header.Set(MerkleHeaderName, headerPayload)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, testCase.expectedCode, w.Code, testCase.route)
+		}
+	})
+}
+
+// countingReplayStore counts attempts to mark a token as used
+type countingReplayStore struct {
+	ReplayStore
+	marks atomic.Int32
+}
+
+func (rcv *countingReplayStore) MarkUsed(ctx context.Context, token string, ttl time.Duration) (bool, error) {
+	rcv.marks.Add(1)
+	return rcv.ReplayStore.MarkUsed(ctx, token, ttl)
+}
+
+func TestMerkleValidationStages(t *testing.T) {
+	cfg := newConfigFromOptions(WithAllowedDepthRange(11, 13), WithChallengeSecret([]byte("secret")))
+	requirements := cfg.currentRequirements("")
+	store := &countingReplayStore{ReplayStore: NewBucketReplayStore(100)}
+	ctx := context.Background()
+	// every rejected header but a few syntactically broken ones carries the same access token as a valid one
+	description := newAccessToken().String()
+	header := func(description string, depth int, opts ...HeaderOption) string {
+		headerPayload, err := generateMerkleHeader(ctx, description, depth, 3, "md5", opts...)
+		require.NoError(t, err)
+		return headerPayload
+	}
+	valid := header(description, 12)
+	request := func(header ...string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header[MerkleHeaderName] = header
+		return req
+	}
+
+	var tampered map[string]any
+	require.NoError(t, json.Unmarshal([]byte(valid), &tampered))
+	tampered["node_stats"].([]any)[0].(map[string]any)["value"] = base64.StdEncoding.EncodeToString(make([]byte, 16))
+	tamperedHeader, err := json.Marshal(tampered)
+	require.NoError(t, err)
+
+	datedToken := newAccessToken()
+	datedToken.TimeStampMicros = time.Now().Add(-time.Minute).UnixMicro()
+	futureToken := newAccessToken()
+	futureToken.TimeStampMicros = time.Now().Add(time.Minute).UnixMicro()
+
+	for _, testCase := range []struct {
+		name   string
+		header []string
+		code   ErrorCode
+	}{
+		{"Missing_header", nil, ErrorCodeMissingHeader},
+		{"Several_headers", []string{valid, valid}, ErrorCodeMalformedHeader},
+		{"Too_large_header", []string{strings.Repeat("a", cfg.maxHeaderSize+1)}, ErrorCodeMalformedHeader},
+		{"Broken_header", []string{"{" + description}, ErrorCodeMalformedHeader},
+		{"Outdated_version", []string{header(description, 12,
+			WithTreeOptions(impl.WithProofVersion(impl.ProofVersionSeeded)))}, ErrorCodeOutdatedVersion},
+		{"Too_easy", []string{header(description, 10)}, ErrorCodeTooEasy},
+		{"Too_hard", []string{header(description, 14)}, ErrorCodeTooHard},
+		{"Dated_access_token", []string{header(datedToken.String(), 12)}, ErrorCodeExpired},
+		{"Future_access_token", []string{header(futureToken.String(), 12)}, ErrorCodeInvalidAccessToken},
+		{"Forged_challenge", []string{header("c1.1.12.3.1.bm9uY2U.c2lnbmF0dXJl", 12)}, ErrorCodeInvalidChallenge},
+		{"Invalid_proof", []string{string(tamperedHeader)}, ErrorCodeInvalidProof},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, err := validateMerkleHeader(request(testCase.header...), store, cfg, requirements)
+			assert.Equal(t, testCase.code, ErrorCodeOf(err), "%v", err)
+		})
+	}
+	assert.Equal(t, int32(0), store.marks.Load(), "rejected tokens are not marked as used")
+
+	t.Run("Valid_token_is_not_burned", func(t *testing.T) {
+		_, err := validateMerkleHeader(request(valid), store, cfg, requirements)
+		assert.NoError(t, err)
+		_, err = validateMerkleHeader(request(valid), store, cfg, requirements)
+		assert.Equal(t, ErrorCodeReplayed, ErrorCodeOf(err))
+		assert.Equal(t, int32(2), store.marks.Load())
+	})
+}
@@ -0,0 +1,244 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Verification is an outcome and a cost of a verification of a single request
+type Verification struct {
+	// Code is a reason of a rejection, it is empty for an accepted request
+	Code ErrorCode
+	// Depth is a depth of an accepted proof of work, it is zero for rejections and requests served by sessions
+	Depth int
+	// Session tells that a request was served or rejected by a session token instead of a proof of work
+	Session bool
+	// HeaderSize is a size of a merkle header in bytes, it is zero for requests without a header
+	HeaderSize int
+	// Latency is a time spent on a verification
+	Latency time.Duration
+}
+
+// Accepted reports whether a request was served
+func (rcv Verification) Accepted() bool {
+	return rcv.Code == ""
+}
+
+// MetricsSink receives an outcome of every verification of a middleware, see WithMetrics.
+// It is called synchronously for every request, so it has to be cheap and safe for concurrent use.
+// Metrics is a built-in sink, others may forward verifications to any monitoring system
+type MetricsSink interface {
+	ObserveVerification(verification Verification)
+}
+
+// ReplayStoreWatcher is a MetricsSink that reports an occupancy of replay stores.
+// NewMerkleMiddleware hands its replay store to a sink of a default policy that implements it,
+// so a sink shared by several middlewares watches several stores
+type ReplayStoreWatcher interface {
+	WatchReplayStore(store ReplayStore)
+}
+
+// default buckets of histograms of Metrics
+var (
+	verificationLatencyBuckets = []float64{
+		0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1,
+	}
+	headerSizeBuckets = []float64{256, 512, 1 << 10, 2 << 10, 4 << 10, 8 << 10, 16 << 10, 32 << 10}
+)
+
+// histogram is a cumulative histogram of the Prometheus data model, its buckets are upper inclusive bounds
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) histogram {
+	return histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (rcv *histogram) observe(value float64) {
+	if i, _ := slices.BinarySearch(rcv.bounds, value); i < len(rcv.bounds) {
+		rcv.counts[i]++
+	}
+	rcv.sum += value
+	rcv.count++
+}
+
+// write writes a histogram in the text exposition format, counts of buckets are made cumulative
+func (rcv *histogram) write(w io.Writer, name string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	var cumulative uint64
+	for i, bound := range rcv.bounds {
+		cumulative += rcv.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, rcv.count)
+	fmt.Fprintf(w, "%s_sum %s\n%s_count %d\n", name, formatFloat(rcv.sum), name, rcv.count)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Metrics is a MetricsSink that keeps counters of accepted proofs of work by depth and of rejections by reason,
+// histograms of a verification latency and of a header size and an occupancy of replay stores.
+// It is an http.Handler that exposes them in the Prometheus text exposition format
+type Metrics struct {
+	mu                  sync.Mutex
+	acceptedProofs      map[int]uint64
+	acceptedSessions    uint64
+	rejections          map[ErrorCode]uint64
+	verificationLatency histogram
+	headerSize          histogram
+	replayStores        []ReplayStore
+}
+
+// confirm interfaces' implementation
+var (
+	_ MetricsSink        = (*Metrics)(nil)
+	_ ReplayStoreWatcher = (*Metrics)(nil)
+	_ http.Handler       = (*Metrics)(nil)
+)
+
+// NewMetrics creates Metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		acceptedProofs:      make(map[int]uint64),
+		rejections:          make(map[ErrorCode]uint64),
+		verificationLatency: newHistogram(verificationLatencyBuckets),
+		headerSize:          newHistogram(headerSizeBuckets),
+	}
+}
+
+// ObserveVerification implements MetricsSink interface
+func (rcv *Metrics) ObserveVerification(verification Verification) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	switch {
+	case !verification.Accepted():
+		rcv.rejections[verification.Code]++
+	case verification.Session:
+		rcv.acceptedSessions++
+	default:
+		rcv.acceptedProofs[verification.Depth]++
+	}
+	rcv.verificationLatency.observe(verification.Latency.Seconds())
+	if verification.HeaderSize > 0 {
+		rcv.headerSize.observe(float64(verification.HeaderSize))
+	}
+}
+
+// WatchReplayStore implements ReplayStoreWatcher interface. A number of remembered tokens is reported
+// for a store with a Len method, e.g. BucketReplayStore, its capacity and saturations are reported when
+// a store has Cap and Saturations methods. A RedisReplayStore has none of them.
+// Every watched store is reported with a "store" label of its number in an order of watching, starting from 0,
+// a store watched twice is reported once
+func (rcv *Metrics) WatchReplayStore(store ReplayStore) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	for _, watched := range rcv.replayStores {
+		if watched == store {
+			return
+		}
+	}
+	rcv.replayStores = append(rcv.replayStores, store)
+}
+
+// ServeHTTP implements http.Handler interface, it writes metrics in the Prometheus text exposition format
+func (rcv *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	rcv.WriteText(w)
+}
+
+// WriteText writes metrics in the Prometheus text exposition format
+func (rcv *Metrics) WriteText(w io.Writer) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP merkle_proofs_accepted_total Accepted proofs of work by depth.\n"+
+		"# TYPE merkle_proofs_accepted_total counter\n")
+	depths := make([]int, 0, len(rcv.acceptedProofs))
+	for depth := range rcv.acceptedProofs {
+		depths = append(depths, depth)
+	}
+	slices.Sort(depths)
+	for _, depth := range depths {
+		fmt.Fprintf(w, "merkle_proofs_accepted_total{depth=\"%d\"} %d\n", depth, rcv.acceptedProofs[depth])
+	}
+
+	fmt.Fprintf(w, "# HELP merkle_sessions_accepted_total Requests served by session tokens.\n"+
+		"# TYPE merkle_sessions_accepted_total counter\nmerkle_sessions_accepted_total %d\n", rcv.acceptedSessions)
+
+	// every known reason is exposed, so a rate of a reason is known before its first rejection
+	fmt.Fprintf(w, "# HELP merkle_rejections_total Rejected requests by reason.\n"+
+		"# TYPE merkle_rejections_total counter\n")
+	codes := make([]ErrorCode, 0, len(errorTitles))
+	for code := range errorTitles {
+		codes = append(codes, code)
+	}
+	for code := range rcv.rejections {
+		if _, ok := errorTitles[code]; !ok {
+			codes = append(codes, code)
+		}
+	}
+	slices.Sort(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "merkle_rejections_total{reason=%q} %d\n", code, rcv.rejections[code])
+	}
+
+	rcv.verificationLatency.write(w, "merkle_verification_duration_seconds", "Time spent on verifications.")
+	rcv.headerSize.write(w, "merkle_header_size_bytes", "Sizes of merkle headers.")
+
+	rcv.writeReplayStores(w, "merkle_replay_store_tokens", "Tokens remembered by a replay store.", "gauge",
+		func(store ReplayStore) (int64, bool) {
+			if store, ok := store.(interface{ Len() int }); ok {
+				return int64(store.Len()), true
+			}
+			return 0, false
+		})
+	rcv.writeReplayStores(w, "merkle_replay_store_capacity", "Max number of tokens of a replay store.", "gauge",
+		func(store ReplayStore) (int64, bool) {
+			if store, ok := store.(interface{ Cap() int }); ok {
+				return int64(store.Cap()), true
+			}
+			return 0, false
+		})
+	rcv.writeReplayStores(w, "merkle_replay_store_saturations_total", "Tokens rejected by a full replay store.",
+		"counter", func(store ReplayStore) (int64, bool) {
+			if store, ok := store.(interface{ Saturations() int64 }); ok {
+				return store.Saturations(), true
+			}
+			return 0, false
+		})
+}
+
+// writeReplayStores writes a metric of every watched store that reports it, a metric is omitted
+// when no store reports it. It has to be called under the lock
+func (rcv *Metrics) writeReplayStores(
+	w io.Writer,
+	name string,
+	help string,
+	metricType string,
+	probe func(store ReplayStore) (int64, bool),
+) {
+	described := false
+	for i, store := range rcv.replayStores {
+		value, ok := probe(store)
+		if !ok {
+			continue
+		}
+		if !described {
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+			described = true
+		}
+		fmt.Fprintf(w, "%s{store=\"%d\"} %d\n", name, i, value)
+	}
+}
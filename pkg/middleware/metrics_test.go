@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram([]float64{1, 2, 4})
+	for _, value := range []float64{0.5, 1, 3, 5} {
+		h.observe(value)
+	}
+	var sb strings.Builder
+	h.write(&sb, "test", "Test values.")
+	assert.Equal(t, `# HELP test Test values.
+# TYPE test histogram
+test_bucket{le="1"} 2
+test_bucket{le="2"} 2
+test_bucket{le="4"} 3
+test_bucket{le="+Inf"} 4
+test_sum 9.5
+test_count 4
+`, sb.String())
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics()
+	merkleMiddleware := NewMerkleMiddleware(WithAllowedDepthRange(10, 12), WithMetrics(metrics),
+		WithSessionKeys(SessionKey{ID: "k1", Secret: []byte("secret")}), WithSessionMinDepth(11))
+	r := gin.New()
+	r.GET("/ping", merkleMiddleware.Handler(), func(c *gin.Context) {
+		c.String(200, "pong")
+	})
+	r.GET("/metrics", gin.WrapH(metrics))
+	send := func(setup func(req *http.Request)) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/ping", nil)
+		setup(req)
+		r.ServeHTTP(w, req)
+		return w
+	}
+	withProof := func(depth int) func(req *http.Request) {
+		return func(req *http.Request) {
+			headerPayload, err := GenerateMerkleHeader(depth, 3, "md5")
+			require.NoError(t, err)
+			req.Header.Set(MerkleHeaderName, headerPayload)
+		}
+	}
+
+	w := send(withProof(11))
+	require.Equal(t, 200, w.Code)
+	sessionToken := w.Header().Get(SessionHeaderName)
+	require.Equal(t, 200, send(withProof(12)).Code)
+	require.Equal(t, 200, send(withProof(12)).Code)
+	require.Equal(t, 200, send(func(req *http.Request) {
+		req.Header.Set(SessionHeaderName, sessionToken)
+	}).Code)
+	require.Equal(t, 406, send(withProof(9)).Code)
+	require.Equal(t, 406, send(func(*http.Request) {}).Code)
+	require.Equal(t, 406, send(func(req *http.Request) {
+		req.Header.Set(MerkleHeaderName, "junk")
+	}).Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, 200, w.Code)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", w.Header().Get("Content-Type"))
+	body := w.Body.String()
+	for _, line := range []string{
+		`merkle_proofs_accepted_total{depth="11"} 1`,
+		`merkle_proofs_accepted_total{depth="12"} 2`,
+		`merkle_sessions_accepted_total 1`,
+		`merkle_rejections_total{reason="too_easy"} 1`,
+		`merkle_rejections_total{reason="missing_header"} 1`,
+		`merkle_rejections_total{reason="malformed_header"} 1`,
+		`merkle_rejections_total{reason="replayed"} 0`,
+		`merkle_verification_duration_seconds_count 7`,
+		// requests with a session token or without a header have no header size
+		`merkle_header_size_bytes_count 5`,
+		`merkle_replay_store_tokens{store="0"} 3`,
+		`merkle_replay_store_capacity{store="0"} 100000`,
+		`merkle_replay_store_saturations_total{store="0"} 0`,
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+
+	t.Run("Every_sample_has_type", func(t *testing.T) {
+		types := make(map[string]bool)
+		for _, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+			if fields := strings.Fields(line); fields[0] == "#" {
+				if fields[1] == "TYPE" {
+					types[fields[2]] = true
+				}
+				continue
+			}
+			name, _, _ := strings.Cut(strings.Fields(line)[0], "{")
+			for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+				if base, found := strings.CutSuffix(name, suffix); found && types[base] {
+					name = base
+				}
+			}
+			assert.True(t, types[name], line)
+		}
+	})
+
+	t.Run("Replay_stores_are_labelled", func(t *testing.T) {
+		metrics := NewMetrics()
+		shared := NewBucketReplayStore(10)
+		NewMerkleMiddleware(WithMetrics(metrics), WithReplayStore(shared))
+		NewMerkleMiddleware(WithMetrics(metrics), WithReplayStore(shared))
+		NewMerkleMiddleware(WithMetrics(metrics), WithReplayStore(NewRedisReplayStore("localhost:0")))
+		NewMerkleMiddleware(WithMetrics(metrics), WithReplayStore(NewGCacheReplayStore(20)))
+		var sb strings.Builder
+		metrics.WriteText(&sb)
+		body := sb.String()
+
+		// a shared store is reported once and a redis store has no gauges, but it still takes a number
+		assert.Contains(t, body, `merkle_replay_store_capacity{store="0"} 10`+"\n")
+		assert.NotContains(t, body, `store="1"`)
+		assert.Contains(t, body, `merkle_replay_store_tokens{store="2"} 0`+"\n")
+		assert.NotContains(t, body, `merkle_replay_store_capacity{store="2"}`)
+		assert.Equal(t, 1, strings.Count(body, "# TYPE merkle_replay_store_tokens gauge\n"))
+	})
+
+	t.Run("Custom_sink", func(t *testing.T) {
+		var verifications []Verification
+		sink := metricsSinkFunc(func(verification Verification) {
+			verifications = append(verifications, verification)
+		})
+		handler := GetHTTPMiddleware(WithAllowedDepthRange(10, 12), WithMetrics(sink))(http.NotFoundHandler())
+		req := httptest.NewRequest("GET", "/ping", nil)
+		withProof(11)(req)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		require.Len(t, verifications, 2)
+		assert.True(t, verifications[0].Accepted())
+		assert.Equal(t, 11, verifications[0].Depth)
+		assert.Equal(t, len(req.Header.Get(MerkleHeaderName)), verifications[0].HeaderSize)
+		assert.Greater(t, verifications[0].Latency, time.Duration(0))
+		assert.Equal(t, ErrorCodeReplayed, verifications[1].Code)
+		assert.Zero(t, verifications[1].Depth)
+	})
+}
+
+type metricsSinkFunc func(verification Verification)
+
+func (rcv metricsSinkFunc) ObserveVerification(verification Verification) {
+	rcv(verification)
+}
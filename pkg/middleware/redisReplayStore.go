@@ -0,0 +1,247 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisError is an error reply of a server
+type redisError string
+
+func (rcv redisError) Error() string {
+	return "redis: " + string(rcv)
+}
+
+// redisConn is a connection that speaks RESP, a protocol of Redis and compatible servers
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+// do sends a command and reads its reply: a string, an int64, nil or a redisError
+func (rcv *redisConn) do(args ...string) (any, error) {
+	fmt.Fprintf(rcv.writer, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(rcv.writer, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if err := rcv.writer.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to send redis command: %w", err)
+	}
+	return rcv.readReply()
+}
+
+func (rcv *redisConn) readLine() (string, error) {
+	line, err := rcv.reader.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("failed to read redis reply: %w", err)
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		return "", fmt.Errorf("redis reply line is expected to end with CRLF")
+	}
+	return line[:len(line)-2], nil
+}
+
+func (rcv *redisConn) readReply() (any, error) {
+	line, err := rcv.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, fmt.Errorf("empty redis reply")
+	}
+	switch payload := line[1:]; line[0] {
+	case '+':
+		return payload, nil
+	case '-':
+		return redisError(payload), nil
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '_':
+		// a null of RESP3
+		return nil, nil
+	case '$':
+		size, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse redis bulk string size: %w", err)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(rcv.reader, data); err != nil {
+			return nil, fmt.Errorf("failed to read redis bulk string: %w", err)
+		}
+		return string(data[:size]), nil
+	default:
+		return nil, fmt.Errorf("unsupported redis reply type %q", line[0])
+	}
+}
+
+// namespaces of keys, so a session ID can never collide with a used token
+const (
+	redisReplayNamespace  = "replay:"
+	redisSessionNamespace = "session:"
+)
+
+// sessionUseScript increments a counter and sets its expiration on the first use in one atomic step
+const sessionUseScript = `local uses = redis.call("INCR", KEYS[1])
+if uses == 1 then redis.call("PEXPIRE", KEYS[1], ARGV[1]) end
+return uses`
+
+// RedisReplayStore is a ReplayStore shared by several servers through a Redis-compatible server.
+// Every token is stored with SET NX PX, so a check and a set are a single atomic command.
+// It is a SessionStore as well, a counter of a session is incremented by a Lua script
+type RedisReplayStore struct {
+	address string
+	cfg     redisConfig
+	idle    chan *redisConn
+}
+
+// confirm interfaces' implementation
+var (
+	_ ReplayStore  = (*RedisReplayStore)(nil)
+	_ SessionStore = (*RedisReplayStore)(nil)
+)
+
+// NewRedisReplayStore creates a store of a server with a given address, connections are opened lazily
+func NewRedisReplayStore(address string, opts ...RedisOption) *RedisReplayStore {
+	cfg := newRedisConfigFromOptions(opts...)
+	return &RedisReplayStore{
+		address: address,
+		cfg:     cfg,
+		idle:    make(chan *redisConn, cfg.poolSize),
+	}
+}
+
+// MarkUsed implements ReplayStore interface
+func (rcv *RedisReplayStore) MarkUsed(ctx context.Context, token string, ttl time.Duration) (bool, error) {
+	reply, err := rcv.do(ctx, "SET", rcv.cfg.keyPrefix+redisReplayNamespace+token, "1", "PX", formatMillis(ttl), "NX")
+	if err != nil {
+		return false, err
+	}
+
+	switch reply := reply.(type) {
+	case nil:
+		// a key already exists
+		return false, nil
+	case string:
+		return reply == "OK", nil
+	case redisError:
+		return false, reply
+	default:
+		return false, fmt.Errorf("unexpected redis reply %v", reply)
+	}
+}
+
+// Use implements SessionStore interface
+func (rcv *RedisReplayStore) Use(ctx context.Context, id string, ttl time.Duration) (int64, error) {
+	reply, err := rcv.do(ctx, "EVAL", sessionUseScript, "1", rcv.cfg.keyPrefix+redisSessionNamespace+id, formatMillis(ttl))
+	if err != nil {
+		return 0, err
+	}
+
+	switch reply := reply.(type) {
+	case int64:
+		return reply, nil
+	case redisError:
+		return 0, reply
+	default:
+		return 0, fmt.Errorf("unexpected redis reply %v", reply)
+	}
+}
+
+func formatMillis(d time.Duration) string {
+	return strconv.FormatInt(max(d.Milliseconds(), 1), 10)
+}
+
+// do executes a command with a pooled connection within a context's deadline and a timeout
+func (rcv *RedisReplayStore) do(ctx context.Context, args ...string) (any, error) {
+	conn, err := rcv.getConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok || time.Until(deadline) > rcv.cfg.timeout {
+		deadline = time.Now().Add(rcv.cfg.timeout)
+	}
+	if err := conn.conn.SetDeadline(deadline); err != nil {
+		conn.conn.Close()
+		return nil, fmt.Errorf("failed to set redis deadline: %w", err)
+	}
+
+	reply, err := conn.do(args...)
+	if err != nil {
+		// a connection is in an unknown state after a failure
+		conn.conn.Close()
+		return nil, err
+	}
+	rcv.putConn(conn)
+	return reply, nil
+}
+
+// Close closes idle connections
+func (rcv *RedisReplayStore) Close() error {
+	for {
+		select {
+		case conn := <-rcv.idle:
+			conn.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (rcv *RedisReplayStore) getConn(ctx context.Context) (*redisConn, error) {
+	select {
+	case conn := <-rcv.idle:
+		return conn, nil
+	default:
+	}
+
+	dialer := net.Dialer{Timeout: rcv.cfg.timeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", rcv.address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to redis at %q: %w", rcv.address, err)
+	}
+	conn := &redisConn{conn: netConn, reader: bufio.NewReader(netConn), writer: bufio.NewWriter(netConn)}
+	if err := netConn.SetDeadline(time.Now().Add(rcv.cfg.timeout)); err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("failed to set redis deadline: %w", err)
+	}
+
+	var handshake [][]string
+	if rcv.cfg.password != "" {
+		handshake = append(handshake, []string{"AUTH", rcv.cfg.password})
+	}
+	if rcv.cfg.database != 0 {
+		handshake = append(handshake, []string{"SELECT", strconv.Itoa(rcv.cfg.database)})
+	}
+	for _, command := range handshake {
+		reply, err := conn.do(command...)
+		if err == nil {
+			if replyErr, ok := reply.(redisError); ok {
+				err = replyErr
+			}
+		}
+		if err != nil {
+			netConn.Close()
+			return nil, fmt.Errorf("failed to execute redis %s: %w", command[0], err)
+		}
+	}
+	return conn, nil
+}
+
+func (rcv *RedisReplayStore) putConn(conn *redisConn) {
+	select {
+	case rcv.idle <- conn:
+	default:
+		conn.conn.Close()
+	}
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"time"
+)
+
+type redisConfig struct {
+	password  string
+	database  int
+	keyPrefix string
+	poolSize  int
+	timeout   time.Duration
+}
+
+func newRedisConfigFromOptions(opts ...RedisOption) redisConfig {
+	// default values
+	cfg := redisConfig{
+		keyPrefix: "merkle:",
+		poolSize:  16,
+		timeout:   time.Second,
+	}
+
+	// overrides
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return cfg
+}
+
+// RedisOption allows to customize RedisReplayStore
+type RedisOption func(cfg *redisConfig)
+
+// WithRedisPassword allows to authenticate connections with AUTH command
+func WithRedisPassword(password string) RedisOption {
+	return func(cfg *redisConfig) {
+		cfg.password = password
+	}
+}
+
+// WithRedisDatabase allows to select a database other than 0
+func WithRedisDatabase(database int) RedisOption {
+	return func(cfg *redisConfig) {
+		cfg.database = database
+	}
+}
+
+// WithRedisKeyPrefix allows to specify a prefix of keys, "merkle:" by default.
+// Used tokens are stored under "<prefix>replay:" and session counters under "<prefix>session:"
+func WithRedisKeyPrefix(prefix string) RedisOption {
+	return func(cfg *redisConfig) {
+		cfg.keyPrefix = prefix
+	}
+}
+
+// WithRedisPoolSize allows to specify a number of idle connections kept open
+func WithRedisPoolSize(size int) RedisOption {
+	return func(cfg *redisConfig) {
+		cfg.poolSize = size
+	}
+}
+
+// WithRedisTimeout allows to specify a timeout of a dial and of a command
+// when a context of a request has no earlier deadline
+func WithRedisTimeout(d time.Duration) RedisOption {
+	return func(cfg *redisConfig) {
+		cfg.timeout = d
+	}
+}
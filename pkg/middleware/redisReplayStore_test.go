@@ -0,0 +1,249 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedis is an in-process server that supports a subset of RESP commands used by RedisReplayStore
+type fakeRedis struct {
+	listener net.Listener
+	password string
+	mu       sync.Mutex
+	keys     map[string]time.Time
+	counters map[string]int64
+	commands []string
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &fakeRedis{listener: listener, password: password, keys: make(map[string]time.Time),
+		counters: make(map[string]int64)}
+	go server.serve()
+	t.Cleanup(func() { listener.Close() })
+	return server
+}
+
+func (rcv *fakeRedis) address() string {
+	return rcv.listener.Addr().String()
+}
+
+func (rcv *fakeRedis) serve() {
+	for {
+		conn, err := rcv.listener.Accept()
+		if err != nil {
+			return
+		}
+		go rcv.handle(conn)
+	}
+}
+
+func (rcv *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	authenticated := rcv.password == ""
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		rcv.mu.Lock()
+		rcv.commands = append(rcv.commands, args[0])
+		rcv.mu.Unlock()
+
+		var reply string
+		switch {
+		case args[0] == "AUTH":
+			authenticated = len(args) == 2 && args[1] == rcv.password
+			reply = "+OK\r\n"
+			if !authenticated {
+				reply = "-WRONGPASS invalid password\r\n"
+			}
+		case !authenticated:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case args[0] == "SELECT":
+			reply = "+OK\r\n"
+		case args[0] == "SET" && len(args) == 6 && args[3] == "PX" && args[5] == "NX":
+			reply = rcv.setIfAbsent(args[1], args[4])
+		case args[0] == "EVAL" && len(args) == 5 && args[1] == sessionUseScript && args[2] == "1":
+			reply = rcv.increment(args[3], args[4])
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+func (rcv *fakeRedis) setIfAbsent(key, ttlMillis string) string {
+	millis, err := strconv.Atoi(ttlMillis)
+	if err != nil {
+		return "-ERR value is not an integer\r\n"
+	}
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	now := time.Now()
+	if expiresAt, ok := rcv.keys[key]; ok && now.Before(expiresAt) {
+		return "$-1\r\n"
+	}
+	rcv.keys[key] = now.Add(time.Duration(millis) * time.Millisecond)
+	return "+OK\r\n"
+}
+
+// increment follows sessionUseScript
+func (rcv *fakeRedis) increment(key, ttlMillis string) string {
+	millis, err := strconv.Atoi(ttlMillis)
+	if err != nil {
+		return "-ERR value is not an integer\r\n"
+	}
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	now := time.Now()
+	if expiresAt, ok := rcv.keys[key]; !ok || !now.Before(expiresAt) {
+		rcv.keys[key] = now.Add(time.Duration(millis) * time.Millisecond)
+		rcv.counters[key] = 0
+	}
+	rcv.counters[key]++
+	return fmt.Sprintf(":%d\r\n", rcv.counters[key])
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	argsNum, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(line, "*"), "\r\n"))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, argsNum)
+	for i := range args {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(line, "$"), "\r\n"))
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+func TestRedisReplayStore(t *testing.T) {
+	t.Run("Contract", func(t *testing.T) {
+		server := newFakeRedis(t, "")
+		store := NewRedisReplayStore(server.address(), WithRedisPoolSize(4))
+		defer store.Close()
+		testReplayStore(t, store)
+		testSessionStore(t, store)
+	})
+
+	t.Run("Handshake", func(t *testing.T) {
+		server := newFakeRedis(t, "secret")
+		store := NewRedisReplayStore(server.address(), WithRedisPassword("secret"), WithRedisDatabase(2),
+			WithRedisKeyPrefix("test:"))
+		defer store.Close()
+		fresh, err := store.MarkUsed(context.Background(), "token", time.Minute)
+		require.NoError(t, err)
+		assert.True(t, fresh)
+		// an idle connection is reused
+		_, err = store.MarkUsed(context.Background(), "other token", time.Minute)
+		require.NoError(t, err)
+		server.mu.Lock()
+		assert.Equal(t, []string{"AUTH", "SELECT", "SET", "SET"}, server.commands)
+		assert.Contains(t, server.keys, "test:replay:token")
+		server.mu.Unlock()
+
+		_, err = NewRedisReplayStore(server.address(), WithRedisPassword("wrong")).
+			MarkUsed(context.Background(), "token", time.Minute)
+		assert.ErrorContains(t, err, "WRONGPASS")
+	})
+
+	t.Run("Key_namespaces", func(t *testing.T) {
+		server := newFakeRedis(t, "")
+		store := NewRedisReplayStore(server.address())
+		defer store.Close()
+		fresh, err := store.MarkUsed(context.Background(), "id", time.Minute)
+		require.NoError(t, err)
+		assert.True(t, fresh)
+		// a session with the same ID neither sees a used token nor burns it
+		uses, err := store.Use(context.Background(), "id", time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), uses)
+		server.mu.Lock()
+		assert.Len(t, server.keys, 2)
+		assert.Contains(t, server.keys, "merkle:replay:id")
+		assert.Contains(t, server.keys, "merkle:session:id")
+		server.mu.Unlock()
+	})
+
+	t.Run("Unavailable_server", func(t *testing.T) {
+		server := newFakeRedis(t, "")
+		server.listener.Close()
+		_, err := NewRedisReplayStore(server.address(), WithRedisTimeout(100*time.Millisecond)).
+			MarkUsed(context.Background(), "token", time.Minute)
+		assert.Error(t, err)
+	})
+}
+
+func TestMerkleSharedReplayStore(t *testing.T) {
+	server := newFakeRedis(t, "")
+	store := NewRedisReplayStore(server.address())
+	defer store.Close()
+	newReplica := func(opts ...Option) *gin.Engine {
+		r := gin.New()
+		r.GET("/ping", GetMerkleMiddleware(opts...), func(c *gin.Context) {
+			c.String(200, "pong")
+		})
+		return r
+	}
+	ping := func(r *gin.Engine, headerPayload string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/ping", nil)
+		req.Header.Set(MerkleHeaderName, headerPayload)
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	headerPayload, err := GenerateMerkleHeader(12, 3, "md5")
+	require.NoError(t, err)
+	replicas := []*gin.Engine{newReplica(WithReplayStore(store)), newReplica(WithReplayStore(store))}
+	assert.Equal(t, 200, ping(replicas[0], headerPayload))
+	assert.Equal(t, 406, ping(replicas[1], headerPayload), "a proof of work is accepted by one replica only")
+
+	// a failure of a store is not a client's fault
+	server.listener.Close()
+	store.Close()
+	headerPayload, err = GenerateMerkleHeader(12, 3, "md5")
+	require.NoError(t, err)
+	assert.Equal(t, 503, ping(replicas[0], headerPayload))
+
+	// replicas with own stores are unaware of each other
+	headerPayload, err = GenerateMerkleHeader(12, 3, "md5")
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, 200, ping(newReplica(), headerPayload), fmt.Sprintf("replica %d", i))
+	}
+}
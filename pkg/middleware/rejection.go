@@ -0,0 +1,158 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/evilaffliction/merkle/pkg/rest"
+)
+
+// ErrorCode is a machine-readable reason why a proof of work was rejected
+type ErrorCode string
+
+const (
+	// ErrorCodeMissingHeader means that a request has no merkle header
+	ErrorCodeMissingHeader ErrorCode = "missing_header"
+	// ErrorCodeMalformedHeader means that a merkle header is too large or can not be parsed
+	ErrorCodeMalformedHeader ErrorCode = "malformed_header"
+	// ErrorCodeOutdatedVersion means that a proof of work format is older than allowed
+	ErrorCodeOutdatedVersion ErrorCode = "outdated_version"
+	// ErrorCodeUnsupportedHash means that a proof of work is built with a hash function that is not allowed
+	ErrorCodeUnsupportedHash ErrorCode = "unsupported_hash"
+	// ErrorCodeReplayed means that an access token or a challenge was already used
+	ErrorCodeReplayed ErrorCode = "replayed"
+	// ErrorCodeTooEasy means that a merkle tree is smaller than required, see RequirementsHeaderName
+	ErrorCodeTooEasy ErrorCode = "too_easy"
+	// ErrorCodeTooHard means that a merkle tree is larger than allowed, see RequirementsHeaderName
+	ErrorCodeTooHard ErrorCode = "too_hard"
+	// ErrorCodeExpired means that an access token, a challenge or a session token is too old
+	ErrorCodeExpired ErrorCode = "expired"
+	// ErrorCodeInvalidAccessToken means that a client-chosen access token is malformed or dated in future
+	ErrorCodeInvalidAccessToken ErrorCode = "invalid_access_token"
+	// ErrorCodeInvalidChallenge means that a challenge was not issued by a server
+	// or a merkle tree does not match its difficulty
+	ErrorCodeInvalidChallenge ErrorCode = "invalid_challenge"
+	// ErrorCodeChallengeRequired means that a proof of work has to be built for a server-issued challenge
+	ErrorCodeChallengeRequired ErrorCode = "challenge_required"
+	// ErrorCodeBindingMismatch means that a proof of work is not bound to a request it is sent with,
+	// see RequestBinding
+	ErrorCodeBindingMismatch ErrorCode = "binding_mismatch"
+	// ErrorCodeInvalidSession means that a session token was not issued by a server
+	ErrorCodeInvalidSession ErrorCode = "invalid_session"
+	// ErrorCodeSessionExhausted means that all the uses of a session token are spent
+	ErrorCodeSessionExhausted ErrorCode = "session_exhausted"
+	// ErrorCodeInvalidProof means that a proof of work does not prove anything
+	ErrorCodeInvalidProof ErrorCode = "invalid_proof"
+	// ErrorCodeSaturated means that a server remembers too many used tokens to accept one more
+	ErrorCodeSaturated ErrorCode = "saturated"
+	// ErrorCodeInternal means that a server failed to verify a proof of work
+	ErrorCodeInternal ErrorCode = "internal_error"
+)
+
+// errorTitles are short human-readable summaries of error codes, they never change from occurrence to occurrence
+var errorTitles = map[ErrorCode]string{
+	ErrorCodeMissingHeader:      "Proof of work is missing",
+	ErrorCodeMalformedHeader:    "Proof of work is malformed",
+	ErrorCodeOutdatedVersion:    "Proof of work version is outdated",
+	ErrorCodeUnsupportedHash:    "Proof of work hash function is not allowed",
+	ErrorCodeReplayed:           "Proof of work was already used",
+	ErrorCodeTooEasy:            "Proof of work is too easy",
+	ErrorCodeTooHard:            "Proof of work is too hard",
+	ErrorCodeExpired:            "Proof of work is expired",
+	ErrorCodeInvalidAccessToken: "Access token is invalid",
+	ErrorCodeInvalidChallenge:   "Challenge is invalid",
+	ErrorCodeChallengeRequired:  "Server-issued challenge is required",
+	ErrorCodeBindingMismatch:    "Proof of work is bound to another request",
+	ErrorCodeInvalidSession:     "Session token is invalid",
+	ErrorCodeSessionExhausted:   "Session token is exhausted",
+	ErrorCodeInvalidProof:       "Proof of work is invalid",
+	ErrorCodeSaturated:          "Server is saturated",
+	ErrorCodeInternal:           "Proof of work can not be verified",
+}
+
+// VerificationError is a rejection of a proof of work with a machine-readable code
+type VerificationError struct {
+	Code ErrorCode
+	Err  error
+}
+
+func newVerificationError(code ErrorCode, format string, args ...any) error {
+	return &VerificationError{Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+// Error implements error interface
+func (rcv *VerificationError) Error() string {
+	return fmt.Sprintf("%s: %v", rcv.Code, rcv.Err)
+}
+
+// Unwrap returns an underlying error
+func (rcv *VerificationError) Unwrap() error {
+	return rcv.Err
+}
+
+// ErrorCodeOf returns a code of a VerificationError, any other error is considered internal
+func ErrorCodeOf(err error) ErrorCode {
+	var verificationErr *VerificationError
+	if errors.As(err, &verificationErr) {
+		return verificationErr.Code
+	}
+	return ErrorCodeInternal
+}
+
+// Problem is a body of a rejection, it follows a problem details format of RFC 9457
+// with a code and current requirements as extension members
+type Problem struct {
+	Type         string       `json:"type"`
+	Title        string       `json:"title"`
+	Status       int          `json:"status"`
+	Detail       string       `json:"detail,omitempty"`
+	Code         ErrorCode    `json:"code"`
+	Requirements Requirements `json:"requirements"`
+}
+
+// newProblem describes a rejection, a detailed reason is included only in a debug mode
+func newProblem(err error, requirements Requirements, debugErrors bool) Problem {
+	code := ErrorCodeOf(err)
+	problem := Problem{
+		Type:         "urn:merkle:error:" + string(code),
+		Title:        errorTitles[code],
+		Status:       http.StatusNotAcceptable,
+		Code:         code,
+		Requirements: requirements,
+	}
+	if code == ErrorCodeInternal || code == ErrorCodeSaturated {
+		problem.Status = http.StatusServiceUnavailable
+	}
+	if debugErrors {
+		problem.Detail = err.Error()
+	}
+	return problem
+}
+
+// reject writes a problem and hints for a client: current requirements and, when a client may
+// get easier requirements or a server may recover, how long it is worth to wait
+func reject(w http.ResponseWriter, err error, requirements Requirements, retryAfter time.Duration, debugErrors bool) {
+	problem := newProblem(err, requirements, debugErrors)
+	w.Header().Set(RequirementsHeaderName, requirements.String())
+	if retryAfter = rejectionRetryAfter(problem.Code, retryAfter); retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	}
+	rest.ProblemResponse(w, problem.Status, problem)
+}
+
+// rejectionRetryAfter returns how long it is worth to wait before a retry of a rejected request, if at all
+func rejectionRetryAfter(code ErrorCode, retryAfter time.Duration) time.Duration {
+	switch code {
+	case ErrorCodeTooEasy:
+		// nothing to wait for when requirements are not raised
+		return retryAfter
+	case ErrorCodeInternal, ErrorCodeSaturated:
+		return max(retryAfter, time.Second)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/evilaffliction/merkle/pkg/rest"
+)
+
+func TestMerkleRejections(t *testing.T) {
+	newEngine := func(opts ...Option) *gin.Engine {
+		opts = append([]Option{WithAllowedDepthRange(12, 16)}, opts...)
+		r := gin.New()
+		r.GET("/ping", GetMerkleMiddleware(opts...), func(c *gin.Context) {
+			c.String(200, "pong")
+		})
+		return r
+	}
+	ping := func(r *gin.Engine, headerPayload string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/ping", nil)
+		if headerPayload != "" {
+			req.Header.Set(MerkleHeaderName, headerPayload)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+	readProblem := func(t *testing.T, w *httptest.ResponseRecorder) Problem {
+		assert.Equal(t, "application/problem+json; charset=utf-8", w.Header().Get("Content-Type"))
+		var problem Problem
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+		assert.Equal(t, w.Code, problem.Status)
+		assert.Equal(t, "urn:merkle:error:"+string(problem.Code), problem.Type)
+		assert.NotEmpty(t, problem.Title)
+		return problem
+	}
+	header := func(depth int, opts ...HeaderOption) string {
+		headerPayload, err := GenerateMerkleHeader(depth, 3, "md5", opts...)
+		require.NoError(t, err)
+		return headerPayload
+	}
+
+	t.Run("Codes_tell_reasons_apart", func(t *testing.T) {
+		r := newEngine()
+		replayed := header(12)
+		require.Equal(t, 200, ping(r, replayed).Code)
+		for _, testCase := range []struct {
+			headerPayload string
+			code          ErrorCode
+		}{
+			{"", ErrorCodeMissingHeader},
+			{"{", ErrorCodeMalformedHeader},
+			{header(11), ErrorCodeTooEasy},
+			{header(17), ErrorCodeTooHard},
+			{replayed, ErrorCodeReplayed},
+		} {
+			w := ping(r, testCase.headerPayload)
+			assert.Equal(t, 406, w.Code)
+			problem := readProblem(t, w)
+			assert.Equal(t, testCase.code, problem.Code)
+			assert.Empty(t, problem.Detail, "details are hidden by default")
+			assert.Empty(t, w.Header().Get("Retry-After"))
+
+			requirements, err := ParseRequirements(w.Header().Get(RequirementsHeaderName))
+			require.NoError(t, err)
+			assert.Equal(t, problem.Requirements, requirements)
+			assert.Equal(t, 12, requirements.MinDepth)
+			assert.Equal(t, 16, requirements.MaxDepth)
+			assert.Contains(t, requirements.Hashes, "md5")
+		}
+	})
+
+	t.Run("Expired_access_token", func(t *testing.T) {
+		r := newEngine(WithAccessTokenLifeTime(-time.Second))
+		assert.Equal(t, ErrorCodeExpired, readProblem(t, ping(r, header(12))).Code)
+	})
+
+	t.Run("Required_challenge", func(t *testing.T) {
+		r := newEngine(WithChallengeSecret([]byte("secret")), WithRequiredChallenge())
+		w := ping(r, header(12))
+		assert.Equal(t, ErrorCodeChallengeRequired, readProblem(t, w).Code)
+		requirements, err := ParseRequirements(w.Header().Get(RequirementsHeaderName))
+		require.NoError(t, err)
+		assert.True(t, requirements.ChallengeRequired)
+	})
+
+	t.Run("Debug_mode_discloses_details", func(t *testing.T) {
+		w := ping(newEngine(WithDebugErrors()), header(11))
+		problem := readProblem(t, w)
+		assert.Equal(t, ErrorCodeTooEasy, problem.Code)
+		assert.Contains(t, problem.Detail, "prover depth 11 is too small")
+
+		err := rest.ReadResponse(w.Result(), nil)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), string(ErrorCodeTooEasy))
+		assert.Contains(t, err.Error(), "prover depth 11 is too small")
+	})
+
+	t.Run("Raised_requirements_come_with_retry_after", func(t *testing.T) {
+		clock := newFakeClock()
+		reputation := NewClientReputation(WithReputationCosts(1, 0), WithReputationLevels(2, 1, 0, 10),
+			WithReputationHalfLife(time.Minute), WithReputationClock(clock))
+		r := newEngine(WithClientReputation(reputation))
+		require.Equal(t, 200, ping(r, header(12)).Code)
+		require.Equal(t, 200, ping(r, header(12)).Code)
+
+		w := ping(r, header(12))
+		assert.Equal(t, ErrorCodeTooEasy, readProblem(t, w).Code)
+		// a score of 3 decays below 2 in log2(3/2) half-lives, i.e. in 35.1 seconds rounded up
+		assert.Equal(t, "36", w.Header().Get("Retry-After"))
+		requirements, err := ParseRequirements(w.Header().Get(RequirementsHeaderName))
+		require.NoError(t, err)
+		assert.Equal(t, 13, requirements.MinDepth)
+	})
+}
+
+func TestMerkleSaturatedReplayStore(t *testing.T) {
+	r := gin.New()
+	r.GET("/ping", GetMerkleMiddleware(WithAccessTokenCacheSize(1)), func(c *gin.Context) {
+		c.String(200, "pong")
+	})
+	codes := make([]int, 0, 2)
+	for i := 0; i < 2; i++ {
+		headerPayload, err := GenerateMerkleHeader(12, 3, "md5")
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/ping", nil)
+		req.Header.Set(MerkleHeaderName, headerPayload)
+		r.ServeHTTP(w, req)
+		codes = append(codes, w.Code)
+		if w.Code != 200 {
+			var problem Problem
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+			assert.Equal(t, ErrorCodeSaturated, problem.Code)
+			assert.Equal(t, "1", w.Header().Get("Retry-After"))
+		}
+	}
+	assert.Equal(t, []int{200, 503}, codes)
+}
+
+func TestProblem(t *testing.T) {
+	err := newVerificationError(ErrorCodeReplayed, "access token %s was already used", "42")
+	assert.Equal(t, ErrorCodeReplayed, ErrorCodeOf(fmt.Errorf("wrapped: %w", err)))
+	assert.Equal(t, ErrorCodeInternal, ErrorCodeOf(fmt.Errorf("cache is broken")))
+
+	problem := newProblem(fmt.Errorf("cache is broken"), Requirements{}, false)
+	assert.Equal(t, 503, problem.Status)
+	assert.Equal(t, ErrorCodeInternal, problem.Code)
+	assert.Empty(t, problem.Detail)
+
+	problem = newProblem(err, Requirements{}, true)
+	assert.Equal(t, 406, problem.Status)
+	assert.Equal(t, "replayed: access token 42 was already used", problem.Detail)
+}
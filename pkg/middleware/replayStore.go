@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bluele/gcache"
+)
+
+// ReplayStore remembers used access tokens and challenges, so that a proof of work is accepted only once.
+// Several servers behind a load balancer should share a store, e.g. a RedisReplayStore
+type ReplayStore interface {
+	// MarkUsed remembers a token for at least "ttl" and reports whether the token was fresh.
+	// It has to be atomic: out of concurrent calls with the same token only one may return true
+	MarkUsed(ctx context.Context, token string, ttl time.Duration) (bool, error)
+}
+
+// GCacheReplayStore is an in-process ReplayStore, tokens are forgotten either when they expire
+// or when the store is full and they are the least recently used ones
+type GCacheReplayStore struct {
+	mu    sync.Mutex
+	cache gcache.Cache
+}
+
+// confirm interfaces' implementation
+var _ ReplayStore = (*GCacheReplayStore)(nil)
+
+// NewGCacheReplayStore creates a GCacheReplayStore of a given size
+func NewGCacheReplayStore(size int) *GCacheReplayStore {
+	return &GCacheReplayStore{
+		cache: gcache.New(size).LRU().Build(),
+	}
+}
+
+// MarkUsed implements ReplayStore interface
+func (rcv *GCacheReplayStore) MarkUsed(_ context.Context, token string, ttl time.Duration) (bool, error) {
+	// a lock makes a check and a set to be atomic
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	_, err := rcv.cache.Get(token)
+	switch {
+	case errors.Is(err, gcache.KeyNotFoundError):
+		// all is good, a token is fresh
+	case err != nil:
+		return false, fmt.Errorf("failed to verify token in cache history, error: %w", err)
+	default:
+		return false, nil
+	}
+
+	if err := rcv.cache.SetWithExpire(token, struct{}{}, ttl); err != nil {
+		return false, fmt.Errorf("failed to set cache, error: %w", err)
+	}
+	return true, nil
+}
+
+// Len returns a number of remembered tokens that are not expired yet
+func (rcv *GCacheReplayStore) Len() int {
+	return rcv.cache.Len(true)
+}
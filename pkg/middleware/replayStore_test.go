@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testReplayStore checks a contract of ReplayStore
+func testReplayStore(t *testing.T, store ReplayStore) {
+	ctx := context.Background()
+
+	fresh, err := store.MarkUsed(ctx, "token", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, fresh)
+	fresh, err = store.MarkUsed(ctx, "token", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, fresh)
+
+	fresh, err = store.MarkUsed(ctx, "short-lived", 50*time.Millisecond)
+	require.NoError(t, err)
+	assert.True(t, fresh)
+	time.Sleep(100 * time.Millisecond)
+	fresh, err = store.MarkUsed(ctx, "short-lived", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, fresh, "an expired token is forgotten")
+
+	// only one of concurrent requests with the same token passes
+	for i := 0; i < 10; i++ {
+		var wg sync.WaitGroup
+		var passed atomic.Int32
+		for j := 0; j < 20; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				fresh, err := store.MarkUsed(ctx, fmt.Sprintf("concurrent-%d", i), time.Minute)
+				assert.NoError(t, err)
+				if fresh {
+					passed.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(1), passed.Load())
+	}
+}
+
+func TestGCacheReplayStore(t *testing.T) {
+	testReplayStore(t, NewGCacheReplayStore(100))
+}
@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bluele/gcache"
+)
+
+// ClientKeyFunc extracts a key of a client out of a request, requests with the same key
+// share a reputation
+type ClientKeyFunc func(req *http.Request) string
+
+// ClientIP uses an IP address of a peer as a client's key
+func ClientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// ClientSubnet uses a subnet of a peer as a client's key, e.g. ClientSubnet(24, 64)
+// makes all the addresses of a /24 IPv4 subnet or a /64 IPv6 subnet to share a reputation
+func ClientSubnet(ipv4PrefixLen, ipv6PrefixLen int) ClientKeyFunc {
+	return func(req *http.Request) string {
+		host := ClientIP(req)
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return host
+		}
+		if ipv4 := ip.To4(); ipv4 != nil {
+			return (&net.IPNet{IP: ipv4.Mask(net.CIDRMask(ipv4PrefixLen, 32)), Mask: net.CIDRMask(ipv4PrefixLen, 32)}).String()
+		}
+		return (&net.IPNet{IP: ip.Mask(net.CIDRMask(ipv6PrefixLen, 128)), Mask: net.CIDRMask(ipv6PrefixLen, 128)}).String()
+	}
+}
+
+// clientScore is a reputation of a single client, the greater the worse
+type clientScore struct {
+	score     float64
+	updatedAt time.Time
+}
+
+// ClientReputation raises min acceptable depth and proof leaves num for clients that send
+// many requests or many invalid proofs of work.
+//
+// Every request adds a request cost to a client's score, every request with a malformed, forged or replayed
+// proof of work or session token adds an invalid proof cost. Requests rejected for an outdated or too easy proof
+// count as plain requests, and requests a server failed to verify are not counted at all.
+// The score halves every half-life period, and every "score per level" points raise a difficulty by a step.
+// At most "max clients" scores are kept, the least recently used ones are evicted first,
+// and scores expire after a period of inactivity when they are about to decay to zero anyway
+type ClientReputation struct {
+	mu     sync.Mutex
+	cfg    reputationConfig
+	scores gcache.Cache
+}
+
+// NewClientReputation creates a reputation tracker, see WithClientReputation
+func NewClientReputation(opts ...ReputationOption) *ClientReputation {
+	cfg := newReputationConfigFromOptions(opts...)
+	return &ClientReputation{
+		cfg: cfg,
+		scores: gcache.New(cfg.maxClients).
+			LRU().
+			Expiration(cfg.stateLifeTime).
+			Clock(cfg.clock).
+			Build(),
+	}
+}
+
+// Key returns a key of a client that sent a request
+func (rcv *ClientReputation) Key(req *http.Request) string {
+	return rcv.cfg.clientKey(req)
+}
+
+// score returns a decayed score of a client, it has to be called under the lock
+func (rcv *ClientReputation) score(key string, now time.Time) float64 {
+	value, err := rcv.scores.Get(key)
+	if err != nil {
+		// a new or a forgotten client
+		return 0
+	}
+	state := value.(clientScore)
+	elapsed := now.Sub(state.updatedAt)
+	if elapsed <= 0 {
+		return state.score
+	}
+	return state.score * math.Exp2(-float64(elapsed)/float64(rcv.cfg.halfLife))
+}
+
+// Score returns a current score of a client
+func (rcv *ClientReputation) Score(key string) float64 {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return rcv.score(key, rcv.cfg.clock.Now())
+}
+
+// Observe adds a request of a client to its score, "valid" is false for an invalid proof of work
+func (rcv *ClientReputation) Observe(key string, valid bool) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	now := rcv.cfg.clock.Now()
+	score := rcv.score(key, now) + rcv.cfg.requestCost
+	if !valid {
+		score += rcv.cfg.invalidProofCost
+	}
+	// the cache is unable to fail on a set without a serialization function
+	_ = rcv.scores.Set(key, clientScore{score: score, updatedAt: now})
+}
+
+// ExtraDifficulty returns how much min acceptable depth and proof leaves num are raised for a client
+func (rcv *ClientReputation) ExtraDifficulty(key string) (extraDepth int, extraProofLeavesNum int) {
+	level := min(int(rcv.Score(key)/rcv.cfg.scorePerLevel), rcv.cfg.maxLevel)
+	return level * rcv.cfg.depthStep, level * rcv.cfg.proofLeavesNumStep
+}
+
+// retryAfter returns how long it takes for a score of a client to decay by a level
+func (rcv *ClientReputation) retryAfter(key string) time.Duration {
+	score := rcv.Score(key)
+	level := min(int(score/rcv.cfg.scorePerLevel), rcv.cfg.maxLevel)
+	if level == 0 {
+		return 0
+	}
+	// a level is lowered as soon as a score drops below level * score per level
+	decay := math.Log2(score / (float64(level) * rcv.cfg.scorePerLevel))
+	return max(time.Duration(decay*float64(rcv.cfg.halfLife)), time.Second)
+}
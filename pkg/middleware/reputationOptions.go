@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"time"
+)
+
+type reputationConfig struct {
+	clientKey          ClientKeyFunc
+	requestCost        float64
+	invalidProofCost   float64
+	halfLife           time.Duration
+	scorePerLevel      float64
+	depthStep          int
+	proofLeavesNumStep int
+	maxLevel           int
+	maxClients         int
+	stateLifeTime      time.Duration
+	clock              Clock
+}
+
+func newReputationConfigFromOptions(opts ...ReputationOption) reputationConfig {
+	// default values
+	cfg := reputationConfig{
+		clientKey:          ClientIP,
+		requestCost:        1,
+		invalidProofCost:   10,
+		halfLife:           time.Minute,
+		scorePerLevel:      100,
+		depthStep:          1,
+		proofLeavesNumStep: 0,
+		maxLevel:           5,
+		maxClients:         100000,
+		stateLifeTime:      10 * time.Minute,
+		clock:              SystemClock{},
+	}
+
+	// overrides
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return cfg
+}
+
+// ReputationOption allows to customize ClientReputation
+type ReputationOption func(cfg *reputationConfig)
+
+// WithClientKey allows to specify how clients are distinguished, e.g. ClientIP, ClientSubnet
+// or a custom function of a request
+func WithClientKey(clientKey ClientKeyFunc) ReputationOption {
+	return func(cfg *reputationConfig) {
+		cfg.clientKey = clientKey
+	}
+}
+
+// WithReputationCosts allows to specify how much every request and every rejected request
+// add to a client's score
+func WithReputationCosts(requestCost, invalidProofCost float64) ReputationOption {
+	return func(cfg *reputationConfig) {
+		cfg.requestCost = requestCost
+		cfg.invalidProofCost = invalidProofCost
+	}
+}
+
+// WithReputationHalfLife allows to specify how fast a client's score decays
+func WithReputationHalfLife(d time.Duration) ReputationOption {
+	return func(cfg *reputationConfig) {
+		cfg.halfLife = d
+	}
+}
+
+// WithReputationLevels allows to specify a score that raises difficulty by a step,
+// steps of depth and proof leaves num and max number of steps
+func WithReputationLevels(scorePerLevel float64, depthStep, proofLeavesNumStep, maxLevel int) ReputationOption {
+	return func(cfg *reputationConfig) {
+		cfg.scorePerLevel = scorePerLevel
+		cfg.depthStep = depthStep
+		cfg.proofLeavesNumStep = proofLeavesNumStep
+		cfg.maxLevel = maxLevel
+	}
+}
+
+// WithMaxTrackedClients allows to bound memory used by reputations,
+// the least recently seen clients are forgotten first
+func WithMaxTrackedClients(maxClients int) ReputationOption {
+	return func(cfg *reputationConfig) {
+		cfg.maxClients = maxClients
+	}
+}
+
+// WithReputationStateLifeTime allows to specify how long a reputation of an inactive client is kept.
+// It should be several half-life periods, so that a forgotten score is negligible
+func WithReputationStateLifeTime(d time.Duration) ReputationOption {
+	return func(cfg *reputationConfig) {
+		cfg.stateLifeTime = d
+	}
+}
+
+// WithReputationClock allows to substitute time, e.g. with a fake clock in tests
+func WithReputationClock(clock Clock) ReputationOption {
+	return func(cfg *reputationConfig) {
+		cfg.clock = clock
+	}
+}
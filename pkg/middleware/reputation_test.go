@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientKeys(t *testing.T) {
+	for _, testCase := range []struct {
+		remoteAddr     string
+		expectedIP     string
+		expectedSubnet string
+	}{
+		{remoteAddr: "192.168.1.42:5555", expectedIP: "192.168.1.42", expectedSubnet: "192.168.1.0/24"},
+		{remoteAddr: "[2001:db8:1:2:3::4]:5555", expectedIP: "2001:db8:1:2:3::4", expectedSubnet: "2001:db8:1:2::/64"},
+		{remoteAddr: "pipe", expectedIP: "pipe", expectedSubnet: "pipe"},
+	} {
+		req := httptest.NewRequest("GET", "/ping", nil)
+		req.RemoteAddr = testCase.remoteAddr
+		assert.Equal(t, testCase.expectedIP, ClientIP(req))
+		assert.Equal(t, testCase.expectedSubnet, ClientSubnet(24, 64)(req))
+	}
+}
+
+func TestClientReputation(t *testing.T) {
+	clock := newFakeClock()
+	reputation := NewClientReputation(
+		WithReputationCosts(1, 9),
+		WithReputationHalfLife(time.Minute),
+		WithReputationLevels(10, 2, 1, 3),
+		WithMaxTrackedClients(2),
+		WithReputationStateLifeTime(time.Hour),
+		WithReputationClock(clock),
+	)
+	expectExtraDifficulty := func(key string, extraDepth, extraProofLeavesNum int) {
+		t.Helper()
+		actualExtraDepth, actualExtraProofLeavesNum := reputation.ExtraDifficulty(key)
+		assert.Equal(t, extraDepth, actualExtraDepth, key)
+		assert.Equal(t, extraProofLeavesNum, actualExtraProofLeavesNum, key)
+	}
+
+	for i := 0; i < 9; i++ {
+		reputation.Observe("polite", true)
+	}
+	assert.Equal(t, 9.0, reputation.Score("polite"))
+	expectExtraDifficulty("polite", 0, 0)
+	reputation.Observe("polite", true)
+	expectExtraDifficulty("polite", 2, 1)
+
+	// invalid proofs are more expensive
+	reputation.Observe("rude", false)
+	reputation.Observe("rude", false)
+	assert.Equal(t, 20.0, reputation.Score("rude"))
+	expectExtraDifficulty("rude", 4, 2)
+	for i := 0; i < 10; i++ {
+		reputation.Observe("rude", false)
+	}
+	expectExtraDifficulty("rude", 6, 3)
+
+	// scores decay over time
+	clock.Advance(time.Minute)
+	assert.InDelta(t, 5.0, reputation.Score("polite"), 1e-9)
+	expectExtraDifficulty("polite", 0, 0)
+
+	// the least recently used client is forgotten
+	reputation.Observe("newcomer", true)
+	assert.Equal(t, 0.0, reputation.Score("rude"))
+	assert.InDelta(t, 5.0, reputation.Score("polite"), 1e-9)
+	assert.Equal(t, 1.0, reputation.Score("newcomer"))
+
+	// inactive clients expire
+	clock.Advance(time.Hour + time.Second)
+	assert.Equal(t, 0.0, reputation.Score("polite"))
+	assert.Equal(t, 0.0, reputation.Score("newcomer"))
+}
+
+func TestMerkleClientReputation(t *testing.T) {
+	reputation := NewClientReputation(WithReputationCosts(1, 2), WithReputationLevels(3, 1, 0, 10),
+		WithReputationClock(newFakeClock()))
+	merkleMiddleware := NewMerkleMiddleware(WithAllowedDepthRange(10, 16), WithClientReputation(reputation))
+	r := gin.New()
+	r.GET("/ping", merkleMiddleware.Handler(), func(c *gin.Context) {
+		c.String(200, "pong")
+	})
+	newRequest := func(remoteAddr string, depth int) *http.Request {
+		headerPayload, err := GenerateMerkleHeader(depth, 3, "md5")
+		require.NoError(t, err)
+		req := httptest.NewRequest("GET", "/ping", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set(MerkleHeaderName, headerPayload)
+		return req
+	}
+	call := func(remoteAddr string, depth int) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, newRequest(remoteAddr, depth))
+		return w.Code
+	}
+
+	const greedy, modest = "10.0.0.1:1000", "10.0.0.2:1000"
+	assert.Equal(t, 200, call(greedy, 10))
+	assert.Equal(t, 200, call(greedy, 10))
+	assert.Equal(t, 200, call(greedy, 10))
+	// 3 requests make the greedy client to pay more
+	assert.Equal(t, 406, call(greedy, 10))
+	assert.Equal(t, 11, merkleMiddleware.Requirements(newRequest(greedy, 10)).MinDepth)
+	assert.Equal(t, 200, call(greedy, 12))
+
+	// others are not affected
+	assert.Equal(t, 10, merkleMiddleware.Requirements(newRequest(modest, 10)).MinDepth)
+	assert.Equal(t, 200, call(modest, 10))
+
+	// a route's policy is taken into account, max depth is never exceeded
+	assert.Equal(t, 12, merkleMiddleware.Requirements(newRequest(greedy, 10), WithAllowedDepthRange(11, 13)).MinDepth)
+	// ports do not matter
+	for i := 0; i < 5; i++ {
+		req := newRequest(fmt.Sprintf("10.0.0.3:%d", i), 10)
+		req.Header.Set(MerkleHeaderName, "junk")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, 406, w.Code)
+	}
+	assert.Equal(t, 15.0, reputation.Score("10.0.0.3"))
+	assert.Equal(t, 406, call("10.0.0.3:1000", 14))
+	assert.Equal(t, 200, call("10.0.0.3:1000", 16))
+
+	// too easy proofs are plain requests, otherwise raised requirements would raise themselves further
+	assert.Equal(t, 406, call("10.0.0.4:1000", 9))
+	assert.Equal(t, 406, call("10.0.0.4:1000", 9))
+	assert.Equal(t, 2.0, reputation.Score("10.0.0.4"))
+}
+
+func TestMerkleClientReputationServerFailures(t *testing.T) {
+	reputation := NewClientReputation(WithReputationCosts(1, 2), WithReputationClock(newFakeClock()))
+	merkleMiddleware := NewMerkleMiddleware(WithAllowedDepthRange(10, 16), WithClientReputation(reputation),
+		WithReplayStore(failingReplayStore{}))
+	r := gin.New()
+	r.GET("/ping", merkleMiddleware.Handler(), func(c *gin.Context) {
+		c.String(200, "pong")
+	})
+
+	headerPayload, err := GenerateMerkleHeader(10, 3, "md5")
+	require.NoError(t, err)
+	req := httptest.NewRequest("GET", "/ping", nil)
+	req.RemoteAddr = "10.0.0.1:1000"
+	req.Header.Set(MerkleHeaderName, headerPayload)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 503, w.Code)
+	// an unavailable replay store is not a client's fault
+	assert.Zero(t, reputation.Score("10.0.0.1"))
+}
+
+// failingReplayStore is an unavailable ReplayStore
+type failingReplayStore struct{}
+
+func (failingReplayStore) MarkUsed(context.Context, string, time.Duration) (bool, error) {
+	return false, errors.New("connection refused")
+}
@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RequirementsHeaderName represents a name for a header that advertises acceptable parameters
+// of a proof of work, it is sent with every rejection
+const RequirementsHeaderName = "Merkle-Requirements"
+
+// Requirements are the acceptable parameters of a proof of work for a client at the moment
+type Requirements struct {
+	MinDepth          int      `json:"min_depth"`
+	MaxDepth          int      `json:"max_depth"`
+	MinProofLeavesNum int      `json:"min_proof_leaves_num"`
+	MaxProofLeavesNum int      `json:"max_proof_leaves_num"`
+	MinWorkFactor     int      `json:"min_work_factor"`
+	MaxWorkFactor     int      `json:"max_work_factor"`
+	MinProofVersion   int      `json:"min_proof_version"`
+	Hashes            []string `json:"hashes"`
+	ChallengeRequired bool     `json:"challenge_required,omitempty"`
+	BindingRequired   bool     `json:"binding_required,omitempty"`
+	BoundHeaders      []string `json:"bound_headers,omitempty"`
+}
+
+// String formats requirements as a value of a RequirementsHeaderName header:
+//
+//	depth=12-25, proof-leaves-num=3-10, work-factor=1-16, min-version=1, hash="md5 sha256", challenge=required,
+//	binding="content-type"
+//
+// The challenge parameter is present only when a proof of work has to be built for a server-issued challenge,
+// the binding one lists bound headers and is present only when a proof of work has to be bound to a request
+func (rcv Requirements) String() string {
+	params := []string{
+		fmt.Sprintf("depth=%d-%d", rcv.MinDepth, rcv.MaxDepth),
+		fmt.Sprintf("proof-leaves-num=%d-%d", rcv.MinProofLeavesNum, rcv.MaxProofLeavesNum),
+		fmt.Sprintf("work-factor=%d-%d", rcv.MinWorkFactor, rcv.MaxWorkFactor),
+		fmt.Sprintf("min-version=%d", rcv.MinProofVersion),
+		fmt.Sprintf("hash=%q", strings.Join(rcv.Hashes, " ")),
+	}
+	if rcv.ChallengeRequired {
+		params = append(params, "challenge=required")
+	}
+	if rcv.BindingRequired {
+		params = append(params, fmt.Sprintf("binding=%q", strings.Join(rcv.BoundHeaders, " ")))
+	}
+	return strings.Join(params, ", ")
+}
+
+// ParseRequirements restores requirements from a value of a RequirementsHeaderName header,
+// unknown parameters are ignored
+func ParseRequirements(header string) (Requirements, error) {
+	var result Requirements
+	for _, param := range strings.Split(header, ",") {
+		param = strings.TrimSpace(param)
+		if param == "" {
+			continue
+		}
+		name, value, found := strings.Cut(param, "=")
+		if !found {
+			return Requirements{}, fmt.Errorf("requirement %q is expected to be a name=value pair", param)
+		}
+		var err error
+		switch name {
+		case "depth":
+			result.MinDepth, result.MaxDepth, err = parseRange(value)
+		case "proof-leaves-num":
+			result.MinProofLeavesNum, result.MaxProofLeavesNum, err = parseRange(value)
+		case "work-factor":
+			result.MinWorkFactor, result.MaxWorkFactor, err = parseRange(value)
+		case "min-version":
+			result.MinProofVersion, err = strconv.Atoi(value)
+		case "hash":
+			var hashes string
+			if hashes, err = strconv.Unquote(value); err == nil {
+				result.Hashes = strings.Fields(hashes)
+			}
+		case "challenge":
+			result.ChallengeRequired = value == "required"
+		case "binding":
+			var headers string
+			if headers, err = strconv.Unquote(value); err == nil {
+				result.BindingRequired = true
+				if fields := strings.Fields(headers); len(fields) > 0 {
+					result.BoundHeaders = fields
+				}
+			}
+		}
+		if err != nil {
+			return Requirements{}, fmt.Errorf("failed to parse requirement %q: %w", name, err)
+		}
+	}
+	return result, nil
+}
+
+func parseRange(value string) (int, int, error) {
+	minValue, maxValue, found := strings.Cut(value, "-")
+	if !found {
+		return 0, 0, fmt.Errorf("range %q is expected to be min-max", value)
+	}
+	lower, err := strconv.Atoi(minValue)
+	if err != nil {
+		return 0, 0, err
+	}
+	upper, err := strconv.Atoi(maxValue)
+	if err != nil {
+		return 0, 0, err
+	}
+	return lower, upper, nil
+}
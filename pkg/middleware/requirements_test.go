@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequirementsHeader(t *testing.T) {
+	requirements := Requirements{
+		MinDepth:          12,
+		MaxDepth:          25,
+		MinProofLeavesNum: 3,
+		MaxProofLeavesNum: 10,
+		MinWorkFactor:     1,
+		MaxWorkFactor:     16,
+		MinProofVersion:   1,
+		Hashes:            []string{"md5", "sha256"},
+	}
+	assert.Equal(t, `depth=12-25, proof-leaves-num=3-10, work-factor=1-16, min-version=1, hash="md5 sha256"`,
+		requirements.String())
+	restored, err := ParseRequirements(requirements.String())
+	require.NoError(t, err)
+	assert.Equal(t, requirements, restored)
+
+	requirements.ChallengeRequired = true
+	restored, err = ParseRequirements(requirements.String())
+	require.NoError(t, err)
+	assert.Equal(t, requirements, restored)
+
+	requirements.BindingRequired = true
+	restored, err = ParseRequirements(requirements.String())
+	require.NoError(t, err)
+	assert.Equal(t, requirements, restored)
+
+	requirements.BoundHeaders = []string{"content-type", "x-tenant"}
+	assert.Contains(t, requirements.String(), `binding="content-type x-tenant"`)
+	restored, err = ParseRequirements(requirements.String())
+	require.NoError(t, err)
+	assert.Equal(t, requirements, restored)
+
+	// unknown parameters are ignored for the sake of compatibility
+	restored, err = ParseRequirements("depth=1-2, color=blue")
+	require.NoError(t, err)
+	assert.Equal(t, Requirements{MinDepth: 1, MaxDepth: 2}, restored)
+
+	for _, malformed := range []string{"depth", "depth=12", "depth=a-b", "min-version=x", "hash=md5"} {
+		_, err := ParseRequirements(malformed)
+		assert.Error(t, err, malformed)
+	}
+}
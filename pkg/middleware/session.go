@@ -0,0 +1,261 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/evilaffliction/merkle/pkg/algo/merkle"
+)
+
+// SessionHeaderName represents a name for a header that carries a session token. A server sends a fresh token
+// in a response to a request with a valid proof of work, a client sends the token back instead of a proof of work
+const SessionHeaderName = "Merkle-Session"
+
+// SessionCookieName represents a name for a cookie that carries a session token, see WithSessionCookie
+const SessionCookieName = "merkle_session"
+
+// sessionPrefix distinguishes session tokens from access tokens and challenges
+const sessionPrefix = "s2."
+
+// sessionNonceSize is a number of random bytes in every session token
+const sessionNonceSize = 16
+
+// SessionKey is a secret that signs session tokens. Its ID is put into every token it signs,
+// so tokens of a previous key are accepted while keys are rotated. An ID must not be empty or contain dots,
+// a secret must not be empty
+type SessionKey struct {
+	ID     string
+	Secret []byte
+}
+
+// validateSessionKeys checks that every key can sign tokens that are restored by restoreSessionToken
+func validateSessionKeys(keys []SessionKey) error {
+	for _, key := range keys {
+		switch {
+		case key.ID == "":
+			return fmt.Errorf("session key ID should not be empty")
+		case strings.Contains(key.ID, "."):
+			return fmt.Errorf("session key ID %q should not contain dots", key.ID)
+		case len(key.Secret) == 0:
+			return fmt.Errorf("session key %q should have a secret", key.ID)
+		}
+	}
+	return nil
+}
+
+// sessionToken is a parsed session token:
+//
+//	s2.<key id>.<base64url scope>.<depth>.<proof leaves num>.<work factor>.<expires at micros>.<max uses>.
+//	<base64url nonce>.<base64url HMAC-SHA256>
+//
+// A scope is a route a token was bought for, see WithSessionScope, a depth, a proof leaves num and a work factor
+// are the ones of a proof of work that bought it. HMAC covers everything before the last dot
+type sessionToken struct {
+	keyID           string
+	scope           string
+	depth           int
+	proofLeavesNum  int
+	workFactor      int
+	expiresAtMicros int64
+	maxUses         int
+	nonce           []byte
+}
+
+func (rcv sessionToken) payload() string {
+	return fmt.Sprintf("%s%s.%s.%d.%d.%d.%d.%d.%s", sessionPrefix, rcv.keyID,
+		base64.RawURLEncoding.EncodeToString([]byte(rcv.scope)), rcv.depth, rcv.proofLeavesNum, rcv.workFactor,
+		rcv.expiresAtMicros, rcv.maxUses, base64.RawURLEncoding.EncodeToString(rcv.nonce))
+}
+
+func (rcv sessionToken) sign(secret []byte) string {
+	payload := rcv.payload()
+	return payload + "." + base64.RawURLEncoding.EncodeToString(challengeMAC(secret, payload))
+}
+
+// id identifies a session in a SessionStore
+func (rcv sessionToken) id() string {
+	return sessionPrefix + base64.RawURLEncoding.EncodeToString(rcv.nonce)
+}
+
+func (rcv sessionToken) expiresAt() time.Time {
+	return time.UnixMicro(rcv.expiresAtMicros)
+}
+
+// newSessionToken issues a session token of a scope signed with the current key of a config
+// for a proof of work of a given difficulty
+func newSessionToken(
+	cfg config,
+	scope string,
+	depth, proofLeavesNum, workFactor int,
+) (sessionToken, string, error) {
+	nonce := make([]byte, sessionNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return sessionToken{}, "", fmt.Errorf("failed to generate session nonce: %w", err)
+	}
+	key := cfg.sessionKeys[0]
+	token := sessionToken{
+		keyID:           key.ID,
+		scope:           scope,
+		depth:           depth,
+		proofLeavesNum:  proofLeavesNum,
+		workFactor:      workFactor,
+		expiresAtMicros: time.Now().Add(cfg.sessionLifeTime).UnixMicro(),
+		maxUses:         cfg.sessionMaxUses,
+		nonce:           nonce,
+	}
+	return token, token.sign(key.Secret), nil
+}
+
+// restoreSessionToken parses a session token and checks its signature with a key of its ID
+func restoreSessionToken(s string, keys []SessionKey) (sessionToken, error) {
+	if !strings.HasPrefix(s, sessionPrefix) {
+		return sessionToken{}, fmt.Errorf("session token is expected to start with %q", sessionPrefix)
+	}
+	lastDot := strings.LastIndexByte(s, '.')
+	payload, encodedMAC := s[:lastDot], s[lastDot+1:]
+	parts := strings.Split(strings.TrimPrefix(payload, sessionPrefix), ".")
+	if len(parts) != 8 {
+		return sessionToken{}, fmt.Errorf("session token is expected to have 8 parts, actual number %d", len(parts))
+	}
+
+	var secret []byte
+	for _, key := range keys {
+		if key.ID == parts[0] {
+			secret = key.Secret
+			break
+		}
+	}
+	if secret == nil {
+		return sessionToken{}, fmt.Errorf("session key %q is unknown", parts[0])
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil {
+		return sessionToken{}, fmt.Errorf("failed to decode session token signature: %w", err)
+	}
+	if !hmac.Equal(mac, challengeMAC(secret, payload)) {
+		return sessionToken{}, fmt.Errorf("session token signature is invalid")
+	}
+
+	result := sessionToken{keyID: parts[0]}
+	scope, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return sessionToken{}, fmt.Errorf("failed to decode session token scope: %w", err)
+	}
+	result.scope = string(scope)
+	for i, field := range []*int{&result.depth, &result.proofLeavesNum, &result.workFactor} {
+		if *field, err = strconv.Atoi(parts[i+2]); err != nil {
+			return sessionToken{}, fmt.Errorf("failed to parse session token difficulty: %w", err)
+		}
+	}
+	if result.expiresAtMicros, err = strconv.ParseInt(parts[5], 10, 64); err != nil {
+		return sessionToken{}, fmt.Errorf("failed to parse session token expiration: %w", err)
+	}
+	if result.maxUses, err = strconv.Atoi(parts[6]); err != nil {
+		return sessionToken{}, fmt.Errorf("failed to parse session token max uses: %w", err)
+	}
+	if result.nonce, err = base64.RawURLEncoding.DecodeString(parts[7]); err != nil {
+		return sessionToken{}, fmt.Errorf("failed to decode session token nonce: %w", err)
+	}
+	return result, nil
+}
+
+// sessionTokenOf returns a session token of a request, a header takes precedence over a cookie
+func sessionTokenOf(req *http.Request, cfg config) string {
+	if token := req.Header.Get(SessionHeaderName); token != "" {
+		return token
+	}
+	if cfg.sessionCookie {
+		if cookie, err := req.Cookie(SessionCookieName); err == nil {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// sessionScope returns a scope of session tokens of a request, it is a path of a request by default
+func sessionScope(req *http.Request, cfg config) string {
+	if cfg.sessionScope != "" {
+		return cfg.sessionScope
+	}
+	return req.URL.Path
+}
+
+// validateSession checks that a session token was bought for a scope of a request with a proof of work
+// that satisfies current requirements and spends one of its uses
+func validateSession(
+	req *http.Request,
+	tokenStr string,
+	requirements Requirements,
+	store SessionStore,
+	cfg config,
+) error {
+	token, err := restoreSessionToken(tokenStr, cfg.sessionKeys)
+	if err != nil {
+		return newVerificationError(ErrorCodeInvalidSession, "failed to restore session token: %w", err)
+	}
+	ttl := time.Until(token.expiresAt())
+	if ttl <= 0 {
+		return newVerificationError(ErrorCodeExpired, "session token is expired")
+	}
+	if token.scope != sessionScope(req, cfg) {
+		return newVerificationError(ErrorCodeInvalidSession, "session token was issued for another route")
+	}
+	// requirements may be raised since a token was issued
+	if token.depth < requirements.MinDepth {
+		return newVerificationError(ErrorCodeTooEasy, "session token was bought with depth %d, min allowed: %d",
+			token.depth, requirements.MinDepth)
+	}
+	if token.proofLeavesNum < requirements.MinProofLeavesNum {
+		return newVerificationError(ErrorCodeTooEasy,
+			"session token was bought with proof leaves num %d, min allowed: %d",
+			token.proofLeavesNum, requirements.MinProofLeavesNum)
+	}
+	if token.workFactor < requirements.MinWorkFactor {
+		return newVerificationError(ErrorCodeTooEasy, "session token was bought with work factor %d, min allowed: %d",
+			token.workFactor, requirements.MinWorkFactor)
+	}
+
+	uses, err := store.Use(req.Context(), token.id(), ttl)
+	if err != nil {
+		return fmt.Errorf("failed to count session token uses, error: %w", err)
+	}
+	if uses > int64(token.maxUses) {
+		return newVerificationError(ErrorCodeSessionExhausted, "session token was used %d times, max allowed: %d",
+			uses-1, token.maxUses)
+	}
+	return nil
+}
+
+// issueSession sends a fresh session token to a client that has just proven its work.
+// A request with a valid proof of work is served even if a token can not be issued
+func issueSession(header http.Header, req *http.Request, pow merkle.ProofOfWork, cfg config) {
+	scope := sessionScope(req, cfg)
+	token, tokenStr, err := newSessionToken(cfg, scope, pow.Depth(), pow.ProofLeavesNum(), pow.WorkFactor())
+	if err != nil {
+		return
+	}
+	header.Set(SessionHeaderName, tokenStr)
+	if cfg.sessionCookie {
+		// a token of a path is useless for other paths, a named scope may be shared by any routes
+		cookiePath := "/"
+		if cfg.sessionScope == "" {
+			cookiePath = scope
+		}
+		cookie := &http.Cookie{
+			Name:     SessionCookieName,
+			Value:    tokenStr,
+			Path:     cookiePath,
+			Expires:  token.expiresAt(),
+			Secure:   req.TLS != nil,
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		}
+		header.Add("Set-Cookie", cookie.String())
+	}
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bluele/gcache"
+)
+
+// SessionStore counts uses of session tokens, see WithSessionKeys.
+// Several servers behind a load balancer should share a store, e.g. a RedisReplayStore
+type SessionStore interface {
+	// Use increments a counter of a session and returns its new value. A counter starts with 1
+	// and lives for at least "ttl" after its first use. It has to be atomic
+	Use(ctx context.Context, id string, ttl time.Duration) (int64, error)
+}
+
+// GCacheSessionStore is an in-process SessionStore. When it is full, counters of the least recently used
+// sessions are forgotten, so their tokens get spare uses. Every session costs a proof of work though
+type GCacheSessionStore struct {
+	mu    sync.Mutex
+	cache gcache.Cache
+}
+
+// confirm interfaces' implementation
+var _ SessionStore = (*GCacheSessionStore)(nil)
+
+// NewGCacheSessionStore creates a GCacheSessionStore of a given size
+func NewGCacheSessionStore(size int) *GCacheSessionStore {
+	return &GCacheSessionStore{
+		cache: gcache.New(size).LRU().Build(),
+	}
+}
+
+// Use implements SessionStore interface
+func (rcv *GCacheSessionStore) Use(_ context.Context, id string, ttl time.Duration) (int64, error) {
+	// a lock makes a read and an increment to be atomic
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	value, err := rcv.cache.Get(id)
+	switch {
+	case errors.Is(err, gcache.KeyNotFoundError):
+		uses := int64(1)
+		if err := rcv.cache.SetWithExpire(id, &uses, ttl); err != nil {
+			return 0, fmt.Errorf("failed to set cache, error: %w", err)
+		}
+		return uses, nil
+	case err != nil:
+		return 0, fmt.Errorf("failed to get session from cache, error: %w", err)
+	}
+	// a counter is modified in place to keep its expiration
+	uses := value.(*int64)
+	*uses++
+	return *uses, nil
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSessionStore checks a contract of SessionStore
+func testSessionStore(t *testing.T, store SessionStore) {
+	ctx := context.Background()
+
+	for expected := int64(1); expected <= 3; expected++ {
+		uses, err := store.Use(ctx, "session", time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, expected, uses)
+	}
+
+	uses, err := store.Use(ctx, "short-lived-session", 50*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), uses)
+	// a counter keeps an expiration of its first use
+	_, err = store.Use(ctx, "short-lived-session", time.Minute)
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	uses, err = store.Use(ctx, "short-lived-session", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), uses, "an expired session is forgotten")
+
+	// concurrent uses get distinct values
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var values []int64
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			uses, err := store.Use(ctx, "concurrent-session", time.Minute)
+			assert.NoError(t, err)
+			mu.Lock()
+			values = append(values, uses)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	slices.Sort(values)
+	for i, value := range values {
+		assert.Equal(t, int64(i+1), value)
+	}
+}
+
+func TestGCacheSessionStore(t *testing.T) {
+	testSessionStore(t, NewGCacheSessionStore(100))
+}
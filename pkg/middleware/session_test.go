@@ -0,0 +1,312 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionToken(t *testing.T) {
+	oldKey := SessionKey{ID: "k1", Secret: []byte("old secret")}
+	newKey := SessionKey{ID: "k2", Secret: []byte("new secret")}
+	cfg := newConfigFromOptions(WithSessionKeys(oldKey), WithSessionLimits(7, time.Minute))
+	token, tokenStr, err := newSessionToken(cfg, "/ping", 11, 3, 2)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(tokenStr, "s2.k1."))
+	assert.Equal(t, "/ping", token.scope)
+	assert.Equal(t, 11, token.depth)
+	assert.Equal(t, 3, token.proofLeavesNum)
+	assert.Equal(t, 2, token.workFactor)
+	assert.Equal(t, 7, token.maxUses)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), token.expiresAt(), time.Second)
+
+	restored, err := restoreSessionToken(tokenStr, []SessionKey{oldKey})
+	require.NoError(t, err)
+	assert.Equal(t, token, restored)
+
+	t.Run("Previous_key_is_accepted", func(t *testing.T) {
+		restored, err := restoreSessionToken(tokenStr, []SessionKey{newKey, oldKey})
+		require.NoError(t, err)
+		assert.Equal(t, token, restored)
+
+		_, err = restoreSessionToken(tokenStr, []SessionKey{newKey})
+		assert.ErrorContains(t, err, "unknown")
+		_, err = restoreSessionToken(tokenStr, []SessionKey{{ID: "k1", Secret: []byte("forged")}})
+		assert.ErrorContains(t, err, "signature")
+	})
+
+	t.Run("Invalid_keys_are_refused", func(t *testing.T) {
+		for _, invalid := range []SessionKey{
+			{ID: "", Secret: []byte("secret")},
+			{ID: "k.1", Secret: []byte("secret")},
+			{ID: "k1"},
+		} {
+			assert.Panics(t, func() {
+				NewMerkleMiddleware(WithSessionKeys(oldKey, invalid))
+			}, invalid.ID)
+		}
+		assert.NotPanics(t, func() {
+			NewMerkleMiddleware(WithSessionKeys(newKey, oldKey))
+		})
+	})
+
+	t.Run("Malformed_tokens_are_rejected", func(t *testing.T) {
+		forged := token
+		forged.maxUses = 1000
+		for _, malformed := range []string{
+			"",
+			"c1.1.2.3",
+			"s2.k1.1.2.3",
+			strings.Replace(tokenStr, ".7.", ".1000.", 1),
+			strings.Replace(tokenStr, ".11.", ".20.", 1),
+			forged.sign([]byte("forged")),
+		} {
+			_, err := restoreSessionToken(malformed, []SessionKey{oldKey})
+			assert.Error(t, err, malformed)
+		}
+	})
+}
+
+func TestMerkleSessions(t *testing.T) {
+	key := SessionKey{ID: "k1", Secret: []byte("secret")}
+	newEngine := func(opts ...Option) *gin.Engine {
+		opts = append([]Option{
+			WithAllowedDepthRange(10, 12),
+			WithSessionKeys(key),
+			WithSessionLimits(2, time.Minute),
+			WithSessionMinDepth(11),
+		}, opts...)
+		r := gin.New()
+		r.GET("/ping", NewMerkleMiddleware(opts...).Handler(), func(c *gin.Context) {
+			c.String(200, "pong")
+		})
+		return r
+	}
+	ping := func(r *gin.Engine, setup func(req *http.Request)) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/ping", nil)
+		setup(req)
+		r.ServeHTTP(w, req)
+		return w
+	}
+	withProof := func(depth int) func(req *http.Request) {
+		return func(req *http.Request) {
+			headerPayload, err := GenerateMerkleHeader(depth, 3, "md5")
+			require.NoError(t, err)
+			req.Header.Set(MerkleHeaderName, headerPayload)
+		}
+	}
+	withSession := func(token string) func(req *http.Request) {
+		return func(req *http.Request) {
+			req.Header.Set(SessionHeaderName, token)
+		}
+	}
+	errorCode := func(w *httptest.ResponseRecorder) ErrorCode {
+		var problem Problem
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+		return problem.Code
+	}
+
+	t.Run("Session_is_bought_by_heavier_proof_of_work", func(t *testing.T) {
+		r := newEngine()
+		w := ping(r, withProof(10))
+		require.Equal(t, 200, w.Code)
+		assert.Empty(t, w.Header().Get(SessionHeaderName), "a light proof of work buys a single request")
+
+		w = ping(r, withProof(11))
+		require.Equal(t, 200, w.Code)
+		token := w.Header().Get(SessionHeaderName)
+		require.NotEmpty(t, token)
+
+		for i := 0; i < 2; i++ {
+			w = ping(r, withSession(token))
+			assert.Equal(t, 200, w.Code)
+			assert.Empty(t, w.Header().Get(SessionHeaderName))
+		}
+		w = ping(r, withSession(token))
+		assert.Equal(t, 406, w.Code)
+		assert.Equal(t, ErrorCodeSessionExhausted, errorCode(w))
+		assert.NotEmpty(t, w.Header().Get(RequirementsHeaderName))
+
+		// a proof of work takes precedence over an exhausted session token
+		w = ping(r, func(req *http.Request) {
+			withSession(token)(req)
+			withProof(11)(req)
+		})
+		assert.Equal(t, 200, w.Code)
+		assert.NotEqual(t, token, w.Header().Get(SessionHeaderName))
+	})
+
+	t.Run("Invalid_sessions_are_rejected", func(t *testing.T) {
+		r := newEngine()
+		_, expired, err := newSessionToken(newConfigFromOptions(WithSessionKeys(key),
+			WithSessionLimits(2, -time.Second)), "/ping", 11, 3, 1)
+		require.NoError(t, err)
+		_, foreign, err := newSessionToken(newConfigFromOptions(WithSessionKeys(SessionKey{ID: "k1",
+			Secret: []byte("other secret")})), "/ping", 11, 3, 1)
+		require.NoError(t, err)
+		_, otherRoute, err := newSessionToken(newConfigFromOptions(WithSessionKeys(key)), "/pong", 11, 3, 1)
+		require.NoError(t, err)
+		_, light, err := newSessionToken(newConfigFromOptions(WithSessionKeys(key)), "/ping", 9, 3, 1)
+		require.NoError(t, err)
+		for _, testCase := range []struct {
+			token string
+			code  ErrorCode
+		}{
+			{"s2.junk", ErrorCodeInvalidSession},
+			{foreign, ErrorCodeInvalidSession},
+			{expired, ErrorCodeExpired},
+			{otherRoute, ErrorCodeInvalidSession},
+			{light, ErrorCodeTooEasy},
+		} {
+			w := ping(r, withSession(testCase.token))
+			assert.Equal(t, 406, w.Code)
+			assert.Equal(t, testCase.code, errorCode(w))
+		}
+	})
+
+	t.Run("Raised_requirements_reject_sessions", func(t *testing.T) {
+		w := ping(newEngine(), withProof(11))
+		require.Equal(t, 200, w.Code)
+		token := w.Header().Get(SessionHeaderName)
+		require.NotEmpty(t, token)
+
+		for name, raised := range map[string]Option{
+			"depth":            WithAllowedDepthRange(12, 12),
+			"proof_leaves_num": WithAllowedProofLeavesNum(4, 10),
+			"work_factor":      WithAllowedWorkFactorRange(2, 4),
+		} {
+			w = ping(newEngine(raised), withSession(token))
+			assert.Equal(t, 406, w.Code, name)
+			assert.Equal(t, ErrorCodeTooEasy, errorCode(w), name)
+		}
+		assert.Equal(t, 200, ping(newEngine(), withSession(token)).Code)
+	})
+
+	t.Run("Keys_are_rotated", func(t *testing.T) {
+		w := ping(newEngine(), withProof(11))
+		require.Equal(t, 200, w.Code)
+		token := w.Header().Get(SessionHeaderName)
+
+		rotated := newEngine(WithSessionKeys(SessionKey{ID: "k2", Secret: []byte("new secret")}, key))
+		w = ping(rotated, withSession(token))
+		assert.Equal(t, 200, w.Code)
+		w = ping(rotated, withProof(11))
+		require.Equal(t, 200, w.Code)
+		assert.True(t, strings.HasPrefix(w.Header().Get(SessionHeaderName), "s2.k2."))
+	})
+
+	t.Run("Cookies", func(t *testing.T) {
+		r := newEngine(WithSessionCookie())
+		w := ping(r, withProof(11))
+		require.Equal(t, 200, w.Code)
+		cookies := w.Result().Cookies()
+		require.Len(t, cookies, 1)
+		assert.Equal(t, SessionCookieName, cookies[0].Name)
+		assert.Equal(t, w.Header().Get(SessionHeaderName), cookies[0].Value)
+		assert.True(t, cookies[0].HttpOnly)
+
+		w = ping(r, func(req *http.Request) {
+			req.AddCookie(cookies[0])
+		})
+		assert.Equal(t, 200, w.Code)
+		w = ping(newEngine(), func(req *http.Request) {
+			req.AddCookie(cookies[0])
+		})
+		assert.Equal(t, ErrorCodeMissingHeader, errorCode(w), "cookies are ignored by default")
+	})
+
+	t.Run("Sessions_are_scoped_to_routes", func(t *testing.T) {
+		merkleMiddleware := NewMerkleMiddleware(WithAllowedDepthRange(10, 12), WithSessionKeys(key),
+			WithSessionMinDepth(11))
+		pong := func(c *gin.Context) {
+			c.String(200, "pong")
+		}
+		r := gin.New()
+		r.GET("/ping", merkleMiddleware.Handler(), pong)
+		r.GET("/strict", merkleMiddleware.Handler(WithAllowedDepthRange(12, 12)), pong)
+		r.GET("/shared/ping", merkleMiddleware.Handler(WithSessionScope("shared")), pong)
+		r.GET("/shared/strict", merkleMiddleware.Handler(WithSessionScope("shared"), WithAllowedDepthRange(12, 12)),
+			pong)
+		request := func(path string, setup func(req *http.Request)) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", path, nil)
+			setup(req)
+			r.ServeHTTP(w, req)
+			return w
+		}
+
+		w := request("/ping", withProof(11))
+		require.Equal(t, 200, w.Code)
+		token := w.Header().Get(SessionHeaderName)
+		w = request("/strict", withSession(token))
+		assert.Equal(t, 406, w.Code)
+		assert.Equal(t, ErrorCodeInvalidSession, errorCode(w), "a token of a cheap route is not accepted by another one")
+
+		w = request("/shared/ping", withProof(11))
+		require.Equal(t, 200, w.Code)
+		token = w.Header().Get(SessionHeaderName)
+		assert.Equal(t, 200, request("/shared/ping", withSession(token)).Code)
+		w = request("/shared/strict", withSession(token))
+		assert.Equal(t, 406, w.Code)
+		assert.Equal(t, ErrorCodeTooEasy, errorCode(w), "a shared token has to satisfy requirements of a route")
+
+		w = request("/shared/strict", withProof(12))
+		require.Equal(t, 200, w.Code)
+		token = w.Header().Get(SessionHeaderName)
+		assert.Equal(t, 200, request("/shared/ping", withSession(token)).Code)
+	})
+
+	t.Run("Strict_routes_have_no_sessions", func(t *testing.T) {
+		r := newEngine(WithRequestBinding())
+		bound := func(req *http.Request) {
+			binding, err := RequestBinding(req)
+			require.NoError(t, err)
+			headerPayload, err := GenerateMerkleHeader(11, 3, "md5", WithBinding(binding))
+			require.NoError(t, err)
+			req.Header.Set(MerkleHeaderName, headerPayload)
+		}
+		w := ping(r, bound)
+		require.Equal(t, 200, w.Code)
+		assert.Empty(t, w.Header().Get(SessionHeaderName))
+
+		// a token of a route without binding is not accepted either
+		w = ping(newEngine(), withProof(11))
+		require.Equal(t, 200, w.Code)
+		w = ping(r, withSession(w.Header().Get(SessionHeaderName)))
+		assert.Equal(t, ErrorCodeMissingHeader, errorCode(w))
+
+		w = ping(newEngine(WithRequestBinding(), WithSessionsOnStrictRoutes()), bound)
+		require.Equal(t, 200, w.Code)
+		assert.NotEmpty(t, w.Header().Get(SessionHeaderName))
+	})
+
+	t.Run("Min_depth_has_to_be_chosen", func(t *testing.T) {
+		r := gin.New()
+		r.GET("/ping", GetMerkleMiddleware(WithAllowedDepthRange(10, 12), WithSessionKeys(key)), func(c *gin.Context) {
+			c.String(200, "pong")
+		})
+		for _, depth := range []int{10, 12} {
+			w := ping(r, withProof(depth))
+			assert.Equal(t, 200, w.Code)
+			assert.Empty(t, w.Header().Get(SessionHeaderName), "a proof of work of depth %d buys no session", depth)
+		}
+	})
+
+	t.Run("Sessions_are_disabled_by_default", func(t *testing.T) {
+		r := gin.New()
+		r.GET("/ping", GetMerkleMiddleware(WithAllowedDepthRange(10, 12)), func(c *gin.Context) {
+			c.String(200, "pong")
+		})
+		w := ping(r, withProof(11))
+		assert.Equal(t, 200, w.Code)
+		assert.Empty(t, w.Header().Get(SessionHeaderName))
+	})
+}
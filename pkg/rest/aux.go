@@ -10,7 +10,10 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// EndpointSecurityResponse writes an error to a response and aborts further computation
+// EndpointSecurityResponse writes an error to a response and aborts further computation.
+// The middleware no longer uses it, it is kept for backwards compatibility.
+//
+// Deprecated: use ProblemResponse
 func EndpointSecurityResponse(ctx *gin.Context, err error) {
 	ctx.String(http.StatusNotAcceptable, err.Error())
 	ctx.Abort()
@@ -20,21 +23,59 @@ func EndpointSecurityResponse(ctx *gin.Context, err error) {
 // to a response with no husstle
 func EndpointWrapper(caller func(ctx *gin.Context) (any, error)) func(ctx *gin.Context) {
 	return func(ctx *gin.Context) {
-		result, err := caller(ctx)
-		if err != nil {
-			ctx.String(http.StatusInternalServerError, err.Error())
-			return
-		}
-		if result == nil {
-			ctx.Writer.WriteHeader(http.StatusNoContent)
-			return
-		}
-		if strResult, ok := result.(string); ok {
-			ctx.String(http.StatusOK, strResult)
-			return
-		}
-		ctx.JSON(http.StatusOK, result)
+		writeResult(ctx.Writer, func() (any, error) {
+			return caller(ctx)
+		})
+	}
+}
+
+// ProblemResponse writes a problem details object of RFC 9457 to a response
+func ProblemResponse(w http.ResponseWriter, status int, problem any) {
+	writeJSON(w, status, "application/problem+json; charset=utf-8", problem)
+}
+
+// HandlerWrapper is EndpointWrapper for net/http handlers
+func HandlerWrapper(caller func(req *http.Request) (any, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeResult(w, func() (any, error) {
+			return caller(req)
+		})
+	}
+}
+
+// writeResult writes a result of a call as a plain text or a JSON
+func writeResult(w http.ResponseWriter, call func() (any, error)) {
+	result, err := call()
+	if err != nil {
+		writeString(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if result == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
 	}
+	if strResult, ok := result.(string); ok {
+		writeString(w, http.StatusOK, strResult)
+		return
+	}
+	writeJSON(w, http.StatusOK, "application/json; charset=utf-8", result)
+}
+
+func writeString(w http.ResponseWriter, status int, data string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = io.WriteString(w, data)
+}
+
+func writeJSON(w http.ResponseWriter, status int, contentType string, obj any) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		writeString(w, http.StatusInternalServerError, fmt.Sprintf("failed to marshal json response, error: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
 }
 
 type contentType int
@@ -43,6 +84,7 @@ const (
 	undefinedContentType contentType = 0
 	stringContentType    contentType = 1
 	jsonContentType      contentType = 2
+	problemContentType   contentType = 3
 )
 
 func getContentType(contentTypeHeader []string) contentType {
@@ -54,6 +96,8 @@ func getContentType(contentTypeHeader []string) contentType {
 		return stringContentType
 	case strings.HasPrefix(contentTypeHeader[0], "application/json;"):
 		return jsonContentType
+	case strings.HasPrefix(contentTypeHeader[0], "application/problem+json;"):
+		return problemContentType
 	default:
 		return undefinedContentType
 
@@ -70,8 +114,35 @@ func getData(body io.ReadCloser) ([]byte, error) {
 	return data, nil
 }
 
-// ReadResponse allows to easily extract a response that was generated by EndpointSecurityResponse or EndpointWrapper
-// functions
+// problem contains the members of a problem details object that are common for all the problems
+type problem struct {
+	Title  string `json:"title"`
+	Detail string `json:"detail"`
+	Code   string `json:"code"`
+}
+
+// readProblem converts a problem details object to an error
+func readProblem(body io.ReadCloser) error {
+	data, err := getData(body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body, error: %w", err)
+	}
+	var result problem
+	if err := json.Unmarshal(data, &result); err != nil {
+		return fmt.Errorf("failed to unmarshal problem details, error: %w", err)
+	}
+	message := result.Title
+	if result.Code != "" {
+		message = fmt.Sprintf("%s (%s)", message, result.Code)
+	}
+	if result.Detail != "" {
+		message = fmt.Sprintf("%s: %s", message, result.Detail)
+	}
+	return fmt.Errorf("%s", message)
+}
+
+// ReadResponse allows to easily extract a response that was generated by ProblemResponse,
+// EndpointWrapper, HandlerWrapper or EndpointSecurityResponse of older servers
 func ReadResponse(resp *http.Response, output any) error {
 	contentTypeHeader := resp.Header["Content-Type"]
 	contentType := getContentType(contentTypeHeader)
@@ -80,6 +151,9 @@ func ReadResponse(resp *http.Response, output any) error {
 	}
 
 	switch {
+	case contentType == problemContentType:
+		return readProblem(resp.Body)
+
 	case resp.StatusCode == http.StatusOK:
 		data, err := getData(resp.Body)
 		if err != nil {